@@ -6,6 +6,8 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"strings"
 )
 
@@ -21,27 +23,219 @@ type AtomType int
 const (
 	// AtomType_Nil represents the empty list.
 	AtomType_Nil AtomType = iota
+	// AtomType_Bignum is an exact integer too large to fit in an int.
+	AtomType_Bignum
+	// AtomType_Boolean is #t or #f.
+	AtomType_Boolean
 	// AtomType_Builtin is a native function.
 	AtomType_Builtin
+	// AtomType_Char is a Unicode character.
+	AtomType_Char
 	// AtomType_Closure is a closure.
 	AtomType_Closure
-	// AtomType_Integer is a number.
+	// AtomType_Condition is an error object raised by ERROR or by a builtin.
+	AtomType_Condition
+	// AtomType_Continuation is a continuation captured by CALL/CC.
+	AtomType_Continuation
+	// AtomType_Float is an inexact number.
+	AtomType_Float
+	// AtomType_HashTable is a table of keys and values.
+	AtomType_HashTable
+	// AtomType_Integer is an exact number.
 	AtomType_Integer
+	// AtomType_Local is a reference to a local variable in the body of a
+	// closure created by the evaluator. it is never seen by programs.
+	AtomType_Local
 	// AtomType_Macro is a macro.
 	AtomType_Macro
 	// AtomType_Pair is a "cons" cell holding a "car" and "cdr" pointer.
 	AtomType_Pair
+	// AtomType_Rational is an exact fraction in lowest terms.
+	AtomType_Rational
+	// AtomType_String is an immutable string of characters.
+	AtomType_String
 	// AtomType_Symbol is a string of characters, converted to upper-case.
 	AtomType_Symbol
+	// AtomType_Syntax is a macro defined with SYNTAX-RULES.
+	AtomType_Syntax
+	// AtomType_Vector is a fixed-length array of atoms.
+	AtomType_Vector
 )
 
+// String implements the Stringer interface.
+// It returns the name of the type, which is used in error messages.
+func (t AtomType) String() string {
+	switch t {
+	case AtomType_Nil:
+		return "nil"
+	case AtomType_Bignum:
+		return "bignum"
+	case AtomType_Boolean:
+		return "boolean"
+	case AtomType_Builtin:
+		return "builtin"
+	case AtomType_Char:
+		return "char"
+	case AtomType_Closure:
+		return "closure"
+	case AtomType_Condition:
+		return "condition"
+	case AtomType_Continuation:
+		return "continuation"
+	case AtomType_Float:
+		return "float"
+	case AtomType_HashTable:
+		return "hash-table"
+	case AtomType_Integer:
+		return "integer"
+	case AtomType_Local:
+		return "local"
+	case AtomType_Macro:
+		return "macro"
+	case AtomType_Pair:
+		return "pair"
+	case AtomType_Rational:
+		return "rational"
+	case AtomType_String:
+		return "string"
+	case AtomType_Symbol:
+		return "symbol"
+	case AtomType_Syntax:
+		return "syntax"
+	case AtomType_Vector:
+		return "vector"
+	}
+	return fmt.Sprintf("AtomType(%d)", int(t))
+}
+
 // AtomValue is the value of an Atom.
-// It can be a simple type, like an integer or symbol, or a pointer to a Pair.
+// It can be a simple type, like an integer or character, which is kept
+// in n, or a pointer to an object, like a Pair or Symbol, which is kept
+// in ref. Keeping it small keeps Atoms and Pairs small, which matters
+// because every cons copies two of them.
+//
+// The accessors, like pair() and integer(), return the value as the type
+// that the Atom's _type says it is. They return the zero value if the
+// Atom is some other type.
 type AtomValue struct {
-	builtin *Builtin
-	integer int
-	pair    *Pair
-	symbol  *Symbol
+	// ref is the object for types that have one.
+	ref any
+	// n is an integer or character, 1 for #t or 0 for #f,
+	// the bits of a float, or the depth and index of a local.
+	n int64
+}
+
+// eval_closure is the value of a closure created by the evaluator.
+type eval_closure struct {
+	lambda *eval_lambda
+	env    Atom
+}
+
+// vm_closure is the value of a closure created by the virtual machine.
+type vm_closure struct {
+	lambda *Lambda
+	env    *vm_env
+}
+
+func (v AtomValue) bignum() *big.Int {
+	x, _ := v.ref.(*big.Int)
+	return x
+}
+
+func (v AtomValue) boolean() bool {
+	return v.n != 0
+}
+
+func (v AtomValue) builtin() *Builtin {
+	x, _ := v.ref.(*Builtin)
+	return x
+}
+
+func (v AtomValue) char() rune {
+	return rune(v.n)
+}
+
+// closure returns a closure created by the evaluator. it returns nil
+// for a closure created by the virtual machine.
+func (v AtomValue) closure() *eval_closure {
+	x, _ := v.ref.(*eval_closure)
+	return x
+}
+
+func (v AtomValue) condition() *Condition {
+	x, _ := v.ref.(*Condition)
+	return x
+}
+
+func (v AtomValue) continuation() *Continuation {
+	x, _ := v.ref.(*Continuation)
+	return x
+}
+
+// env returns the environment of a closure created by the virtual machine.
+func (v AtomValue) env() *vm_env {
+	if x, ok := v.ref.(*vm_closure); ok {
+		return x.env
+	}
+	return nil
+}
+
+func (v AtomValue) float() float64 {
+	return math.Float64frombits(uint64(v.n))
+}
+
+func (v AtomValue) integer() int {
+	return int(v.n)
+}
+
+// lambda returns the compiled form of a closure created by the virtual
+// machine. it returns nil for a closure created by the evaluator.
+func (v AtomValue) lambda() *Lambda {
+	if x, ok := v.ref.(*vm_closure); ok {
+		return x.lambda
+	}
+	return nil
+}
+
+// local returns the depth of the frame and the index of the slot
+// that a local refers to.
+func (v AtomValue) local() (depth, index int) {
+	return int(v.n >> 32), int(uint32(v.n))
+}
+
+func (v AtomValue) pair() *Pair {
+	x, _ := v.ref.(*Pair)
+	return x
+}
+
+func (v AtomValue) rational() *big.Rat {
+	x, _ := v.ref.(*big.Rat)
+	return x
+}
+
+func (v AtomValue) str() *String {
+	x, _ := v.ref.(*String)
+	return x
+}
+
+func (v AtomValue) symbol() *Symbol {
+	x, _ := v.ref.(*Symbol)
+	return x
+}
+
+func (v AtomValue) syntax() *Syntax {
+	x, _ := v.ref.(*Syntax)
+	return x
+}
+
+func (v AtomValue) table() *HashTable {
+	x, _ := v.ref.(*HashTable)
+	return x
+}
+
+func (v AtomValue) vector() *Vector {
+	x, _ := v.ref.(*Vector)
+	return x
 }
 
 // Bytes implements the Byter interface.
@@ -70,12 +264,45 @@ func (a Atom) Write(w io.Writer) (int, error) {
 	case AtomType_Nil:
 		// atom is nil, so write "NIL"
 		return w.Write([]byte{'N', 'I', 'L'})
+	case AtomType_Bignum:
+		// atom is a large integer
+		return w.Write([]byte(a.value.bignum().String()))
+	case AtomType_Boolean:
+		// atom is #t or #f
+		if a.value.boolean() {
+			return w.Write([]byte{'#', 't'})
+		}
+		return w.Write([]byte{'#', 'f'})
 	case AtomType_Builtin:
 		// atom is a native function
-		return w.Write([]byte(fmt.Sprintf("#<BUILTIN:%p>", a.value.builtin)))
+		return w.Write([]byte(fmt.Sprintf("#<BUILTIN:%p>", a.value.builtin())))
+	case AtomType_Char:
+		// atom is a character, so write it as a literal
+		return w.Write(write_char(a.value.char()))
+	case AtomType_Closure:
+		// atom is a closure
+		return w.Write([]byte("#<CLOSURE>"))
+	case AtomType_Condition:
+		// atom is an error object
+		return w.Write(write_condition(a.value.condition()))
+	case AtomType_Continuation:
+		// atom is a captured continuation
+		return w.Write([]byte(fmt.Sprintf("#<CONTINUATION:%p>", a.value.continuation())))
+	case AtomType_Float:
+		// atom is a float
+		return w.Write(write_float(a.value.float()))
+	case AtomType_HashTable:
+		// atom is a hash table
+		return w.Write(write_hash_table(a.value.table()))
 	case AtomType_Integer:
 		// atom is an integer
-		return w.Write([]byte(fmt.Sprintf("%d", a.value.integer)))
+		return w.Write([]byte(fmt.Sprintf("%d", a.value.integer())))
+	case AtomType_Local:
+		// atom is a reference to a local variable, so write its name
+		return w.Write(a.value.symbol().label)
+	case AtomType_Macro:
+		// atom is a macro defined with DEFMACRO
+		return w.Write([]byte("#<MACRO>"))
 	case AtomType_Pair:
 		// atom is a list, so write it out surrounded by ( and ).
 		totalBytesWritten, err := w.Write([]byte{'('})
@@ -133,8 +360,20 @@ func (a Atom) Write(w io.Writer) (int, error) {
 
 		// and return
 		return totalBytesWritten, err
+	case AtomType_Rational:
+		// atom is a fraction, written as n/d
+		return w.Write([]byte(a.value.rational().String()))
+	case AtomType_String:
+		// atom is a string, so write it as a quoted literal
+		return w.Write(write_string(a.value.str().text))
 	case AtomType_Symbol:
-		return w.Write(a.value.symbol.label)
+		return w.Write(a.value.symbol().label)
+	case AtomType_Syntax:
+		// atom is a SYNTAX-RULES macro
+		return w.Write([]byte(fmt.Sprintf("#<SYNTAX:%p>", a.value.syntax())))
+	case AtomType_Vector:
+		// atom is a vector, so write it out surrounded by #( and ).
+		return write_vector(w, a.value.vector())
 	}
 
 	panic(fmt.Sprintf("assert(_type != %d)", a._type))
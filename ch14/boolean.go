@@ -0,0 +1,61 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lisp
+
+import "bytes"
+
+// booleans are written #t and #f, or #true and #false.
+// only #f is false, so NIL and the empty list are true, as in Scheme.
+// the compatibility mode treats NIL as false too, which is how the
+// interpreter behaved before it had booleans.
+
+// WithNilFalse returns an option that makes NIL false as well as #f,
+// for programs written before the interpreter had booleans.
+func WithNilFalse() Option {
+	return func(l *Interpreter) {
+		l.nil_false = true
+	}
+}
+
+// make_boolean returns #t if b is true and #f otherwise.
+func make_boolean(b bool) Atom {
+	if b {
+		return _true
+	}
+	return _false
+}
+
+// falsep returns true if the atom is false.
+// #f is always false; NIL is false only in the compatibility mode.
+func (l *Interpreter) falsep(atom Atom) bool {
+	if atom._type == AtomType_Boolean {
+		return !atom.value.boolean()
+	}
+	return l.nil_false && nilp(atom)
+}
+
+// read_boolean reads a boolean from the input.
+// it returns false if the input is not a boolean.
+// note that the result is not updated unless it is a boolean.
+func read_boolean(input []byte, result *Atom) bool {
+	if bytes.EqualFold(input, []byte("#t")) || bytes.EqualFold(input, []byte("#true")) {
+		*result = _true
+		return true
+	} else if bytes.EqualFold(input, []byte("#f")) || bytes.EqualFold(input, []byte("#false")) {
+		*result = _false
+		return true
+	}
+	return false
+}
+
+// builtin_booleanp returns #t if the argument is a boolean.
+// note that the result may not be updated if we find errors.
+func builtin_booleanp(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	}
+
+	*result = make_boolean(car(args)._type == AtomType_Boolean)
+	return nil
+}
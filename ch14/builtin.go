@@ -2,13 +2,18 @@
 
 package lisp
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
 
 // Builtin is a helper for calling a native Go function.
 // We define a struct around it so that we can do
 // pointer comparisons for equality in other parts of this package.
 type Builtin struct {
-	fn Native
+	fn   Native
+	mark uint32 // used by the garbage collector
 }
 
 // Native is a function in Go that can evaluate expressions.
@@ -17,17 +22,24 @@ type Native func(args Atom, result *Atom) error
 // builtin_add implements a function for calculating the sum of two numbers.
 // note that the result may not be updated if we find errors.
 func builtin_add(args Atom, result *Atom) error {
-	// verify number and type of arguments
-	if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
-		return Error_Args
-	}
-	a, b := car(args), car(cdr(args))
-	if a._type != AtomType_Integer || b._type != AtomType_Integer {
-		return Error_Type
-	}
-
-	*result = make_int(a.value.integer + b.value.integer)
-	return nil
+	return arith(args, result, arith_op{
+		integer: func(a, b int) (Atom, error) {
+			if c := a + b; (c > a) == (b > 0) {
+				return make_int(c), nil
+			}
+			// overflow, so promote to a bignum
+			return make_bignum(new(big.Int).Add(big.NewInt(int64(a)), big.NewInt(int64(b)))), nil
+		},
+		bignum: func(a, b *big.Int) (Atom, error) {
+			return make_bignum(new(big.Int).Add(a, b)), nil
+		},
+		rational: func(a, b *big.Rat) (Atom, error) {
+			return make_rational(new(big.Rat).Add(a, b)), nil
+		},
+		float: func(a, b float64) (Atom, error) {
+			return make_float(a + b), nil
+		},
+	})
 }
 
 // builtin_car makes our native car function available to the interpreter.
@@ -35,9 +47,9 @@ func builtin_add(args Atom, result *Atom) error {
 func builtin_car(args Atom, result *Atom) error {
 	// verify number and type of arguments
 	if nilp(args) || !nilp(cdr(args)) {
-		return Error_Args
+		return error_args("1", args)
 	} else if car(args)._type != AtomType_Pair {
-		return Error_Type
+		return error_type("pair", car(args))
 	}
 
 	if nilp(car(args)) {
@@ -53,9 +65,9 @@ func builtin_car(args Atom, result *Atom) error {
 func builtin_cdr(args Atom, result *Atom) error {
 	// verify number and type of arguments
 	if nilp(args) || !nilp(cdr(args)) {
-		return Error_Args
+		return error_args("1", args)
 	} else if car(args)._type != AtomType_Pair {
-		return Error_Type
+		return error_type("pair", car(args))
 	}
 
 	if nilp(car(args)) {
@@ -66,112 +78,201 @@ func builtin_cdr(args Atom, result *Atom) error {
 	return nil
 }
 
-// builtin_cons makes our native cons function available to the interpreter.
+// builtin_set_car replaces the car of a pair.
+// it returns NIL.
 // note that the result may not be updated if we find errors.
-func builtin_cons(args Atom, result *Atom) error {
+func builtin_set_car(args Atom, result *Atom) error {
 	// verify number and type of arguments
 	if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
-		return Error_Args
+		return error_args("2", args)
+	} else if car(args)._type != AtomType_Pair {
+		return error_type("pair", car(args))
 	}
 
-	*result = cons(car(args), car(cdr(args)))
+	setcar(car(args), car(cdr(args)))
+	*result = _nil
 	return nil
 }
 
-// builtin_divide implements a function for calculating the quotient of two numbers.
+// builtin_set_cdr replaces the cdr of a pair.
+// it returns NIL.
 // note that the result may not be updated if we find errors.
-// will panic on divide by zero.
-func builtin_divide(args Atom, result *Atom) error {
+func builtin_set_cdr(args Atom, result *Atom) error {
 	// verify number and type of arguments
 	if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
-		return Error_Args
+		return error_args("2", args)
+	} else if car(args)._type != AtomType_Pair {
+		return error_type("pair", car(args))
 	}
-	a, b := car(args), car(cdr(args))
-	if a._type != AtomType_Integer || b._type != AtomType_Integer {
-		return Error_Type
+
+	setcdr(car(args), car(cdr(args)))
+	*result = _nil
+	return nil
+}
+
+// builtin_cons makes our native cons function available to the interpreter.
+// note that the result may not be updated if we find errors.
+func (l *Interpreter) builtin_cons(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
+		return error_args("2", args)
 	}
 
-	*result = make_int(a.value.integer / b.value.integer)
+	*result = l.cons(car(args), car(cdr(args)))
 	return nil
 }
 
+// builtin_divide implements a function for calculating the quotient of two numbers.
+// dividing exact numbers gives an exact result, which may be a rational.
+// it is an error to divide an exact number by exact zero.
+// note that the result may not be updated if we find errors.
+func builtin_divide(args Atom, result *Atom) error {
+	return arith(args, result, arith_op{
+		integer: func(a, b int) (Atom, error) {
+			if b == 0 {
+				return _nil, Error_DivideByZero
+			} else if a == math.MinInt && b == -1 {
+				// overflow, so promote to a bignum
+				return make_bignum(new(big.Int).Neg(big.NewInt(int64(a)))), nil
+			} else if a%b != 0 {
+				return make_rational(big.NewRat(int64(a), int64(b))), nil
+			}
+			return make_int(a / b), nil
+		},
+		rational: func(a, b *big.Rat) (Atom, error) {
+			if b.Sign() == 0 {
+				return _nil, Error_DivideByZero
+			}
+			return make_rational(new(big.Rat).Quo(a, b)), nil
+		},
+		float: func(a, b float64) (Atom, error) {
+			return make_float(a / b), nil
+		},
+	})
+}
+
 // builtin_eq tests whether two atoms refer to the same object.
+// numbers are compared by value, so it is also used for EQV?.
 // note that the result may not be updated if we find errors.
 func builtin_eq(args Atom, result *Atom) error {
 	// verify number and type of arguments
 	if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
-		return Error_Args
+		return error_args("2", args)
+	}
+
+	*result = make_boolean(eqp(car(args), car(cdr(args))))
+	return nil
+}
+
+// builtin_equal tests whether two atoms have the same structure.
+// note that the result may not be updated if we find errors.
+func builtin_equal(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
+		return error_args("2", args)
 	}
 
-	// todo: should be able to assume that T is in the environment
-	a, b, t := car(args), car(cdr(args)), make_sym([]byte{'T'})
+	*result = make_boolean(equalp(car(args), car(cdr(args))))
+	return nil
+}
+
+// eqp returns true if two atoms refer to the same object.
+// numbers of the same type are the same object if they are equal.
+func eqp(a, b Atom) bool {
 	if a._type != b._type {
-		*result = _nil
-		return nil
+		return false
 	}
 	switch a._type {
 	case AtomType_Nil:
-		*result = t
+		return true
+	case AtomType_Bignum:
+		return a.value.bignum().Cmp(b.value.bignum()) == 0
+	case AtomType_Boolean:
+		return a.value.boolean() == b.value.boolean()
 	case AtomType_Builtin:
-		if a.value.builtin != b.value.builtin {
-			*result = _nil
-		} else {
-			*result = t
-		}
-	case AtomType_Closure:
-		if a.value.pair != b.value.pair {
-			*result = _nil
-		} else {
-			*result = t
-		}
+		return a.value.builtin() == b.value.builtin()
+	case AtomType_Char:
+		return a.value.char() == b.value.char()
+	case AtomType_Closure, AtomType_Macro, AtomType_Pair:
+		// a closure is an eval_closure or a vm_closure
+		return a.value.ref == b.value.ref
+	case AtomType_Condition:
+		return a.value.condition() == b.value.condition()
+	case AtomType_Continuation:
+		return a.value.continuation() == b.value.continuation()
+	case AtomType_Float:
+		// compared as EQV? does, so 0.0 and -0.0 are different
+		// and NaN is the same as itself
+		return make_float_key(a.value.float()) == make_float_key(b.value.float())
+	case AtomType_HashTable:
+		return a.value.table() == b.value.table()
 	case AtomType_Integer:
-		if a.value.integer != b.value.integer {
-			*result = _nil
-		} else {
-			*result = t
-		}
-	case AtomType_Macro:
-		if a.value.pair != b.value.pair {
-			*result = _nil
-		} else {
-			*result = t
+		return a.value.integer() == b.value.integer()
+	case AtomType_Rational:
+		return a.value.rational().Cmp(b.value.rational()) == 0
+	case AtomType_String:
+		return a.value.str() == b.value.str()
+	case AtomType_Symbol:
+		return a.value.symbol() == b.value.symbol()
+	case AtomType_Syntax:
+		return a.value.syntax() == b.value.syntax()
+	case AtomType_Vector:
+		return a.value.vector() == b.value.vector()
+	}
+	panic(fmt.Sprintf("assert(_type != %d)", a._type))
+}
+
+// equalp returns true if two atoms have the same structure.
+// pairs and vectors are equal if their items are equal, and strings
+// are equal if they have the same characters. anything else is equal
+// if it is the same object.
+func equalp(a, b Atom) bool {
+	for a._type == AtomType_Pair && b._type == AtomType_Pair {
+		if !equalp(car(a), car(b)) {
+			return false
 		}
-	case AtomType_Pair:
-		if a.value.pair != b.value.pair {
-			*result = _nil
-		} else {
-			*result = t
+		// loop instead of recursing on the cdr
+		a, b = cdr(a), cdr(b)
+	}
+	if a._type != b._type {
+		return false
+	}
+	switch a._type {
+	case AtomType_String:
+		return a.value.str().text == b.value.str().text
+	case AtomType_Vector:
+		if len(a.value.vector().items) != len(b.value.vector().items) {
+			return false
 		}
-	case AtomType_Symbol:
-		if a.value.symbol != b.value.symbol {
-			*result = _nil
-		} else {
-			*result = t
+		for n, item := range a.value.vector().items {
+			if !equalp(item, b.value.vector().items[n]) {
+				return false
+			}
 		}
-	default:
-		panic(fmt.Sprintf("assert(_type != %d)", a._type))
+		return true
 	}
-	return nil
+	return eqp(a, b)
 }
 
 // builtin_less implements a comparison operator for numbers,
-// returning T if the first argument is less than the second.
+// returning #t if the first argument is less than the second.
 // note that the result may not be updated if we find errors.
 func builtin_less(args Atom, result *Atom) error {
 	// verify number and type of arguments
 	if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
-		return Error_Args
+		return error_args("2", args)
 	}
 	a, b := car(args), car(cdr(args))
-	if a._type != AtomType_Integer || b._type != AtomType_Integer {
-		return Error_Type
+	if !numberp(a) {
+		return error_type("number", a)
+	} else if !numberp(b) {
+		return error_type("number", b)
 	}
 
-	if a.value.integer < b.value.integer {
-		// todo: should be able to assume that T is in the environment
-		*result = make_sym([]byte{'T'})
+	if cmp, ok := num_compare(a, b); ok && cmp < 0 {
+		*result = _true
 	} else {
-		*result = _nil
+		*result = _false
 	}
 	return nil
 }
@@ -179,37 +280,47 @@ func builtin_less(args Atom, result *Atom) error {
 // builtin_multiply implements a function for calculating the product of two numbers.
 // note that the result may not be updated if we find errors.
 func builtin_multiply(args Atom, result *Atom) error {
-	// verify number and type of arguments
-	if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
-		return Error_Args
-	}
-	a, b := car(args), car(cdr(args))
-	if a._type != AtomType_Integer || b._type != AtomType_Integer {
-		return Error_Type
-	}
-
-	*result = make_int(a.value.integer * b.value.integer)
-	return nil
+	return arith(args, result, arith_op{
+		integer: func(a, b int) (Atom, error) {
+			if a == 0 || b == 0 {
+				return make_int(0), nil
+			} else if c := a * b; c/b == a && !(a == -1 && b == math.MinInt) && !(b == -1 && a == math.MinInt) {
+				return make_int(c), nil
+			}
+			// overflow, so promote to a bignum
+			return make_bignum(new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(int64(b)))), nil
+		},
+		bignum: func(a, b *big.Int) (Atom, error) {
+			return make_bignum(new(big.Int).Mul(a, b)), nil
+		},
+		rational: func(a, b *big.Rat) (Atom, error) {
+			return make_rational(new(big.Rat).Mul(a, b)), nil
+		},
+		float: func(a, b float64) (Atom, error) {
+			return make_float(a * b), nil
+		},
+	})
 }
 
 // builtin_numeq implements a comparison operator for numbers,
-// returning T if they are equal.
+// returning #t if they are equal.
 // note that the result may not be updated if we find errors.
 func builtin_numeq(args Atom, result *Atom) error {
 	// verify number and type of arguments
 	if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
-		return Error_Args
+		return error_args("2", args)
 	}
 	a, b := car(args), car(cdr(args))
-	if a._type != AtomType_Integer || b._type != AtomType_Integer {
-		return Error_Type
+	if !numberp(a) {
+		return error_type("number", a)
+	} else if !numberp(b) {
+		return error_type("number", b)
 	}
 
-	if a.value.integer == b.value.integer {
-		// todo: should be able to assume that T is in the environment
-		*result = make_sym([]byte{'T'})
+	if cmp, ok := num_compare(a, b); ok && cmp == 0 {
+		*result = _true
 	} else {
-		*result = _nil
+		*result = _false
 	}
 	return nil
 }
@@ -219,13 +330,13 @@ func builtin_numeq(args Atom, result *Atom) error {
 func builtin_pairp(args Atom, result *Atom) error {
 	// verify number and type of arguments
 	if nilp(args) || !nilp(cdr(args)) {
-		return Error_Args
+		return error_args("1", args)
 	}
 
 	if car(args)._type != AtomType_Pair {
-		*result = _nil
+		*result = _false
 	} else {
-		*result = make_sym([]byte{'T'})
+		*result = _true
 	}
 	return nil
 }
@@ -233,15 +344,22 @@ func builtin_pairp(args Atom, result *Atom) error {
 // builtin_subtract implements a function for calculating the difference of two numbers.
 // note that the result may not be updated if we find errors.
 func builtin_subtract(args Atom, result *Atom) error {
-	// verify number and type of arguments
-	if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
-		return Error_Args
-	}
-	a, b := car(args), car(cdr(args))
-	if a._type != AtomType_Integer || b._type != AtomType_Integer {
-		return Error_Type
-	}
-
-	*result = make_int(a.value.integer - b.value.integer)
-	return nil
+	return arith(args, result, arith_op{
+		integer: func(a, b int) (Atom, error) {
+			if c := a - b; (c < a) == (b > 0) {
+				return make_int(c), nil
+			}
+			// overflow, so promote to a bignum
+			return make_bignum(new(big.Int).Sub(big.NewInt(int64(a)), big.NewInt(int64(b)))), nil
+		},
+		bignum: func(a, b *big.Int) (Atom, error) {
+			return make_bignum(new(big.Int).Sub(a, b)), nil
+		},
+		rational: func(a, b *big.Rat) (Atom, error) {
+			return make_rational(new(big.Rat).Sub(a, b)), nil
+		},
+		float: func(a, b float64) (Atom, error) {
+			return make_float(a - b), nil
+		},
+	})
 }
@@ -5,12 +5,118 @@ package lisp
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"unsafe"
 )
 
+// modes are the ways that an interpreter can evaluate expressions.
+// the chapter tests are run in each of them.
+var modes = []struct {
+	name string
+	opts []Option
+}{
+	{name: "eval"},
+	{name: "bytecode", opts: []Option{WithBytecode()}},
+}
+
+// in_each_mode runs a test once for each mode.
+func in_each_mode(t *testing.T, test func(t *testing.T, opts ...Option)) {
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			test(t, mode.opts...)
+		})
+	}
+}
+
+// TestAtomSize guards the size of cells. every cons copies two atoms,
+// so larger atoms slow down the whole interpreter.
+func TestAtomSize(t *testing.T) {
+	if got := unsafe.Sizeof(Atom{}); got > 32 {
+		t.Errorf("atom: want at most 32 bytes: got %d\n", got)
+	}
+	if got := unsafe.Sizeof(Pair{}); got > 80 {
+		t.Errorf("pair: want at most 80 bytes: got %d\n", got)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	l := NewInterpreter()
+	closure, err := l.EvalString("(lambda (a) (define b a) (lambda (c) (list a b c d)))")
+	if err != nil {
+		t.Fatalf("eval: error: want nil: got %v\n", err)
+	}
+	outer := closure.value.closure().lambda
+	var names []string
+	for _, name := range outer.names {
+		names = append(names, string(name.label))
+	}
+	if got := strings.Join(names, " "); got != "A B" {
+		t.Errorf("names: want %q: got %q\n", "A B", got)
+	}
+	if len(outer.lambdas) != 1 {
+		t.Fatalf("lambdas: want 1: got %d\n", len(outer.lambdas))
+	}
+	// the inner LAMBDA's references are resolved when the outer one is
+	for _, inner := range outer.lambdas {
+		var got []string
+		for expr := car(inner.body); !nilp(expr); expr = cdr(expr) {
+			if item := car(expr); item._type == AtomType_Local {
+				depth, index := item.value.local()
+				got = append(got, fmt.Sprintf("%s:%d:%d", item, depth, index))
+			} else {
+				got = append(got, item.String())
+			}
+		}
+		if expect := "LIST A:1:0 B:1:1 C:0:0 D"; strings.Join(got, " ") != expect {
+			t.Errorf("body: want %q: got %q\n", expect, strings.Join(got, " "))
+		}
+	}
+}
+
+func TestResolveDerived(t *testing.T) {
+	l := NewInterpreter()
+	closure, err := l.EvalString("(lambda (a) (let ((b a)) (list a b)))")
+	if err != nil {
+		t.Fatalf("eval: error: want nil: got %v\n", err)
+	}
+	// the LET is replaced by its expansion when the closure is created
+	outer := closure.value.closure().lambda
+	if len(outer.sources) != 1 {
+		t.Fatalf("sources: want 1: got %d\n", len(outer.sources))
+	}
+	for expansion, source := range outer.sources {
+		if expansion != car(outer.body).value.pair() {
+			t.Errorf("body: want expansion: got %s\n", car(outer.body))
+		}
+		if expect := "(LET ((B A)) (LIST A B))"; source.String() != expect {
+			t.Errorf("source: want %q: got %q\n", expect, source.String())
+		}
+	}
+	if len(outer.lambdas) != 1 {
+		t.Fatalf("lambdas: want 1: got %d\n", len(outer.lambdas))
+	}
+	for _, inner := range outer.lambdas {
+		var got []string
+		for expr := car(inner.body); !nilp(expr); expr = cdr(expr) {
+			if item := car(expr); item._type == AtomType_Local {
+				depth, index := item.value.local()
+				got = append(got, fmt.Sprintf("%s:%d:%d", item, depth, index))
+			} else {
+				got = append(got, item.String())
+			}
+		}
+		if expect := "LIST A:1:0 B:0:0"; strings.Join(got, " ") != expect {
+			t.Errorf("body: want %q: got %q\n", expect, strings.Join(got, " "))
+		}
+	}
+}
+
 func TestChapter02(t *testing.T) {
+	l := &Interpreter{}
 	mksym := func(s string) Atom {
-		return make_sym([]byte(s))
+		return l.make_sym([]byte(s))
 	}
 
 	for _, tc := range []struct {
@@ -44,6 +150,8 @@ func TestChapter02(t *testing.T) {
 }
 
 func TestChapter03(t *testing.T) {
+	l := &Interpreter{}
+
 	// test the runof function
 	for _, tc := range []struct {
 		id                string
@@ -93,6 +201,10 @@ func TestChapter03(t *testing.T) {
 		{"skipws: 1", " \n\r\t 42", "42"},
 		{"skipws: 2", "f o o", "f o o"},
 		{"skipws: 3", " \t\r\n", ""},
+		{"skipws: 4", " ; comment\n 42", "42"},
+		{"skipws: 5", "#| block #| nested |# |# 42", "42"},
+		{"skipws: 6", "#; (foo (bar)) 42", "42"},
+		{"skipws: 7", "#| unterminated", "#| unterminated"},
 	} {
 		remainder := skipws([]byte(tc.input))
 		if tc.remainder != string(remainder) {
@@ -111,6 +223,9 @@ func TestChapter03(t *testing.T) {
 		{3, "(foo bar)", []string{"(", "foo", "bar", ")"}},
 		{4, "(s (t . u) v . (w . nil))", []string{"(", "s", "(", "t", ".", "u", ")", "v", ".", "(", "w", ".", "nil", ")", ")"}},
 		{5, "a(b)c\n", []string{"a", "(", "b", ")", "c", ""}},
+		{6, "(a;b\nc)", []string{"(", "a", "c", ")"}},
+		{7, "(a #|b|# #;'c d)", []string{"(", "a", "d", ")"}},
+		{8, `(#\( #\space)`, []string{"(", `#\(`, `#\space`, ")"}},
 	} {
 		input := []byte(tc.input)
 		var token []byte
@@ -138,11 +253,11 @@ func TestChapter03(t *testing.T) {
 		{id: 13, input: "()", expect: "NIL"},
 	} {
 		// reset the symbol table
-		sym_table = _nil
+		l.symbols = nil
 
 		input := []byte(tc.input)
 		var expr Atom
-		_, _ = read_expr(input, &expr)
+		_, _ = l.read_expr(input, &expr)
 		got := expr.String()
 		if tc.expect != got {
 			t.Errorf("%d: want %q: got %q\n", tc.id, tc.expect, got)
@@ -150,7 +265,7 @@ func TestChapter03(t *testing.T) {
 	}
 
 	// reset the symbol table
-	sym_table = _nil
+	l.symbols = nil
 
 	// test the read function
 	for _, tc := range []struct {
@@ -170,7 +285,7 @@ func TestChapter03(t *testing.T) {
 		{id: 19, input: "(define foo (quote bar))", expect: "(DEFINE FOO (QUOTE BAR))"},
 	} {
 		input := []byte(tc.input)
-		expr, remainder, err := read(input)
+		expr, remainder, err := l.read(input)
 		got := expr.String()
 		if tc.expect != got {
 			t.Errorf("%d: want %q: got %q\n", tc.id, tc.expect, got)
@@ -184,8 +299,14 @@ func TestChapter03(t *testing.T) {
 	}
 }
 
-func TestChapter04(t *testing.T) {
-	env := env_create(_nil)
+func TestChapter04(t *testing.T) { in_each_mode(t, test_chapter04) }
+
+func test_chapter04(t *testing.T, opts ...Option) {
+	l := &Interpreter{}
+	for _, opt := range opts {
+		opt(l)
+	}
+	env := l.env_create(_nil)
 
 	for _, tc := range []struct {
 		id     int
@@ -201,13 +322,13 @@ func TestChapter04(t *testing.T) {
 		{id: 6, input: "foo", expect: "BAR"},
 	} {
 		var expr Atom
-		_, err := read_expr([]byte(tc.input), &expr)
+		_, err := l.read_expr([]byte(tc.input), &expr)
 		if err != nil {
 			t.Errorf("%d: read error: want nil: got %v\n", tc.id, err)
 			continue
 		}
 		var result Atom
-		err = eval_expr(expr, env, &result)
+		err = l.eval_expr(expr, env, &result)
 		if tc.err == nil && err == nil {
 			// yay
 		} else if tc.err == nil && err != nil {
@@ -221,8 +342,11 @@ func TestChapter04(t *testing.T) {
 	}
 }
 
-func TestChapter05(t *testing.T) {
-	env := env_create_default()
+func TestChapter05(t *testing.T) { in_each_mode(t, test_chapter05) }
+
+func test_chapter05(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	env := l.env
 
 	for _, tc := range []struct {
 		id     int
@@ -238,14 +362,14 @@ func TestChapter05(t *testing.T) {
 		{id: 6, input: "(cdr baz)", expect: "(B C)"},
 	} {
 		var expr Atom
-		_, err := read_expr([]byte(tc.input), &expr)
+		_, err := l.read_expr([]byte(tc.input), &expr)
 		if err != nil {
 			t.Errorf("%d: read error: want nil: got %v\n", tc.id, err)
 			continue
 		}
 
 		var result Atom
-		err = eval_expr(expr, env, &result)
+		err = l.eval_expr(expr, env, &result)
 
 		if tc.err == nil && err == nil {
 			// yay
@@ -260,8 +384,11 @@ func TestChapter05(t *testing.T) {
 	}
 }
 
-func TestChapter06(t *testing.T) {
-	env := env_create_default()
+func TestChapter06(t *testing.T) { in_each_mode(t, test_chapter06) }
+
+func test_chapter06(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	env := l.env
 
 	for _, tc := range []struct {
 		id     int
@@ -276,14 +403,14 @@ func TestChapter06(t *testing.T) {
 		{id: 5, input: "(/ 108 x)", expect: "2"},
 	} {
 		var expr Atom
-		_, err := read_expr([]byte(tc.input), &expr)
+		_, err := l.read_expr([]byte(tc.input), &expr)
 		if err != nil {
 			t.Errorf("%d: read error: want nil: got %v\n", tc.id, err)
 			continue
 		}
 
 		var result Atom
-		err = eval_expr(expr, env, &result)
+		err = l.eval_expr(expr, env, &result)
 
 		if tc.err == nil && err == nil {
 			// yay
@@ -298,8 +425,11 @@ func TestChapter06(t *testing.T) {
 	}
 }
 
-func TestChapter07(t *testing.T) {
-	env := env_create_default()
+func TestChapter07(t *testing.T) { in_each_mode(t, test_chapter07) }
+
+func test_chapter07(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	env := l.env
 
 	for _, tc := range []struct {
 		id     int
@@ -316,14 +446,14 @@ func TestChapter07(t *testing.T) {
 		{id: 7, input: "(add-two 5)", expect: "7"},
 	} {
 		var expr Atom
-		_, err := read_expr([]byte(tc.input), &expr)
+		_, err := l.read_expr([]byte(tc.input), &expr)
 		if err != nil {
 			t.Errorf("%d: read error: want nil: got %v\n", tc.id, err)
 			continue
 		}
 
 		var result Atom
-		err = eval_expr(expr, env, &result)
+		err = l.eval_expr(expr, env, &result)
 
 		if tc.err == nil && err == nil {
 			// yay
@@ -338,8 +468,11 @@ func TestChapter07(t *testing.T) {
 	}
 }
 
-func TestChapter08(t *testing.T) {
-	env := env_create_default()
+func TestChapter08(t *testing.T) { in_each_mode(t, test_chapter08) }
+
+func test_chapter08(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	env := l.env
 
 	for _, tc := range []struct {
 		id     int
@@ -348,23 +481,23 @@ func TestChapter08(t *testing.T) {
 		err    error
 	}{
 		{id: 1, input: "(if t 3 4)", expect: "3"},
-		{id: 2, input: "(if nil 3 4)", expect: "4"},
-		{id: 3, input: "(if 0 t nil)", expect: "T"},
-		{id: 4, input: "(= 3 3)", expect: "T"},
-		{id: 5, input: "(< 11 4)", expect: "NIL"},
+		{id: 2, input: "(if #f 3 4)", expect: "4"},
+		{id: 3, input: "(if 0 t nil)", expect: "#t"},
+		{id: 4, input: "(= 3 3)", expect: "#t"},
+		{id: 5, input: "(< 11 4)", expect: "#f"},
 		{id: 6, input: "(define fact (lambda (x) (if (= x 0) 1 (* x (fact (- x 1))))))", expect: "FACT"},
 		{id: 7, input: "(fact 10)", expect: "3628800"},
 		{id: 8, input: "(if (= (fact 10) 3628800) (quote passed) (quote failed))", expect: "PASSED"},
 	} {
 		var expr Atom
-		_, err := read_expr([]byte(tc.input), &expr)
+		_, err := l.read_expr([]byte(tc.input), &expr)
 		if err != nil {
 			t.Errorf("%d: read error: want nil: got %v\n", tc.id, err)
 			continue
 		}
 
 		var result Atom
-		err = eval_expr(expr, env, &result)
+		err = l.eval_expr(expr, env, &result)
 
 		if tc.err == nil && err == nil {
 			// yay
@@ -379,8 +512,11 @@ func TestChapter08(t *testing.T) {
 	}
 }
 
-func TestChapter09(t *testing.T) {
-	env := env_create_default()
+func TestChapter09(t *testing.T) { in_each_mode(t, test_chapter09) }
+
+func test_chapter09(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	env := l.env
 
 	for _, tc := range []struct {
 		id     int
@@ -398,14 +534,14 @@ func TestChapter09(t *testing.T) {
 		{id: 8, input: "(square 3)", expect: "9"},
 	} {
 		var expr Atom
-		_, err := read_expr([]byte(tc.input), &expr)
+		_, err := l.read_expr([]byte(tc.input), &expr)
 		if err != nil {
 			t.Errorf("%d: read error: want nil: got %v\n", tc.id, err)
 			continue
 		}
 
 		var result Atom
-		err = eval_expr(expr, env, &result)
+		err = l.eval_expr(expr, env, &result)
 
 		if tc.err == nil && err == nil {
 			// yay
@@ -420,8 +556,11 @@ func TestChapter09(t *testing.T) {
 	}
 }
 
-func TestChapter10(t *testing.T) {
-	env := env_create_default()
+func TestChapter10(t *testing.T) { in_each_mode(t, test_chapter10) }
+
+func test_chapter10(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	env := l.env
 
 	for _, tc := range []struct {
 		id     int
@@ -432,21 +571,21 @@ func TestChapter10(t *testing.T) {
 		{id: 1, input: "((lambda (a . b) a) 1 2 3)", expect: "1"},
 		{id: 2, input: "((lambda (a . b) b) 1 2 3)", expect: "(2 3)"},
 		{id: 3, input: "((lambda args args) 1 2 3)", expect: "(1 2 3)"},
-		{id: 4, input: "(define (sum-list xs) (if xs (+ (car xs) (sum-list (cdr xs))) 0))", expect: "SUM-LIST"},
+		{id: 4, input: "(define (sum-list xs) (if (pair? xs) (+ (car xs) (sum-list (cdr xs))) 0))", expect: "SUM-LIST"},
 		{id: 5, input: "(sum-list '(1 2 3))", expect: "6"},
 		{id: 6, input: "(define (add . xs) (sum-list xs))", expect: "ADD"},
 		{id: 7, input: "(add 1 2 3)", expect: "6"},
 		{id: 8, input: "(add 1 (- 4 2) (/ 9 3))", expect: "6"},
 	} {
 		var expr Atom
-		_, err := read_expr([]byte(tc.input), &expr)
+		_, err := l.read_expr([]byte(tc.input), &expr)
 		if err != nil {
 			t.Errorf("%d: read error: want nil: got %v\n", tc.id, err)
 			continue
 		}
 
 		var result Atom
-		err = eval_expr(expr, env, &result)
+		err = l.eval_expr(expr, env, &result)
 
 		if tc.err == nil && err == nil {
 			// yay
@@ -461,8 +600,11 @@ func TestChapter10(t *testing.T) {
 	}
 }
 
-func TestChapter11(t *testing.T) {
-	env := env_create_default()
+func TestChapter11(t *testing.T) { in_each_mode(t, test_chapter11) }
+
+func test_chapter11(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	env := l.env
 
 	for _, tc := range []struct {
 		id     int
@@ -475,14 +617,14 @@ func TestChapter11(t *testing.T) {
 		{id: 3, input: "foo", expect: "NIL", err: Error_Unbound},
 	} {
 		var expr Atom
-		_, err := read_expr([]byte(tc.input), &expr)
+		_, err := l.read_expr([]byte(tc.input), &expr)
 		if err != nil {
 			t.Errorf("%d: read error: want nil: got %v\n", tc.id, err)
 			continue
 		}
 
 		var result Atom
-		err = eval_expr(expr, env, &result)
+		err = l.eval_expr(expr, env, &result)
 
 		if tc.err == nil && err == nil {
 			// yay
@@ -497,9 +639,12 @@ func TestChapter11(t *testing.T) {
 	}
 }
 
-func TestChapter12(t *testing.T) {
-	env := env_create_default()
-	if err := load_file(env, "library.lisp"); err != nil {
+func TestChapter12(t *testing.T) { in_each_mode(t, test_chapter12) }
+
+func test_chapter12(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	env := l.env
+	if err := l.load_file(env, "library.lisp"); err != nil {
 		t.Errorf("error: want nil: got %v\n", err)
 	}
 
@@ -515,14 +660,14 @@ func TestChapter12(t *testing.T) {
 		{id: 4, input: "(map + '(1 2 3) '(4 5 6))", expect: "(5 7 9)"},
 	} {
 		var expr Atom
-		_, err := read_expr([]byte(tc.input), &expr)
+		_, err := l.read_expr([]byte(tc.input), &expr)
 		if err != nil {
 			t.Errorf("%d: read error: want nil: got %v\n", tc.id, err)
 			continue
 		}
 
 		var result Atom
-		err = eval_expr(expr, env, &result)
+		err = l.eval_expr(expr, env, &result)
 
 		if tc.err == nil && err == nil {
 			// yay
@@ -537,9 +682,12 @@ func TestChapter12(t *testing.T) {
 	}
 }
 
-func TestChapter13(t *testing.T) {
-	env := env_create_default()
-	if err := load_file(env, "library.lisp"); err != nil {
+func TestChapter13(t *testing.T) { in_each_mode(t, test_chapter13) }
+
+func test_chapter13(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	env := l.env
+	if err := l.load_file(env, "library.lisp"); err != nil {
 		t.Errorf("error: want nil: got %v\n", err)
 	}
 
@@ -557,14 +705,14 @@ func TestChapter13(t *testing.T) {
 		{id: 6, input: "(+ 1 2 3 4)", expect: "10"},
 	} {
 		var expr Atom
-		_, err := read_expr([]byte(tc.input), &expr)
+		_, err := l.read_expr([]byte(tc.input), &expr)
 		if err != nil {
 			t.Errorf("%d: read error: want nil: got %v\n", tc.id, err)
 			continue
 		}
 
 		var result Atom
-		err = eval_expr(expr, env, &result)
+		err = l.eval_expr(expr, env, &result)
 
 		if tc.err == nil && err == nil {
 			// yay
@@ -579,9 +727,12 @@ func TestChapter13(t *testing.T) {
 	}
 }
 
-func TestChapter14(t *testing.T) {
-	env := env_create_default()
-	if err := load_file(env, "library.lisp"); err != nil {
+func TestChapter14(t *testing.T) { in_each_mode(t, test_chapter14) }
+
+func test_chapter14(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	env := l.env
+	if err := l.load_file(env, "library.lisp"); err != nil {
 		t.Errorf("error: want nil: got %v\n", err)
 	}
 
@@ -597,15 +748,611 @@ func TestChapter14(t *testing.T) {
 		{id: 4, input: "(count 100000 0)", expect: "100000"},
 	} {
 		var expr Atom
-		_, err := read_expr([]byte(tc.input), &expr)
+		_, err := l.read_expr([]byte(tc.input), &expr)
 		if err != nil {
 			t.Errorf("%d: read error: want nil: got %v\n", tc.id, err)
 			continue
 		}
 
 		var result Atom
-		err = eval_expr(expr, env, &result)
+		err = l.eval_expr(expr, env, &result)
+
+		if tc.err == nil && err == nil {
+			// yay
+		} else if tc.err == nil && err != nil {
+			t.Errorf("%d: error: want nil: got %v\n", tc.id, err)
+		} else if !errors.Is(err, tc.err) {
+			t.Errorf("%d: error: want %v: got %v\n", tc.id, tc.err, err)
+		}
+		if got := result.String(); tc.expect != got {
+			t.Errorf("%d: eval: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+}
+
+func TestInterpreter(t *testing.T) {
+	a, b := NewInterpreter(), NewInterpreter()
+	if err := a.LoadFile("library.lisp"); err != nil {
+		t.Fatalf("load: error: want nil: got %v\n", err)
+	}
+	a.Define("answer", make_int(42))
+	b.DefineBuiltin("twice", func(args Atom, result *Atom) error {
+		if nilp(args) || car(args)._type != AtomType_Integer {
+			return Error_Type
+		}
+		*result = make_int(2 * car(args).value.integer())
+		return nil
+	})
+
+	for _, tc := range []struct {
+		id     int
+		interp *Interpreter
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, interp: a, input: "(define foo 1) (define bar 2) (cons foo bar)", expect: "(1 . 2)"},
+		{id: 2, interp: b, input: "foo", expect: "NIL", err: Error_Unbound},
+		{id: 3, interp: b, input: "(define foo 'b)", expect: "FOO"},
+		{id: 4, interp: a, input: "foo", expect: "1"},
+		{id: 5, interp: b, input: "foo", expect: "B"},
+		{id: 6, interp: a, input: "answer", expect: "42"},
+		{id: 7, interp: b, input: "answer", expect: "NIL", err: Error_Unbound},
+		{id: 8, interp: b, input: "(twice 21)", expect: "42"},
+		{id: 9, interp: a, input: "(twice 21)", expect: "NIL", err: Error_Unbound},
+		{id: 10, interp: a, input: "(list 1 2 3)", expect: "(1 2 3)"},
+		{id: 11, interp: b, input: "(list 1 2 3)", expect: "NIL", err: Error_Unbound},
+		{id: 12, interp: a, input: "", expect: "NIL"},
+		{id: 13, interp: a, input: "(foo", expect: "NIL", err: Error_Syntax},
+	} {
+		result, err := tc.interp.EvalString(tc.input)
+		if tc.err == nil && err == nil {
+			// yay
+		} else if tc.err == nil && err != nil {
+			t.Errorf("%d: error: want nil: got %v\n", tc.id, err)
+		} else if !errors.Is(err, tc.err) {
+			t.Errorf("%d: error: want %v: got %v\n", tc.id, tc.err, err)
+		}
+		if got := result.String(); tc.expect != got {
+			t.Errorf("%d: eval: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+
+	// symbols are interned per interpreter
+	if a.make_sym([]byte("FOO")).value.symbol() == b.make_sym([]byte("FOO")).value.symbol() {
+		t.Errorf("symbols: want distinct symbols: got shared symbol\n")
+	}
+}
+
+func TestContinuations(t *testing.T) { in_each_mode(t, test_continuations) }
+
+func test_continuations(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	if err := l.LoadLibrary(); err != nil {
+		t.Fatalf("library: error: want nil: got %v\n", err)
+	}
+
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, input: "(call/cc (lambda (k) 5))", expect: "5"},
+		{id: 2, input: "(+ 1 (call/cc (lambda (k) (+ 10 (k 42)))))", expect: "43"},
+		{id: 3, input: "(call-with-current-continuation (lambda (k) (k 'foo) 'bar))", expect: "FOO"},
+		{id: 4, input: "(call/cc (lambda (k) (map (lambda (x) (if (< x 0) (k x) x)) '(1 -2 3))))", expect: "-2"},
+		{id: 5, input: "(call/cc (lambda (k) (map (lambda (x) (if (< x 0) (k x) x)) '(1 2 3))))", expect: "(1 2 3)"},
+		{id: 6, input: "(define saved (call/cc (lambda (k) k)))", expect: "SAVED"},
+		{id: 7, input: "(saved '(a b))", expect: "SAVED"},
+		{id: 8, input: "saved", expect: "(A B)"},
+		{id: 9, input: "(define k2 (call/cc (lambda (k) k)))", expect: "K2"},
+		{id: 10, input: "(eq? k2 k2)", expect: "#t"},
+		{id: 11, input: "(apply k2 '(7))", expect: "K2"},
+		{id: 12, input: "k2", expect: "7"},
+		{id: 13, input: "(call/cc (lambda (k) (k 1 2)))", expect: "NIL", err: Error_Args},
+		{id: 14, input: "(call/cc)", expect: "NIL", err: Error_Args},
+		{id: 15, input: "(define r (list 1 (call/cc (lambda (k) k)) 3))", expect: "R"},
+		{id: 16, input: "(if (pair? (cdr r)) ((car (cdr r)) 2) r)", expect: "R"},
+		{id: 17, input: "r", expect: "(1 2 3)"},
+	} {
+		result, err := l.EvalString(tc.input)
+		if tc.err == nil && err == nil {
+			// yay
+		} else if tc.err == nil && err != nil {
+			t.Errorf("%d: error: want nil: got %v\n", tc.id, err)
+		} else if !errors.Is(err, tc.err) {
+			t.Errorf("%d: error: want %v: got %v\n", tc.id, tc.err, err)
+		}
+		if got := result.String(); tc.expect != got {
+			t.Errorf("%d: eval: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	l := NewInterpreter()
+
+	// test the lexer function
+	for _, tc := range []struct {
+		id    int
+		input string
+		token []string
+	}{
+		{1, `"foo"`, []string{`"foo"`}},
+		{2, `("foo" bar)`, []string{"(", `"foo"`, "bar", ")"}},
+		{3, `"a \"b\" c"`, []string{`"a \"b\" c"`}},
+		{4, `foo"bar"`, []string{"foo", `"bar"`}},
+		{5, `"a\\"b`, []string{`"a\\"`, "b"}},
+	} {
+		input := []byte(tc.input)
+		var token []byte
+		for n, want := range tc.token {
+			token, input = lex(input)
+			if want != string(token) {
+				t.Errorf("%d:%d: token: want %q: got %q\n", tc.id, n, want, string(token))
+			}
+		}
+		if len(input) != 0 {
+			t.Errorf("%d: remainder: want %q: got %q\n", tc.id, "", string(input))
+		}
+	}
+
+	// test the read functions
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, input: `"hello"`, expect: `"hello"`},
+		{id: 2, input: `("a" "b")`, expect: `("a" "b")`},
+		{id: 3, input: `"tab\tnew\nline \"q\" \\"`, expect: `"tab\tnew\nline \"q\" \\"`},
+		{id: 4, input: `"unterminated`, expect: "NIL", err: Error_Syntax},
+		{id: 5, input: `"bad \q escape"`, expect: "NIL", err: Error_Syntax},
+		{id: 6, input: `"MiXeD"`, expect: `"MiXeD"`},
+	} {
+		var expr Atom
+		_, err := l.read_expr([]byte(tc.input), &expr)
+		if tc.err == nil && err != nil {
+			t.Errorf("%d: read_expr: error: want nil: got %v\n", tc.id, err)
+		} else if !errors.Is(err, tc.err) {
+			t.Errorf("%d: read_expr: error: want %v: got %v\n", tc.id, tc.err, err)
+		} else if got := expr.String(); tc.expect != got {
+			t.Errorf("%d: read_expr: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+		expr, _, err = l.read([]byte(tc.input))
+		if tc.err == nil && err != nil {
+			t.Errorf("%d: read: error: want nil: got %v\n", tc.id, err)
+		} else if !errors.Is(err, tc.err) {
+			t.Errorf("%d: read: error: want %v: got %v\n", tc.id, tc.err, err)
+		} else if got := expr.String(); tc.expect != got {
+			t.Errorf("%d: read: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, input: `(string-length "hello")`, expect: "5"},
+		{id: 2, input: `(string-length "")`, expect: "0"},
+		{id: 3, input: `(string-append "foo" "bar" "baz")`, expect: `"foobarbaz"`},
+		{id: 4, input: `(string-append)`, expect: `""`},
+		{id: 5, input: `(substring "hello world" 6)`, expect: `"world"`},
+		{id: 6, input: `(substring "hello world" 0 5)`, expect: `"hello"`},
+		{id: 7, input: `(substring "hello" 3 9)`, expect: "NIL", err: Error_Range},
+		{id: 8, input: `(string=? "abc" "abc")`, expect: "#t"},
+		{id: 9, input: `(string=? "abc" "abd")`, expect: "#f"},
+		{id: 10, input: `(string<? "abc" "abd")`, expect: "#t"},
+		{id: 11, input: `(string<? "abd" "abc")`, expect: "#f"},
+		{id: 12, input: `(string->symbol "foo")`, expect: "FOO"},
+		{id: 13, input: `(eq? (string->symbol "foo") 'foo)`, expect: "#t"},
+		{id: 14, input: `(symbol->string 'foo)`, expect: `"FOO"`},
+		{id: 15, input: `(number->string 42)`, expect: `"42"`},
+		{id: 16, input: `(string->number "-17")`, expect: "-17"},
+		{id: 17, input: `(string->number "seventeen")`, expect: "#f"},
+		{id: 18, input: `(string-length 'foo)`, expect: "NIL", err: Error_Type},
+		{id: 19, input: `(define s "abc")`, expect: "S"},
+		{id: 20, input: `(eq? s s)`, expect: "#t"},
+		{id: 21, input: `(eq? "abc" "abc")`, expect: "#f"},
+		{id: 22, input: `(string-length "héllo")`, expect: "5"},
+		{id: 23, input: `(substring "héllo" 1 2)`, expect: `"é"`},
+		{id: 24, input: `(substring "hello" -1)`, expect: "NIL", err: Error_Range},
+		{id: 25, input: `(substring "hello" 6)`, expect: "NIL", err: Error_Range},
+		{id: 26, input: `(substring "hello" 5)`, expect: `""`},
+		{id: 27, input: `(string? "abc")`, expect: "#t"},
+		{id: 28, input: `(string? 'abc)`, expect: "#f"},
+		{id: 29, input: `(string? #\a)`, expect: "#f"},
+	} {
+		result, err := l.EvalString(tc.input)
+		if tc.err == nil && err == nil {
+			// yay
+		} else if tc.err == nil && err != nil {
+			t.Errorf("%d: error: want nil: got %v\n", tc.id, err)
+		} else if !errors.Is(err, tc.err) {
+			t.Errorf("%d: error: want %v: got %v\n", tc.id, tc.err, err)
+		}
+		if got := result.String(); tc.expect != got {
+			t.Errorf("%d: eval: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	l := NewInterpreter()
+	if err := l.LoadLibrary(); err != nil {
+		t.Fatalf("library: error: want nil: got %v\n", err)
+	}
+
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, input: "3.14", expect: "3.14"},
+		{id: 2, input: "1e-3", expect: "0.001"},
+		{id: 3, input: "-2.5E2", expect: "-250.0"},
+		{id: 4, input: ".5", expect: "0.5"},
+		{id: 5, input: "2.", expect: "2.0"},
+		{id: 6, input: "'(1e foo.5 inf nan +inf.0)", expect: "(1E FOO.5 INF NAN +inf.0)"},
+		{id: 7, input: "(+ 1 2.5)", expect: "3.5"},
+		{id: 8, input: "(+ 1 2)", expect: "3"},
+		{id: 9, input: "(- 1.5 1)", expect: "0.5"},
+		{id: 10, input: "(* 2 0.25)", expect: "0.5"},
+		{id: 11, input: "(/ 7 2)", expect: "7/2"},
+		{id: 12, input: "(/ 7 2.0)", expect: "3.5"},
+		{id: 13, input: "(/ 1 0.0)", expect: "+inf.0"},
+		{id: 14, input: "(= 2 2.0)", expect: "#t"},
+		{id: 15, input: "(< 1 1.5)", expect: "#t"},
+		{id: 16, input: "(< 1.5 1)", expect: "#f"},
+		{id: 17, input: "(exact->inexact 3)", expect: "3.0"},
+		{id: 18, input: "(inexact->exact 3.0)", expect: "3"},
+		{id: 19, input: "(inexact->exact 3.5)", expect: "7/2"},
+		{id: 20, input: "(floor 2.5)", expect: "2.0"},
+		{id: 21, input: "(floor -2.5)", expect: "-3.0"},
+		{id: 22, input: "(ceiling 2.1)", expect: "3.0"},
+		{id: 23, input: "(round 2.5)", expect: "2.0"},
+		{id: 24, input: "(round 3.5)", expect: "4.0"},
+		{id: 25, input: "(truncate -2.7)", expect: "-2.0"},
+		{id: 26, input: "(floor 5)", expect: "5"},
+		{id: 27, input: "(sqrt 16)", expect: "4"},
+		{id: 28, input: "(sqrt 2)", expect: "1.4142135623730951"},
+		{id: 29, input: "(sqrt 2.25)", expect: "1.5"},
+		{id: 30, input: "(expt 2 10)", expect: "1024"},
+		{id: 31, input: "(expt 2 -1)", expect: "1/2"},
+		{id: 32, input: "(expt 2.0 3)", expect: "8.0"},
+		{id: 33, input: "(exp 0)", expect: "1.0"},
+		{id: 34, input: "(log 1)", expect: "0.0"},
+		{id: 35, input: "(log 8 2)", expect: "3.0"},
+		{id: 36, input: "(sin 0)", expect: "0.0"},
+		{id: 37, input: "(cos 0)", expect: "1.0"},
+		{id: 38, input: "(atan 1 1)", expect: "0.7853981633974483"},
+		{id: 39, input: "(number? 1.5)", expect: "#t"},
+		{id: 40, input: "(integer? 2.0)", expect: "#t"},
+		{id: 41, input: "(integer? 2.5)", expect: "#f"},
+		{id: 42, input: "(exact? 2)", expect: "#t"},
+		{id: 43, input: "(exact? 2.0)", expect: "#f"},
+		{id: 44, input: "(+ 1 2.5 3)", expect: "6.5"},
+		{id: 45, input: "(abs -2.5)", expect: "2.5"},
+		{id: 46, input: "(number->string 2.5)", expect: `"2.5"`},
+		{id: 47, input: `(string->number "1e3")`, expect: "1000.0"},
+		{id: 48, input: "(+ 1 'a)", expect: "NIL", err: Error_Type},
+		{id: 49, input: "(sqrt 'a)", expect: "NIL", err: Error_Type},
+		{id: 50, input: "(= (/ 0.0 0.0) (/ 0.0 0.0))", expect: "#f"},
+		{id: 51, input: "(define (fact n) (if (= n 0) 1 (* n (fact (- n 1)))))", expect: "FACT"},
+		{id: 52, input: "(fact 30)", expect: "265252859812191058636308480000000"},
+		{id: 53, input: "(/ (fact 30) (fact 28))", expect: "870"},
+		{id: 54, input: "(integer? (/ (fact 30) (fact 28)))", expect: "#t"},
+		{id: 55, input: "(eq? (/ (fact 30) (fact 28)) 870)", expect: "#t"},
+		{id: 56, input: "123456789012345678901234567890", expect: "123456789012345678901234567890"},
+		{id: 57, input: "-123456789012345678901234567890", expect: "-123456789012345678901234567890"},
+		{id: 58, input: "(+ 9223372036854775807 1)", expect: "9223372036854775808"},
+		{id: 59, input: "(- -9223372036854775808 1)", expect: "-9223372036854775809"},
+		{id: 60, input: "(- (+ 9223372036854775807 1) 1)", expect: "9223372036854775807"},
+		{id: 61, input: "(* 99999999999 99999999999)", expect: "9999999999800000000001"},
+		{id: 62, input: "(/ -9223372036854775808 -1)", expect: "9223372036854775808"},
+		{id: 63, input: "(< 9223372036854775807 9223372036854775808)", expect: "#t"},
+		{id: 64, input: "(= 100000000000000000000 100000000000000000000)", expect: "#t"},
+		{id: 65, input: "(eq? 100000000000000000000 100000000000000000000)", expect: "#t"},
+		{id: 66, input: "(exact? 100000000000000000000)", expect: "#t"},
+		{id: 67, input: "(exact->inexact 100000000000000000000)", expect: "1e+20"},
+		{id: 68, input: "(+ 0.5 100000000000000000000)", expect: "1e+20"},
+		{id: 69, input: "(inexact->exact 1e20)", expect: "100000000000000000000"},
+		{id: 70, input: "(expt 2 100)", expect: "1267650600228229401496703205376"},
+		{id: 71, input: "(sqrt (expt 2 100))", expect: "1125899906842624"},
+		{id: 72, input: "(expt (expt 2 100) 2)", expect: "1606938044258990275541962092341162602522202993782792835301376"},
+		{id: 73, input: "(number->string (expt 10 20))", expect: `"100000000000000000000"`},
+		{id: 74, input: `(string->number "100000000000000000000")`, expect: "100000000000000000000"},
+		{id: 75, input: "(/ 1 3)", expect: "1/3"},
+		{id: 76, input: "(/ 6 4)", expect: "3/2"},
+		{id: 77, input: "(/ 6 -4)", expect: "-3/2"},
+		{id: 78, input: "(/ 6 3)", expect: "2"},
+		{id: 79, input: "2/4", expect: "1/2"},
+		{id: 80, input: "-10/5", expect: "-2"},
+		{id: 81, input: "'(1/-2 /2 1/ a/b)", expect: "(1/-2 /2 1/ A/B)"},
+		{id: 82, input: "(+ 1/3 2/3)", expect: "1"},
+		{id: 83, input: "(+ 1/3 1/6)", expect: "1/2"},
+		{id: 84, input: "(* 2/3 3/4)", expect: "1/2"},
+		{id: 85, input: "(- 1/2 1)", expect: "-1/2"},
+		{id: 86, input: "(+ 1/2 0.25)", expect: "0.75"},
+		{id: 87, input: "(< 1/3 0.34)", expect: "#t"},
+		{id: 88, input: "(< 1/3 1/4)", expect: "#f"},
+		{id: 89, input: "(= 1/2 0.5)", expect: "#t"},
+		{id: 90, input: "(eq? 1/2 2/4)", expect: "#t"},
+		{id: 91, input: "(numerator 6/4)", expect: "3"},
+		{id: 92, input: "(denominator 6/4)", expect: "2"},
+		{id: 93, input: "(denominator 5)", expect: "1"},
+		{id: 94, input: "(numerator 0.5)", expect: "1.0"},
+		{id: 95, input: "(denominator 0.5)", expect: "2.0"},
+		{id: 96, input: "(/ 1 0)", expect: "NIL", err: Error_DivideByZero},
+		{id: 97, input: "(/ 1/2 0)", expect: "NIL", err: Error_DivideByZero},
+		{id: 98, input: "(/ (expt 10 20) 0)", expect: "NIL", err: Error_DivideByZero},
+		{id: 99, input: "(/ (expt 10 20) (expt 10 21))", expect: "1/10"},
+		{id: 100, input: "(exact->inexact 1/4)", expect: "0.25"},
+		{id: 101, input: "(floor 7/2)", expect: "3"},
+		{id: 102, input: "(floor -7/2)", expect: "-4"},
+		{id: 103, input: "(ceiling 7/2)", expect: "4"},
+		{id: 104, input: "(truncate -7/2)", expect: "-3"},
+		{id: 105, input: "(round 7/2)", expect: "4"},
+		{id: 106, input: "(round 5/2)", expect: "2"},
+		{id: 107, input: "(round 8/3)", expect: "3"},
+		{id: 108, input: "(sqrt 9/4)", expect: "3/2"},
+		{id: 109, input: "(expt 2/3 2)", expect: "4/9"},
+		{id: 110, input: "(expt 2/3 -2)", expect: "9/4"},
+		{id: 111, input: "(expt 0 -1)", expect: "NIL", err: Error_DivideByZero},
+		{id: 112, input: "(exact? 1/2)", expect: "#t"},
+		{id: 113, input: "(integer? 1/2)", expect: "#f"},
+		{id: 114, input: "(quotient 7 2)", expect: "3"},
+		{id: 115, input: "(quotient -7 2)", expect: "-3"},
+		{id: 116, input: "(remainder -7 2)", expect: "-1"},
+		{id: 117, input: "(modulo -7 2)", expect: "1"},
+		{id: 118, input: "(modulo 7 -2)", expect: "-1"},
+		{id: 119, input: "(quotient 7 0)", expect: "NIL", err: Error_DivideByZero},
+		{id: 120, input: "(quotient 7.0 2)", expect: "NIL", err: Error_Type},
+		{id: 121, input: "(modulo (expt 10 20) 7)", expect: "2"},
+		{id: 122, input: "(quotient -9223372036854775808 -1)", expect: "9223372036854775808"},
+		{id: 123, input: `(string->number "3/4")`, expect: "3/4"},
+		{id: 124, input: "1/0", expect: "NIL", err: Error_Syntax},
+		{id: 125, input: "'(1 -2/00)", expect: "NIL", err: Error_Syntax},
+		{id: 126, input: `(string->number "1/0")`, expect: "#f"},
+	} {
+		result, err := l.EvalString(tc.input)
+		if tc.err == nil && err == nil {
+			// yay
+		} else if tc.err == nil && err != nil {
+			t.Errorf("%d: error: want nil: got %v\n", tc.id, err)
+		} else if !errors.Is(err, tc.err) {
+			t.Errorf("%d: error: want %v: got %v\n", tc.id, tc.err, err)
+		}
+		if got := result.String(); tc.expect != got {
+			t.Errorf("%d: eval: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+}
+
+func TestPositions(t *testing.T) {
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, input: "(car 1)", expect: "test.lisp:1:1", err: Error_Type},
+		{id: 2, input: "(define (f x)\n  (car x))\n(f 1)", expect: "test.lisp:2:3", err: Error_Type},
+		{id: 3, input: "(+ 1\n   foo)", expect: "test.lisp:1:1", err: Error_Unbound},
+		{id: 4, input: "(define (g x) x)\n\n  (g 1 2)", expect: "test.lisp:3:3", err: Error_Args},
+		{id: 5, input: "(quote\n  (a b)\n  (foo", expect: "test.lisp:3:3", err: Error_Syntax},
+		{id: 6, input: "(a . b c)", expect: "test.lisp:1:8", err: Error_Syntax},
+		{id: 7, input: "1\n  )", expect: "test.lisp:2:3", err: Error_Syntax},
+		{id: 8, input: `"héllo" (car "é")`, expect: "test.lisp:1:9", err: Error_Type},
+		{id: 9, input: "(if 1)", expect: "test.lisp:1:1", err: Error_Args},
+		{id: 10, input: "(list 1\n  1/0)", expect: "test.lisp:2:3", err: Error_Syntax},
+	} {
+		l := NewInterpreter()
+		err := l.load("test.lisp", []byte(tc.input))
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%d: error: want %v: got %v\n", tc.id, tc.err, err)
+		}
+		var e *LispError
+		if !errors.As(err, &e) || e.Pos == nil {
+			t.Errorf("%d: position: want %q: got none\n", tc.id, tc.expect)
+		} else if got := e.Pos.String(); tc.expect != got {
+			t.Errorf("%d: position: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+
+	// the reader records the position of each list and its elements
+	l := NewInterpreter()
+	l.source = new_source("", []byte("(a\n (b c) 'd)"))
+	var expr Atom
+	if _, err := l.read_expr(l.source.text, &expr); err != nil {
+		t.Fatalf("read: error: want nil: got %v\n", err)
+	}
+	for _, tc := range []struct {
+		id     int
+		expr   Atom
+		expect string
+	}{
+		{id: 10, expr: expr, expect: "1:1"},
+		{id: 11, expr: cdr(expr), expect: "2:2"},
+		{id: 12, expr: car(cdr(expr)), expect: "2:2"},
+		{id: 13, expr: cdr(car(cdr(expr))), expect: "2:5"},
+		{id: 14, expr: car(cdr(cdr(expr))), expect: "2:8"},
+	} {
+		if pos := pos_of(tc.expr); pos == nil {
+			t.Errorf("%d: position: want %q: got none\n", tc.id, tc.expect)
+		} else if got := pos.String(); tc.expect != got {
+			t.Errorf("%d: position: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	l := NewInterpreter()
+	if err := l.LoadLibrary(); err != nil {
+		t.Fatalf("library: error: want nil: got %v\n", err)
+	}
+	if _, err := l.EvalString("(define (f x) (car x)) (define (g x y) (list (f x) y))"); err != nil {
+		t.Fatalf("define: error: want nil: got %v\n", err)
+	}
+
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+		stack  string
+	}{
+		{id: 1, input: "foo", expect: "unbound: FOO", err: Error_Unbound},
+		{id: 2, input: "(car 1)", expect: "1:1: type in CAR: expected pair, got integer: 1", err: Error_Type},
+		{id: 3, input: "(cons 1)", expect: "1:1: args in CONS: expected 2, got 1", err: Error_Args},
+		{id: 4, input: "(f 1 2)", expect: "1:1: args in F: expected 1, got 2", err: Error_Args, stack: "F at 1:1"},
+		{id: 5, input: "(+ 1 (g 1 2))", expect: "1:15: type in CAR: expected pair, got integer: 1", err: Error_Type, stack: "LIST at 1:40, + at 1:1"},
+		{id: 6, input: "(1 2)", expect: "1:1: type: expected procedure, got integer: 1", err: Error_Type, stack: "? at 1:1"},
+		{id: 7, input: "((lambda (x . y) x))", expect: "1:1: args: expected at least 1, got 0", err: Error_Args, stack: "#<closure> at 1:1"},
+		{id: 8, input: `(substring "abc" 2 1)`, expect: "1:1: range in SUBSTRING: 1", err: Error_Range},
+		{id: 9, input: "(if 1)", expect: "1:1: args: expected 3, got 1", err: Error_Args},
+		{id: 10, input: "(car (lambda (x) x))", expect: "1:1: type in CAR: expected pair, got closure: #<CLOSURE>", err: Error_Type},
+		{id: 11, input: "(- f 1)", expect: "1:1: type in -: expected number, got closure: #<CLOSURE>", err: Error_Type},
+	} {
+		_, err := l.EvalString(tc.input)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%d: error: want %v: got %v\n", tc.id, tc.err, err)
+		}
+		var e *LispError
+		if !errors.As(err, &e) {
+			t.Errorf("%d: error: want *LispError: got %T\n", tc.id, err)
+			continue
+		}
+		if got := e.Error(); tc.expect != got {
+			t.Errorf("%d: error: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+		if got := strings.Join(e.Stack, ", "); tc.stack != got {
+			t.Errorf("%d: stack: want %q: got %q\n", tc.id, tc.stack, got)
+		}
+	}
+}
+
+func TestConditions(t *testing.T) { in_each_mode(t, test_conditions) }
 
+func test_conditions(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	if err := l.LoadLibrary(); err != nil {
+		t.Fatalf("library: error: want nil: got %v\n", err)
+	}
+
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, input: "(guard (e (t (list 'caught e))) (raise 42))", expect: "(CAUGHT 42)"},
+		{id: 2, input: `(guard (e ((error-object? e) (error-object-message e))) (error "bad thing" 1 2))`, expect: `"bad thing"`},
+		{id: 3, input: `(guard (e ((error-object? e) (error-object-irritants e))) (error "bad thing" 1 2))`, expect: "(1 2)"},
+		{id: 4, input: "(guard (e ((eq? e 5) 'five) (else 'else)) (+ 1 (car 5)))", expect: "ELSE"},
+		{id: 5, input: "(guard (e ((error-object? e) (error-object-irritants e))) undefined-thing)", expect: "(UNDEFINED-THING)"},
+		{id: 6, input: "(guard (e ((car e) => list)) (raise '(1 2)))", expect: "(1)"},
+		{id: 7, input: "(guard (e ((pair? e))) (raise '(1 2)))", expect: "#t"},
+		{id: 8, input: "(+ 1 (guard (e (t 10)) (raise 'x)))", expect: "11"},
+		{id: 9, input: "(guard (e (t (list 'outer e))) (guard (e ((pair? e) 'inner)) (raise 'sym)))", expect: "(OUTER SYM)"},
+		{id: 10, input: "(guard (e (t 'never)) 5)", expect: "5"},
+		{id: 11, input: "(define (safe-div a b) (guard (e ((error-object? e) 'div0)) (/ a b)))", expect: "SAFE-DIV"},
+		{id: 12, input: "(safe-div 1 0)", expect: "DIV0"},
+		{id: 13, input: "(safe-div 4 2)", expect: "2"},
+		{id: 14, input: "(guard (e ((eq? e 'x) 'no)) (raise 'y))", expect: "NIL", err: Error_Raise},
+		{id: 15, input: "(guard (e ((eq? e 'x) 'no)) (car 5))", expect: "NIL", err: Error_Type},
+		{id: 16, input: `(error "bad" 1 "two")`, expect: "NIL", err: Error_Raise},
+		{id: 17, input: `(guard (e (t e)) (error "bad" 1 "two"))`, expect: `#<CONDITION "bad" 1 "two">`},
+		{id: 18, input: "(guard (e (t (error-object-message e))) (cons 1))", expect: `"1:41: args in CONS: expected 2, got 1"`},
+		{id: 19, input: "(guard (e (t (raise e))) (car 5))", expect: "NIL", err: Error_Type},
+		{id: 20, input: "(error 'oops)", expect: "NIL", err: Error_Type},
+		{id: 21, input: "(guard (e) 1 2)", expect: "2"},
+		{id: 22, input: "(guard e 1)", expect: "NIL", err: Error_Syntax},
+		{id: 23, input: "(call/cc (lambda (k) (guard (e (t 'caught)) (k 'escaped))))", expect: "ESCAPED"},
+	} {
+		result, err := l.EvalString(tc.input)
+		if tc.err == nil && err == nil {
+			// yay
+		} else if tc.err == nil && err != nil {
+			t.Errorf("%d: error: want nil: got %v\n", tc.id, err)
+		} else if !errors.Is(err, tc.err) {
+			t.Errorf("%d: error: want %v: got %v\n", tc.id, tc.err, err)
+		}
+		if got := result.String(); tc.expect != got {
+			t.Errorf("%d: eval: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+}
+
+func TestScopes(t *testing.T) { in_each_mode(t, test_scopes) }
+
+func test_scopes(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	if err := l.LoadLibrary(); err != nil {
+		t.Fatalf("library: error: want nil: got %v\n", err)
+	}
+
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, input: "(define x 'global)", expect: "X"},
+		{id: 2, input: "(define (get-x) x)", expect: "GET-X"},
+		{id: 3, input: "((lambda (x) (get-x)) 'local)", expect: "GLOBAL"},
+		{id: 4, input: "((lambda (x) ((lambda () x))) 'local)", expect: "LOCAL"},
+		{id: 5, input: "(define x 'redefined)", expect: "X"},
+		{id: 6, input: "(get-x)", expect: "REDEFINED"},
+		{id: 7, input: "(define (f a) (define b (* a 2)) (define (g c) (+ a b c)) (g 1))", expect: "F"},
+		{id: 8, input: "(f 10)", expect: "31"},
+		{id: 9, input: "(define (make-counter) (define n 0) (lambda () (define n2 (+ n 1)) (define n n2) n))", expect: "MAKE-COUNTER"},
+		{id: 10, input: "(define c (make-counter))", expect: "C"},
+		{id: 11, input: "(c)", expect: "1"},
+		{id: 12, input: "(c)", expect: "1"},
+		{id: 13, input: "(define (h) (list x (define x 'inner) x))", expect: "H"},
+		{id: 14, input: "(h)", expect: "(REDEFINED X INNER)"},
+		{id: 15, input: "x", expect: "REDEFINED"},
+		{id: 16, input: "(define (k . args) args)", expect: "K"},
+		{id: 17, input: "(k 1 2 3)", expect: "(1 2 3)"},
+		{id: 18, input: "((lambda (a) (guard (e (t (list a e))) (raise 'oops))) 'arg)", expect: "(ARG OOPS)"},
+		{id: 19, input: "(define (get-y) y)", expect: "GET-Y"},
+		{id: 20, input: "(get-y)", expect: "NIL", err: Error_Unbound},
+		{id: 21, input: "(define y 'late)", expect: "Y"},
+		{id: 22, input: "(get-y)", expect: "LATE"},
+		{id: 23, input: "(define (old) 'old)", expect: "OLD"},
+		{id: 24, input: "(define (call-old) (old))", expect: "CALL-OLD"},
+		{id: 25, input: "(call-old)", expect: "OLD"},
+		{id: 26, input: "(define (old) 'new)", expect: "OLD"},
+		{id: 27, input: "(call-old)", expect: "NEW"},
+		{id: 28, input: "(define (use-later v) (later v))", expect: "USE-LATER"},
+		{id: 29, input: "(defmacro (later e) (list 'quote e))", expect: "LATER"},
+		{id: 30, input: "(use-later 1)", expect: "V"},
+		{id: 31, input: "(defmacro (define-w) '(define w 'macro))", expect: "DEFINE-W"},
+		{id: 32, input: "(define (get-w) (define-w) w)", expect: "GET-W"},
+		{id: 33, input: "(get-w)", expect: "MACRO"},
+		{id: 34, input: "(define (adder) (define n 0) (lambda (d) (set! n (+ n d)) n))", expect: "ADDER"},
+		{id: 35, input: "(define add (adder))", expect: "ADD"},
+		{id: 36, input: "(list (add 2) (add 3) ((adder) 1))", expect: "(2 5 1)"},
+		{id: 37, input: "((((lambda (a) (lambda (b) (lambda (c) (list a b c)))) 1) 2) 3)", expect: "(1 2 3)"},
+		{id: 38, input: "((lambda (a a) a) 1 2)", expect: "2"},
+		{id: 39, input: "((lambda (a) (set! a 'set) a) 1)", expect: "SET"},
+		{id: 40, input: "(define (quote-later) (later-form (let ((x 1)) x)))", expect: "QUOTE-LATER"},
+		{id: 41, input: "(defmacro (later-form e) (list 'quote e))", expect: "LATER-FORM"},
+		{id: 42, input: "(quote-later)", expect: "(LET ((X 1)) X)"},
+		{id: 43, input: "(define (classify n) (cond ((< n 0) 'neg) ((= n 0) 'zero) (else (let loop ((i n) (acc '())) (if (= i 0) acc (loop (- i 1) (cons i acc)))))))", expect: "CLASSIFY"},
+		{id: 44, input: "(list (classify -1) (classify 0) (classify 3) (classify 2))", expect: "(NEG ZERO (1 2 3) (1 2))"},
+		{id: 45, input: "(define (count-to n) (do ((i 0 (+ i 1)) (acc '() (cons i acc))) ((= i n) acc)))", expect: "COUNT-TO"},
+		{id: 46, input: "(list (count-to 3) (count-to 2))", expect: "((2 1 0) (1 0))"},
+		{id: 47, input: "(define (bad) (let ((1 2)) 3))", expect: "BAD"},
+		{id: 48, input: "(bad)", expect: "NIL", err: Error_Syntax},
+	} {
+		result, err := l.EvalString(tc.input)
 		if tc.err == nil && err == nil {
 			// yay
 		} else if tc.err == nil && err != nil {
@@ -618,3 +1365,818 @@ func TestChapter14(t *testing.T) {
 		}
 	}
 }
+
+func TestHeap(t *testing.T) { in_each_mode(t, test_heap) }
+
+func test_heap(t *testing.T, opts ...Option) {
+	// collect as often as possible to find cells and symbols that are freed too soon
+	l := NewInterpreter(append(opts, WithHeap(1), WithWeakSymbols())...)
+	if err := l.LoadLibrary(); err != nil {
+		t.Fatalf("library: error: want nil: got %v\n", err)
+	}
+
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, input: "(define (count n) (if (= n 0) t (count (- n 1))))", expect: "COUNT"},
+		{id: 2, input: "(count 10000)", expect: "#t"},
+		{id: 3, input: "(map (lambda (x) (* x x)) (list 1 2 3 4 5))", expect: "(1 4 9 16 25)"},
+		{id: 4, input: "(define r (list 1 (call/cc (lambda (k) k)) 3))", expect: "R"},
+		{id: 5, input: "(if (pair? (cdr r)) ((car (cdr r)) 2) r)", expect: "R"},
+		{id: 6, input: "r", expect: "(1 2 3)"},
+		{id: 7, input: "(guard (e ((error-object? e) (error-object-irritants e))) (car 5))", expect: "(5)"},
+		{id: 8, input: "(let ((a 1) (b 2)) `(x ,a ,@(list b b)))", expect: "(X 1 2 2)"},
+		{id: 9, input: "(define (fib n) (if (< n 2) n (+ (fib (- n 1)) (fib (- n 2)))))", expect: "FIB"},
+		{id: 10, input: "(fib 12)", expect: "144"},
+		{id: 11, input: "(gc 1)", expect: "NIL", err: Error_Args},
+		{id: 12, input: "(car (car (heap-stats)))", expect: "COLLECTIONS"},
+		{id: 13, input: "(define vec (vector (list 1 2) (list 3)))", expect: "VEC"},
+		{id: 14, input: "(count 1000)", expect: "#t"},
+		{id: 15, input: "vec", expect: "#((1 2) (3))"},
+		{id: 16, input: "(define tab (make-hash-table))", expect: "TAB"},
+		{id: 17, input: "(hash-table-set! tab (list 1) (list 2))", expect: "NIL"},
+		{id: 18, input: "(count 1000)", expect: "#t"},
+		{id: 19, input: "(list (hash-table-keys tab) (hash-table-ref tab (list 1)))", expect: "(((1)) (2))"},
+	} {
+		result, err := l.EvalString(tc.input)
+		if tc.err == nil && err == nil {
+			// yay
+		} else if tc.err == nil && err != nil {
+			t.Errorf("%d: error: want nil: got %v\n", tc.id, err)
+		} else if !errors.Is(err, tc.err) {
+			t.Errorf("%d: error: want %v: got %v\n", tc.id, tc.err, err)
+		}
+		if got := result.String(); tc.expect != got {
+			t.Errorf("%d: eval: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+
+	// garbage from the loop in COUNT is reused, so the heap doesn't grow
+	stats := l.HeapStats()
+	if stats.Collections == 0 || stats.Freed == 0 {
+		t.Errorf("stats: want collections and freed cells: got %+v\n", stats)
+	}
+	if size := stats.Live + stats.Free; size != l.heap.pairs.size()+len(l.heap.builtins) {
+		t.Errorf("stats: want %d cells: got %d\n", l.heap.pairs.size()+len(l.heap.builtins), size)
+	} else if size > 10000 {
+		t.Errorf("stats: want fewer than 10000 cells: got %d\n", size)
+	}
+
+	// an interpreter without a managed heap doesn't collect
+	if n := NewInterpreter().GC(); n != 0 {
+		t.Errorf("gc: want 0: got %d\n", n)
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	l := NewInterpreter()
+
+	// symbols with the same name are the same pointer, whatever the case
+	if a, b := l.make_sym([]byte("foo")), l.make_sym([]byte("FOO")); a.value.symbol() != b.value.symbol() {
+		t.Errorf("intern: want same symbol: got %p and %p\n", a.value.symbol(), b.value.symbol())
+	}
+	if a, b := l.make_sym([]byte("foo")), l.make_sym([]byte("bar")); a.value.symbol() == b.value.symbol() {
+		t.Errorf("intern: want distinct symbols: got %p\n", a.value.symbol())
+	}
+
+	for _, tc := range []struct {
+		id      int
+		opts    []Option
+		removed bool
+	}{
+		{id: 1, opts: []Option{WithHeap(0)}, removed: false},
+		{id: 2, opts: []Option{WithHeap(0), WithWeakSymbols()}, removed: true},
+		{id: 3, opts: []Option{WithWeakSymbols()}, removed: false},
+	} {
+		l := NewInterpreter(tc.opts...)
+		kept := l.make_sym([]byte("kept-symbol")).value.symbol()
+		if _, err := l.EvalString("(define keep 'kept-symbol) (car '(garbage-one garbage-two))"); err != nil {
+			t.Fatalf("%d: eval: error: want nil: got %v\n", tc.id, err)
+		}
+		l.GC()
+		if _, ok := l.symbols["GARBAGE-TWO"]; ok == tc.removed {
+			t.Errorf("%d: garbage: want removed %v: got %v\n", tc.id, tc.removed, !ok)
+		}
+		if _, ok := l.symbols["CAR"]; !ok {
+			t.Errorf("%d: car: want interned: got removed\n", tc.id)
+		}
+		// symbols that are still used keep their identity
+		if sym := l.make_sym([]byte("kept-symbol")).value.symbol(); sym != kept {
+			t.Errorf("%d: kept: want %p: got %p\n", tc.id, kept, sym)
+		}
+	}
+}
+
+func TestCaseSensitive(t *testing.T) { in_each_mode(t, test_case_sensitive) }
+
+func test_case_sensitive(t *testing.T, opts ...Option) {
+	l := NewInterpreter(append(opts, WithCaseSensitive())...)
+	if err := l.LoadLibrary(); err != nil {
+		t.Fatalf("library: error: want nil: got %v\n", err)
+	}
+
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, input: "(define foo 1)", expect: "foo"},
+		{id: 2, input: "(define FOO 2)", expect: "FOO"},
+		{id: 3, input: "(list foo FOO)", expect: "(1 2)"},
+		{id: 4, input: "'Hello", expect: "Hello"},
+		{id: 5, input: "(QUOTE x)", expect: "NIL", err: Error_Unbound},
+		{id: 6, input: "(let ((a 1)) `(x ,a))", expect: "(x 1)"},
+		{id: 7, input: "(eq? 'abc 'ABC)", expect: "#f"},
+		{id: 8, input: "(eq? 'abc 'abc)", expect: "#t"},
+		{id: 9, input: "(symbol->string 'MixedCase)", expect: `"MixedCase"`},
+		{id: 10, input: "(guard (e (else 'caught)) (raise 1))", expect: "caught"},
+		{id: 11, input: "(guard (e ((car e) => list)) (raise '(1 2)))", expect: "(1)"},
+		{id: 12, input: "(eq? Nil nil)", expect: "#t"},
+		{id: 13, input: "(call/cc (lambda (k) (k 'out)))", expect: "out"},
+	} {
+		result, err := l.EvalString(tc.input)
+		if tc.err == nil && err == nil {
+			// yay
+		} else if tc.err == nil && err != nil {
+			t.Errorf("%d: error: want nil: got %v\n", tc.id, err)
+		} else if !errors.Is(err, tc.err) {
+			t.Errorf("%d: error: want %v: got %v\n", tc.id, tc.err, err)
+		}
+		if got := result.String(); tc.expect != got {
+			t.Errorf("%d: eval: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+
+	// case is folded by default
+	if result, err := NewInterpreter(opts...).EvalString("(eq? 'abc 'ABC)"); err != nil || result.String() != "#t" {
+		t.Errorf("default: want #t: got %s %v\n", result.String(), err)
+	}
+}
+
+func TestComments(t *testing.T) {
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, input: "; comment\n42", expect: "42"},
+		{id: 2, input: "(foo ; comment\n bar)", expect: "(FOO BAR)"},
+		{id: 3, input: "(foo;comment\nbar)", expect: "(FOO BAR)"},
+		{id: 4, input: "(foo #| block |# bar)", expect: "(FOO BAR)"},
+		{id: 5, input: "#| outer #| inner |# still outer |# 42", expect: "42"},
+		{id: 6, input: "(foo #;bar baz)", expect: "(FOO BAZ)"},
+		{id: 7, input: "(foo #;(bar (baz)) qux)", expect: "(FOO QUX)"},
+		{id: 8, input: "(foo #; #;a b c)", expect: "(FOO C)"},
+		{id: 9, input: "(foo #;'bar)", expect: "(FOO)"},
+		{id: 10, input: "(foo . #;bar baz)", expect: "(FOO . BAZ)"},
+		{id: 11, input: `(foo "; not a comment")`, expect: `(FOO "; not a comment")`},
+		{id: 12, input: "; only a comment", expect: "NIL", err: Error_EndOfInput},
+		{id: 13, input: "#| unterminated", expect: "NIL", err: Error_Syntax},
+		{id: 14, input: "(foo #| unterminated)", expect: "NIL", err: Error_Syntax},
+	} {
+		// both readers skip comments
+		l := NewInterpreter()
+		var expr Atom
+		_, err := l.read_expr([]byte(tc.input), &expr)
+		if err != nil {
+			expr = _nil
+		}
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%d: read_expr: error: want %v: got %v\n", tc.id, tc.err, err)
+		} else if got := expr.String(); tc.expect != got {
+			t.Errorf("%d: read_expr: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+		expr, _, err = l.read([]byte(tc.input))
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%d: read: error: want %v: got %v\n", tc.id, tc.err, err)
+		} else if got := expr.String(); tc.expect != got {
+			t.Errorf("%d: read: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+
+	// the text of the comments is kept if the interpreter asks for it
+	l := NewInterpreter(WithComments())
+	input := "; one\n(foo #| two |#\n #;(three) . bar) ; four"
+	_, rest, err := l.Read([]byte(input))
+	if err != nil {
+		t.Fatalf("read: error: want nil: got %v\n", err)
+	}
+	// the comment after the last expression is skipped looking for the next one
+	if _, _, err = l.Read(rest); !errors.Is(err, Error_EndOfInput) {
+		t.Fatalf("read: error: want %v: got %v\n", Error_EndOfInput, err)
+	}
+	expect := []string{"1:1 \"; one\"", "2:6 \"#| two |#\"", "3:2 \"#;(three)\"", "3:19 \"; four\""}
+	if got := l.Comments(); len(got) != len(expect) {
+		t.Errorf("comments: want %d: got %d\n", len(expect), len(got))
+	} else {
+		for n, c := range got {
+			if s := fmt.Sprintf("%s %q", c.Pos, c.Text); expect[n] != s {
+				t.Errorf("comments: %d: want %s: got %s\n", n, expect[n], s)
+			}
+		}
+	}
+	if got := NewInterpreter().Comments(); got != nil {
+		t.Errorf("comments: want nil: got %v\n", got)
+	}
+}
+
+func TestBooleans(t *testing.T) { in_each_mode(t, test_booleans) }
+
+func test_booleans(t *testing.T, opts ...Option) {
+	for _, tc := range []struct {
+		id        int
+		input     string
+		expect    string
+		nil_false string // the result in the compatibility mode, if different
+	}{
+		{id: 1, input: "#t", expect: "#t"},
+		{id: 2, input: "#F", expect: "#f"},
+		{id: 3, input: "'(#true #false)", expect: "(#t #f)"},
+		{id: 4, input: "(boolean? #f)", expect: "#t"},
+		{id: 5, input: "(boolean? nil)", expect: "#f"},
+		{id: 6, input: "(boolean? 't)", expect: "#f"},
+		{id: 7, input: "t", expect: "#t"},
+		{id: 8, input: "(if #f 'yes 'no)", expect: "NO"},
+		{id: 9, input: "(if nil 'yes 'no)", expect: "YES", nil_false: "NO"},
+		{id: 10, input: "(if '() 'yes 'no)", expect: "YES", nil_false: "NO"},
+		{id: 11, input: "(if 0 'yes 'no)", expect: "YES"},
+		{id: 12, input: "(eq? #t (= 1 1))", expect: "#t"},
+		{id: 13, input: "(eq? #f nil)", expect: "#f"},
+		{id: 14, input: "(not #f)", expect: "#t"},
+		{id: 15, input: "(not nil)", expect: "#f"},
+		{id: 16, input: "(null? '())", expect: "#t"},
+		{id: 17, input: `(string->number "x")`, expect: "#f"},
+		{id: 18, input: "(guard (e (#f 'never) ((eq? e 2) 'two)) (raise 2))", expect: "TWO"},
+		{id: 19, input: "(map (lambda (x) (< x 2)) '(1 2 3))", expect: "(#t #f #f)"},
+	} {
+		for _, nil_false := range []bool{false, true} {
+			expect := tc.expect
+			l := NewInterpreter(opts...)
+			if nil_false {
+				l = NewInterpreter(append(opts, WithNilFalse())...)
+				if tc.nil_false != "" {
+					expect = tc.nil_false
+				}
+			}
+			if err := l.LoadLibrary(); err != nil {
+				t.Fatalf("library: error: want nil: got %v\n", err)
+			}
+			result, err := l.EvalString(tc.input)
+			if err != nil {
+				t.Errorf("%d: nil false %v: error: want nil: got %v\n", tc.id, nil_false, err)
+			} else if got := result.String(); expect != got {
+				t.Errorf("%d: nil false %v: eval: want %q: got %q\n", tc.id, nil_false, expect, got)
+			}
+		}
+	}
+}
+
+func TestVectors(t *testing.T) { in_each_mode(t, test_vectors) }
+
+// TestVectorsBuiltin checks that the vector procedures don't need the library.
+func TestVectorsBuiltin(t *testing.T) {
+	l := NewInterpreter()
+	result, err := l.EvalString("(vector-map (lambda (x y) (* x y)) #(1 2 3) #(4 5 6))")
+	if err != nil {
+		t.Fatalf("vector-map: error: want nil: got %v\n", err)
+	} else if expect, got := "#(4 10 18)", result.String(); expect != got {
+		t.Errorf("vector-map: want %q: got %q\n", expect, got)
+	}
+}
+
+func test_vectors(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	if err := l.LoadLibrary(); err != nil {
+		t.Fatalf("library: error: want nil: got %v\n", err)
+	}
+
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, input: "#(1 2 3)", expect: "#(1 2 3)"},
+		{id: 2, input: "#()", expect: "#()"},
+		{id: 3, input: "'#(a #(b) (c))", expect: "#(A #(B) (C))"},
+		{id: 4, input: `(vector 1 'a "s")`, expect: `#(1 A "s")`},
+		{id: 5, input: "(define v (make-vector 3 0))", expect: "V"},
+		{id: 6, input: "(vector-set! v 1 'x)", expect: "NIL"},
+		{id: 7, input: "v", expect: "#(0 X 0)"},
+		{id: 8, input: "(vector-ref v 1)", expect: "X"},
+		{id: 9, input: "(vector-ref v 3)", expect: "NIL", err: Error_Range},
+		{id: 10, input: "(vector-ref v -1)", expect: "NIL", err: Error_Range},
+		{id: 11, input: "(vector-ref v 'a)", expect: "NIL", err: Error_Type},
+		{id: 12, input: "(vector-set! v 3 'x)", expect: "NIL", err: Error_Range},
+		{id: 13, input: "(vector-length v)", expect: "3"},
+		{id: 14, input: "(vector-fill! v 7)", expect: "NIL"},
+		{id: 15, input: "v", expect: "#(7 7 7)"},
+		{id: 16, input: "(vector->list #(1 (2) 3))", expect: "(1 (2) 3)"},
+		{id: 17, input: "(list->vector '(1 2))", expect: "#(1 2)"},
+		{id: 18, input: "(list->vector '(1 . 2))", expect: "NIL", err: Error_Type},
+		{id: 19, input: "(vector-map + #(1 2) #(10 20))", expect: "#(11 22)"},
+		{id: 20, input: "(vector? #(1))", expect: "#t"},
+		{id: 21, input: "(vector? '(1))", expect: "#f"},
+		{id: 22, input: "(make-vector 2)", expect: "#(NIL NIL)"},
+		{id: 23, input: "(make-vector -1)", expect: "NIL", err: Error_Range},
+		{id: 24, input: "(eq? v v)", expect: "#t"},
+		{id: 25, input: "(eq? #(1) #(1))", expect: "#f"},
+		{id: 26, input: "#(1 . 2)", expect: "NIL", err: Error_Syntax},
+		{id: 27, input: "#(1 2", expect: "NIL", err: Error_Syntax},
+		{id: 28, input: "(vector-length '(1 2))", expect: "NIL", err: Error_Type},
+		{id: 29, input: "(make-vector 1000000000000000000)", expect: "NIL", err: Error_Range},
+		{id: 30, input: "(make-vector 100000000000000000000)", expect: "NIL", err: Error_Range},
+		{id: 31, input: "(vector-map (lambda (x) (* x x)) #(1 2 3))", expect: "#(1 4 9)"},
+		{id: 32, input: "(vector-map cons #(1 2 3) #(a b))", expect: "#((1 . A) (2 . B))"},
+		{id: 33, input: "(vector-map car #())", expect: "#()"},
+		{id: 34, input: "(vector-map car)", expect: "NIL", err: Error_Args},
+		{id: 35, input: "(vector-map car '(1 2))", expect: "NIL", err: Error_Type},
+		{id: 36, input: "(vector-map car #(1))", expect: "NIL", err: Error_Type},
+	} {
+		result, err := l.EvalString(tc.input)
+		if tc.err == nil && err == nil {
+			// yay
+		} else if tc.err == nil && err != nil {
+			t.Errorf("%d: error: want nil: got %v\n", tc.id, err)
+		} else if !errors.Is(err, tc.err) {
+			t.Errorf("%d: error: want %v: got %v\n", tc.id, tc.err, err)
+		}
+		if got := result.String(); tc.expect != got {
+			t.Errorf("%d: eval: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+
+	// the stack-based reader reads vectors too
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, input: "#(1 #(2 3) (4))", expect: "#(1 #(2 3) (4))"},
+		{id: 2, input: "(#() . #(a))", expect: "(#() . #(A))"},
+		{id: 3, input: "#(1 . 2)", expect: "NIL", err: Error_Syntax},
+	} {
+		expr, _, err := l.read([]byte(tc.input))
+		if !errors.Is(err, tc.err) {
+			t.Errorf("read: %d: error: want %v: got %v\n", tc.id, tc.err, err)
+		} else if got := expr.String(); tc.expect != got {
+			t.Errorf("read: %d: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+}
+
+func TestHashTables(t *testing.T) { in_each_mode(t, test_hash_tables) }
+
+func test_hash_tables(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	if err := l.LoadLibrary(); err != nil {
+		t.Fatalf("library: error: want nil: got %v\n", err)
+	}
+
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, input: "(define h (make-hash-table))", expect: "H"},
+		{id: 2, input: "h", expect: "#<HASH-TABLE EQUAL? 0>"},
+		{id: 3, input: "(hash-table-set! h '(1 2) 'list)", expect: "NIL"},
+		{id: 4, input: `(hash-table-set! h "key" 'string)`, expect: "NIL"},
+		{id: 5, input: "(hash-table-set! h #(a) 'vector)", expect: "NIL"},
+		{id: 6, input: "(hash-table-ref h (list 1 2))", expect: "LIST"},
+		{id: 7, input: `(hash-table-ref h (string-append "k" "ey"))`, expect: "STRING"},
+		{id: 8, input: "(hash-table-ref h (vector 'a))", expect: "VECTOR"},
+		{id: 9, input: "(hash-table-ref h 'missing)", expect: "NIL", err: Error_Unbound},
+		{id: 10, input: "(hash-table-ref h 'missing 'default)", expect: "DEFAULT"},
+		{id: 11, input: "(hash-table-count h)", expect: "3"},
+		{id: 12, input: `(hash-table-delete! h "key")`, expect: "NIL"},
+		{id: 13, input: "(hash-table-keys h)", expect: "((1 2) #(A))"},
+		{id: 14, input: "(hash-table-set! h 1 'int)", expect: "NIL"},
+		{id: 15, input: "(hash-table-ref h 1.0 'no)", expect: "NO"},
+		{id: 16, input: "h", expect: "#<HASH-TABLE EQUAL? 3>"},
+		{id: 17, input: "(define q (make-hash-table eq?))", expect: "Q"},
+		{id: 18, input: "(hash-table-set! q (list 1) 'a)", expect: "NIL"},
+		{id: 19, input: "(hash-table-ref q (list 1) 'none)", expect: "NONE"},
+		{id: 20, input: "(hash-table-set! q 'sym 'b)", expect: "NIL"},
+		{id: 21, input: "(hash-table-ref q 'sym)", expect: "B"},
+		{id: 22, input: "(define e (make-hash-table eqv?))", expect: "E"},
+		{id: 23, input: "(hash-table-set! e 2.5 'float)", expect: "NIL"},
+		{id: 24, input: "(list (hash-table-ref e 2.5) e)", expect: "(FLOAT #<HASH-TABLE EQV? 1>)"},
+		{id: 25, input: "(make-hash-table car)", expect: "NIL", err: Error_Type},
+		{id: 26, input: "(hash-table-ref 5 1)", expect: "NIL", err: Error_Type},
+		{id: 27, input: "(define w (make-hash-table))", expect: "W"},
+		{id: 28, input: "(hash-table-walk h (lambda (k v) (hash-table-set! w v k)))", expect: "NIL"},
+		{id: 29, input: "(list (hash-table-ref w 'list) (hash-table-ref w 'int))", expect: "((1 2) 1)"},
+		{id: 30, input: "(hash-table? h)", expect: "#t"},
+		{id: 31, input: `(equal? '(1 #(2 "x")) (list 1 (vector 2 "x")))`, expect: "#t"},
+		{id: 32, input: `(eqv? "a" "a")`, expect: "#f"},
+		{id: 33, input: "(equal? 1 1.0)", expect: "#f"},
+		{id: 34, input: "(equal? '(1 . 2) '(1 2))", expect: "#f"},
+		// deleting after a key was changed must not corrupt the index
+		{id: 35, input: "(define t (make-hash-table))", expect: "T"},
+		{id: 36, input: "(define a (list 1))", expect: "A"},
+		{id: 37, input: "(define b (list 2))", expect: "B"},
+		{id: 38, input: "(hash-table-set! t a 1)", expect: "NIL"},
+		{id: 39, input: "(hash-table-set! t b 2)", expect: "NIL"},
+		{id: 40, input: "(set-car! b 3)", expect: "NIL"},
+		{id: 41, input: "(hash-table-delete! t a)", expect: "NIL"},
+		{id: 42, input: "(hash-table-ref t (list 2) 'none)", expect: "2"},
+		{id: 43, input: "(hash-table-delete! t '(2))", expect: "NIL"},
+		{id: 44, input: "(hash-table-count t)", expect: "0"},
+		// cyclic keys are keyed by EQ?
+		{id: 45, input: "(define c (list 1 2))", expect: "C"},
+		{id: 46, input: "(set-cdr! (cdr c) c)", expect: "NIL"},
+		{id: 47, input: "(hash-table-set! t c 'cyclic)", expect: "NIL"},
+		{id: 48, input: "(hash-table-ref t c)", expect: "CYCLIC"},
+		{id: 49, input: "(define v (vector 1))", expect: "V"},
+		{id: 50, input: "(vector-set! v 0 (list v))", expect: "NIL"},
+		{id: 51, input: "(hash-table-set! t v 'vector)", expect: "NIL"},
+		{id: 52, input: "(hash-table-ref t v)", expect: "VECTOR"},
+		{id: 53, input: "(define s (list 1))", expect: "S"},
+		{id: 54, input: "(hash-table-set! t (list s s) 'shared)", expect: "NIL"},
+		{id: 55, input: "(hash-table-ref t '((1) (1)))", expect: "SHARED"},
+		// walking finds keys that can't be looked up again
+		{id: 56, input: "(define n (make-hash-table eqv?))", expect: "N"},
+		{id: 57, input: "(hash-table-set! n (/ 0. 0.) 'nan)", expect: "NIL"},
+		{id: 58, input: "(hash-table-set! n 0. 'zero)", expect: "NIL"},
+		{id: 59, input: "(hash-table-set! n -0. 'minus-zero)", expect: "NIL"},
+		{id: 60, input: "(list (hash-table-ref n (/ 0. 0.)) (hash-table-ref n 0.) (hash-table-ref n -0.))", expect: "(NAN ZERO MINUS-ZERO)"},
+		{id: 61, input: "(define found nil)", expect: "FOUND"},
+		{id: 62, input: "(hash-table-walk n (lambda (k v) (set! found (cons v found))))", expect: "NIL"},
+		{id: 63, input: "found", expect: "(MINUS-ZERO ZERO NAN)"},
+		{id: 64, input: "(hash-table-walk n (lambda (k v) (hash-table-delete! n k)))", expect: "NIL"},
+		{id: 65, input: "(hash-table-count n)", expect: "0"},
+		{id: 66, input: "(hash-table-walk n car cdr)", expect: "NIL", err: Error_Args},
+		{id: 67, input: "(list (eqv? 0. -0.) (eqv? (/ 0. 0.) (/ 0. 0.)))", expect: "(#f #t)"},
+	} {
+		result, err := l.EvalString(tc.input)
+		if tc.err == nil && err == nil {
+			// yay
+		} else if tc.err == nil && err != nil {
+			t.Errorf("%d: error: want nil: got %v\n", tc.id, err)
+		} else if !errors.Is(err, tc.err) {
+			t.Errorf("%d: error: want %v: got %v\n", tc.id, tc.err, err)
+		}
+		if got := result.String(); tc.expect != got {
+			t.Errorf("%d: eval: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+}
+
+func TestChars(t *testing.T) { in_each_mode(t, test_chars) }
+
+func test_chars(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	if err := l.LoadLibrary(); err != nil {
+		t.Fatalf("library: error: want nil: got %v\n", err)
+	}
+
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, input: `#\a`, expect: `#\a`},
+		{id: 2, input: `#\A`, expect: `#\A`},
+		{id: 3, input: `#\space`, expect: `#\space`},
+		{id: 4, input: `#\NEWLINE`, expect: `#\newline`},
+		{id: 5, input: `#\x41`, expect: `#\A`},
+		{id: 6, input: `#\x`, expect: `#\x`},
+		{id: 7, input: `'(#\( #\) #\; #\")`, expect: `(#\( #\) #\; #\")`},
+		{id: 8, input: `#\λ`, expect: `#\λ`},
+		{id: 9, input: `#\bogus`, expect: "NIL", err: Error_Syntax},
+		{id: 10, input: `(list (char? #\a) (char? "a"))`, expect: "(#t #f)"},
+		{id: 11, input: `(char->integer #\A)`, expect: "65"},
+		{id: 12, input: "(integer->char 955)", expect: `#\λ`},
+		{id: 13, input: "(list (integer->char 0) (integer->char 1))", expect: `(#\null #\x1)`},
+		{id: 14, input: "(integer->char -1)", expect: "NIL", err: Error_Range},
+		{id: 15, input: "(integer->char 55296)", expect: "NIL", err: Error_Range},
+		{id: 16, input: `(list (char-upcase #\a) (char-downcase #\A) (char-upcase #\1))`, expect: `(#\A #\a #\1)`},
+		{id: 17, input: `(list (char-alphabetic? #\a) (char-alphabetic? #\1))`, expect: "(#t #f)"},
+		{id: 18, input: `(list (char-numeric? #\1) (char-numeric? #\a))`, expect: "(#t #f)"},
+		{id: 19, input: `(list (char-whitespace? #\tab) (char-whitespace? #\a))`, expect: "(#t #f)"},
+		{id: 20, input: `(list (eq? #\a #\a) (eqv? #\a #\A))`, expect: "(#t #f)"},
+		{id: 21, input: `(char-upcase "a")`, expect: "NIL", err: Error_Type},
+		{id: 22, input: `(let ((h (make-hash-table))) (hash-table-set! h #\a 1) (hash-table-ref h #\a))`, expect: "1"},
+	} {
+		result, err := l.EvalString(tc.input)
+		if tc.err == nil && err == nil {
+			// yay
+		} else if tc.err == nil && err != nil {
+			t.Errorf("%d: error: want nil: got %v\n", tc.id, err)
+		} else if !errors.Is(err, tc.err) {
+			t.Errorf("%d: error: want %v: got %v\n", tc.id, tc.err, err)
+		}
+		if got := result.String(); tc.expect != got {
+			t.Errorf("%d: eval: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+
+	// the stack-based reader reads characters too
+	if expr, _, err := l.read([]byte(`(#\a #\) #\space)`)); err != nil {
+		t.Errorf("read: error: want nil: got %v\n", err)
+	} else if got := expr.String(); got != `(#\a #\) #\space)` {
+		t.Errorf("read: want %q: got %q\n", `(#\a #\) #\space)`, got)
+	}
+}
+
+func TestMutation(t *testing.T) { in_each_mode(t, test_mutation) }
+
+func test_mutation(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	if err := l.LoadLibrary(); err != nil {
+		t.Fatalf("library: error: want nil: got %v\n", err)
+	}
+
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, input: "(define x 1)", expect: "X"},
+		{id: 2, input: "(set! x 2)", expect: "X"},
+		{id: 3, input: "x", expect: "2"},
+		{id: 4, input: "(set! undefined-name 1)", expect: "NIL", err: Error_Unbound},
+		{id: 5, input: "(set! x)", expect: "NIL", err: Error_Args},
+		{id: 6, input: "(set! 5 1)", expect: "NIL", err: Error_Type},
+		{id: 7, input: "(define (bump!) (set! x (+ x 1)) x)", expect: "BUMP!"},
+		{id: 8, input: "(list (bump!) (bump!) x)", expect: "(3 4 4)"},
+		{id: 9, input: "(define (make-counter) (define n 0) (lambda () (set! n (+ n 1)) n))", expect: "MAKE-COUNTER"},
+		{id: 10, input: "(define c1 (make-counter))", expect: "C1"},
+		{id: 11, input: "(define c2 (make-counter))", expect: "C2"},
+		{id: 12, input: "(list (c1) (c1) (c2) (c1))", expect: "(1 2 1 3)"},
+		{id: 13, input: "(define (shadow) (define x 10) (set! x (+ x 1)) x)", expect: "SHADOW"},
+		{id: 14, input: "(list (shadow) x)", expect: "(11 4)"},
+		{id: 15, input: "((lambda (y) (set! y (* y 2)) y) 21)", expect: "42"},
+		{id: 16, input: "(define p (list 1 2 3))", expect: "P"},
+		{id: 17, input: "(set-car! p 'a)", expect: "NIL"},
+		{id: 18, input: "(set-cdr! (cdr p) '(c))", expect: "NIL"},
+		{id: 19, input: "p", expect: "(A 2 C)"},
+		{id: 20, input: "(set-car! '() 1)", expect: "NIL", err: Error_Type},
+		{id: 21, input: "(set-cdr! 5 1)", expect: "NIL", err: Error_Type},
+		{id: 22, input: "(set-car! p)", expect: "NIL", err: Error_Args},
+	} {
+		result, err := l.EvalString(tc.input)
+		if tc.err == nil && err == nil {
+			// yay
+		} else if tc.err == nil && err != nil {
+			t.Errorf("%d: error: want nil: got %v\n", tc.id, err)
+		} else if !errors.Is(err, tc.err) {
+			t.Errorf("%d: error: want %v: got %v\n", tc.id, tc.err, err)
+		}
+		if got := result.String(); tc.expect != got {
+			t.Errorf("%d: eval: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+}
+
+func TestDerivedForms(t *testing.T) { in_each_mode(t, test_derived_forms) }
+
+func test_derived_forms(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	if err := l.LoadLibrary(); err != nil {
+		t.Fatalf("library: error: want nil: got %v\n", err)
+	}
+
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, input: "(begin)", expect: "NIL"},
+		{id: 2, input: "(begin 1 2 3)", expect: "3"},
+		{id: 3, input: "(begin (define bx 5) (+ bx 1))", expect: "6"},
+		{id: 4, input: "bx", expect: "5"},
+		{id: 5, input: "(list (and) (or))", expect: "(#t #f)"},
+		{id: 6, input: "(list (and 1 2) (and 1 #f 3) (or #f 2) (or #f #f))", expect: "(2 #f 2 #f)"},
+		{id: 7, input: "(list (and #f (car 5)) (or 1 (car 5)))", expect: "(#f 1)"},
+		{id: 8, input: "(and 1 (car 5))", expect: "NIL", err: Error_Type},
+		{id: 9, input: "(let ((x 1) (y 2)) (+ x y))", expect: "3"},
+		{id: 10, input: "(let () 4)", expect: "4"},
+		{id: 11, input: "(let loop ((i 0) (acc 0)) (if (= i 10) acc (loop (+ i 1) (+ acc i))))", expect: "45"},
+		{id: 12, input: "(let* ((x 1) (y (+ x 1)) (z (* y 3))) (list x y z))", expect: "(1 2 6)"},
+		{id: 13, input: "(letrec ((ev? (lambda (n) (if (= n 0) #t (od? (- n 1))))) (od? (lambda (n) (if (= n 0) #f (ev? (- n 1)))))) (list (ev? 100) (od? 7)))", expect: "(#t #t)"},
+		{id: 14, input: "(let ((1 2)) 1)", expect: "NIL", err: Error_Syntax},
+		{id: 15, input: "(let ((x)) x)", expect: "NIL", err: Error_Syntax},
+		{id: 16, input: "(let ((x 1)))", expect: "NIL", err: Error_Args},
+		{id: 17, input: "(cond (#f 1) ((+ 1 1) => (lambda (x) (* x 10))) (else 3))", expect: "20"},
+		{id: 18, input: "(list (cond (#f 1)) (cond (#f) (7)) (cond (else 1 2)))", expect: "(NIL 7 2)"},
+		{id: 19, input: "(cond 5)", expect: "NIL", err: Error_Syntax},
+		{id: 20, input: "(cond (1 =>))", expect: "NIL", err: Error_Syntax},
+		{id: 21, input: "(case (* 2 3) ((2 3 5 7) 'prime) ((1 4 6 8 9) 'composite))", expect: "COMPOSITE"},
+		{id: 22, input: "(list (case 'x ((a) 1) (else 2)) (case 1 ((2) 'no)) (case #\\a ((#\\a) 'char)))", expect: "(2 NIL CHAR)"},
+		{id: 23, input: "(case 5 ((5) => (lambda (k) (* k k))))", expect: "25"},
+		{id: 24, input: "(case 1 (1 2))", expect: "NIL", err: Error_Syntax},
+		{id: 25, input: "(list (when (< 1 2) 'a 'b) (when #f 'a) (unless #f 'c) (unless 1 'd))", expect: "(B NIL C NIL)"},
+		{id: 26, input: "(do ((vec (make-vector 5)) (i 0 (+ i 1))) ((= i 5) vec) (vector-set! vec i i))", expect: "#(0 1 2 3 4)"},
+		{id: 27, input: "(let ((x '(1 3 5 7 9))) (do ((x x (cdr x)) (sum 0 (+ sum (car x)))) ((null? x) sum)))", expect: "25"},
+		{id: 28, input: "(do ((i 0 (+ i 1))) ((= i 3)))", expect: "NIL"},
+		{id: 29, input: "(do ((i 0 (+ i 1))) ((= i 10000) i))", expect: "10000"},
+		{id: 30, input: "(do ((i 0 (+ i 1)) (j 10)) ((= i 3) (+ i j)))", expect: "13"},
+		{id: 31, input: "(do ((i)) (#t))", expect: "NIL", err: Error_Syntax},
+		{id: 32, input: "(define (id x) x)", expect: "ID"},
+		{id: 33, input: "(+ 1 (guard (e (#t (id 2))) (raise 1)))", expect: "3"},
+	} {
+		result, err := l.EvalString(tc.input)
+		if tc.err == nil && err == nil {
+			// yay
+		} else if tc.err == nil && err != nil {
+			t.Errorf("%d: error: want nil: got %v\n", tc.id, err)
+		} else if !errors.Is(err, tc.err) {
+			t.Errorf("%d: error: want %v: got %v\n", tc.id, tc.err, err)
+		}
+		if got := result.String(); tc.expect != got {
+			t.Errorf("%d: eval: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+}
+
+func TestSyntaxRules(t *testing.T) { in_each_mode(t, test_syntax_rules) }
+
+func test_syntax_rules(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	if err := l.LoadLibrary(); err != nil {
+		t.Fatalf("library: error: want nil: got %v\n", err)
+	}
+
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, input: "(define-syntax swap! (syntax-rules () ((_ a b) (let ((tmp a)) (set! a b) (set! b tmp)))))", expect: "SWAP!"},
+		{id: 2, input: "(define tmp 1)", expect: "TMP"},
+		{id: 3, input: "(define other 2)", expect: "OTHER"},
+		{id: 4, input: "(begin (swap! tmp other) (list tmp other))", expect: "(2 1)"},
+		{id: 5, input: "(let ((tmp 3) (y 4)) (swap! tmp y) (list tmp y))", expect: "(4 3)"},
+		{id: 6, input: "(define-syntax my-or (syntax-rules () ((_) #f) ((_ e) e) ((_ e r ...) (let ((t e)) (if t t (my-or r ...))))))", expect: "MY-OR"},
+		{id: 7, input: "(let ((t 5)) (my-or #f t))", expect: "5"},
+		{id: 8, input: "(list (my-or) (my-or #f #f 3))", expect: "(#f 3)"},
+		{id: 9, input: "(define-syntax kons (syntax-rules () ((_ a b) (cons a b))))", expect: "KONS"},
+		{id: 10, input: "(let ((cons list)) (kons 1 2))", expect: "(1 . 2)"},
+		{id: 11, input: "(define-syntax my-if (syntax-rules (then else) ((_ c then t else e) (if c t e))))", expect: "MY-IF"},
+		{id: 12, input: "(my-if #f then 1 else 2)", expect: "2"},
+		{id: 13, input: "(my-if #f 1 2)", expect: "NIL", err: Error_Syntax},
+		{id: 14, input: "(define-syntax my-let (syntax-rules () ((_ ((name val) ...) body1 body2 ...) ((lambda (name ...) body1 body2 ...) val ...))))", expect: "MY-LET"},
+		{id: 15, input: "(my-let ((x 1) (y 2)) (+ x y))", expect: "3"},
+		{id: 16, input: "(define-syntax flat (syntax-rules () ((_ (a b ...) ...) '(a ... (b ... ...)))))", expect: "FLAT"},
+		{id: 17, input: "(flat (1 2 3) (4) (5 6))", expect: "(1 4 5 (2 3 6))"},
+		{id: 18, input: "(define-syntax lst (syntax-rules () ((_ a ... z) '(z a ...))))", expect: "LST"},
+		{id: 19, input: "(list (lst 1 2 3) (lst 1))", expect: "((3 1 2) (1))"},
+		{id: 20, input: "(define-syntax vec (syntax-rules () ((_ #(a ...)) (list a ...))))", expect: "VEC"},
+		{id: 21, input: "(vec #(1 2 3))", expect: "(1 2 3)"},
+		{id: 22, input: "(define-syntax ell (syntax-rules ::: () ((_ a :::) '(a ::: ...))))", expect: "ELL"},
+		{id: 23, input: "(ell 1 2)", expect: "(1 2 ...)"},
+		{id: 24, input: "(let-syntax ((foo (syntax-rules () ((_ x) (* x 2))))) (foo 21))", expect: "42"},
+		{id: 25, input: "(define (twice n) (let-syntax ((dbl (syntax-rules () ((_ x) (+ x x))))) (dbl n)))", expect: "TWICE"},
+		{id: 26, input: "(twice 4)", expect: "8"},
+		{id: 27, input: "(define counter 0)", expect: "COUNTER"},
+		{id: 28, input: "(define-syntax bump! (syntax-rules () ((_) (set! counter (+ counter 1)))))", expect: "BUMP!"},
+		{id: 29, input: "(let ((counter 10)) (bump!) (bump!) counter)", expect: "10"},
+		{id: 30, input: "counter", expect: "2"},
+		{id: 31, input: "(let ((cons list) (x 2)) `(1 ,x ,@(list 3 4)))", expect: "(1 2 3 4)"},
+		{id: 32, input: "`#(1 ,(+ 1 1))", expect: "#(1 2)"},
+		{id: 33, input: "(define-syntax bad (syntax-rules () ((_ a ...) (list a))))", expect: "BAD"},
+		{id: 34, input: "(bad 1 2)", expect: "NIL", err: Error_Syntax},
+		{id: 35, input: "(define-syntax 1 (syntax-rules ()))", expect: "NIL", err: Error_Type},
+		{id: 36, input: "(define-syntax lit (syntax-rules (=>) ((_ a => b) (list a b)) ((_ a b c) 'other)))", expect: "LIT"},
+		{id: 37, input: "(lit 1 => 2)", expect: "(1 2)"},
+		{id: 38, input: "(let ((=> 5)) (lit 1 => 2))", expect: "OTHER"},
+		{id: 39, input: "(define (lit-in x) (let ((=> x)) (lit 1 => 2)))", expect: "LIT-IN"},
+		{id: 40, input: "(lit-in 5)", expect: "OTHER"},
+		{id: 41, input: "(define-syntax lit-> (syntax-rules () ((_ a b) (lit a => b))))", expect: "LIT->"},
+		{id: 42, input: "(let ((=> 5)) (lit-> 1 2))", expect: "(1 2)"},
+		{id: 43, input: "(define-syntax lit-else (syntax-rules (else) ((_ else) 'else) ((_ x) 'other)))", expect: "LIT-ELSE"},
+		{id: 44, input: "(list (lit-else else) (let ((else 1)) (lit-else else)))", expect: "(ELSE OTHER)"},
+	} {
+		result, err := l.EvalString(tc.input)
+		if tc.err == nil && err == nil {
+			// yay
+		} else if tc.err == nil && err != nil {
+			t.Errorf("%d: error: want nil: got %v\n", tc.id, err)
+		} else if !errors.Is(err, tc.err) {
+			t.Errorf("%d: error: want %v: got %v\n", tc.id, tc.err, err)
+		}
+		if got := result.String(); tc.expect != got {
+			t.Errorf("%d: eval: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+}
+
+func TestMacroExpansion(t *testing.T) { in_each_mode(t, test_macro_expansion) }
+
+func test_macro_expansion(t *testing.T, opts ...Option) {
+	l := NewInterpreter(opts...)
+	if err := l.LoadLibrary(); err != nil {
+		t.Fatalf("library: error: want nil: got %v\n", err)
+	}
+
+	for _, tc := range []struct {
+		id     int
+		input  string
+		expect string
+		err    error
+	}{
+		{id: 1, input: "(gensym)", expect: "G1"},
+		{id: 2, input: `(list (gensym "loop") (gensym 'tmp))`, expect: "(LOOP2 TMP3)"},
+		{id: 3, input: "(eq? (gensym) (gensym))", expect: "#f"},
+		{id: 4, input: "(eq? (gensym) 'g7)", expect: "#f"},
+		{id: 5, input: "(gensym 1)", expect: "NIL", err: Error_Type},
+		{id: 6, input: "(gensym 'a 'b)", expect: "NIL", err: Error_Args},
+		{id: 7, input: "(defmacro (swap! a b) (let ((tmp (gensym))) `(let ((,tmp ,a)) (set! ,a ,b) (set! ,b ,tmp))))", expect: "SWAP!"},
+		{id: 8, input: "(let ((tmp 1) (y 2)) (swap! tmp y) (list tmp y))", expect: "(2 1)"},
+		{id: 9, input: "(defmacro (my-unless c . body) `(if ,c nil (begin ,@body)))", expect: "MY-UNLESS"},
+		{id: 10, input: "(defmacro (my-when c . body) `(my-unless (not ,c) ,@body))", expect: "MY-WHEN"},
+		{id: 11, input: "(macroexpand-1 '(my-unless c a b))", expect: "(IF C NIL (BEGIN A B))"},
+		{id: 12, input: "(macroexpand-1 '(my-when c a))", expect: "(MY-UNLESS (NOT C) A)"},
+		{id: 13, input: "(macroexpand '(my-when c a))", expect: "(IF (NOT C) NIL (BEGIN A))"},
+		{id: 14, input: "(list (macroexpand '(+ 1 2)) (macroexpand-1 5) (macroexpand 'my-when))", expect: "((+ 1 2) 5 MY-WHEN)"},
+		{id: 15, input: "(define x 1)", expect: "X"},
+		{id: 16, input: "(list (macroexpand '(my-unless #f (set! x 2))) x)", expect: "((IF #f NIL (BEGIN (SET! X 2))) 1)"},
+		{id: 17, input: "(define-syntax my-or (syntax-rules () ((_) #f) ((_ e r ...) (let ((t e)) (if t t (my-or r ...))))))", expect: "MY-OR"},
+		{id: 18, input: "(macroexpand-1 '(my-or a b))", expect: "(LET ((T A)) (IF T T (MY-OR B)))"},
+		{id: 19, input: "(macroexpand-1 '(quasiquote (a (unquote b))))", expect: "(CONS (QUASIQUOTE A) (QUASIQUOTE ((UNQUOTE B))))"},
+		{id: 20, input: "(macroexpand '(my-or 1 . 2))", expect: "NIL", err: Error_Syntax},
+		{id: 21, input: "(macroexpand)", expect: "NIL", err: Error_Args},
+	} {
+		result, err := l.EvalString(tc.input)
+		if tc.err == nil && err == nil {
+			// yay
+		} else if tc.err == nil && err != nil {
+			t.Errorf("%d: error: want nil: got %v\n", tc.id, err)
+		} else if !errors.Is(err, tc.err) {
+			t.Errorf("%d: error: want %v: got %v\n", tc.id, tc.err, err)
+		}
+		if got := result.String(); tc.expect != got {
+			t.Errorf("%d: eval: want %q: got %q\n", tc.id, tc.expect, got)
+		}
+	}
+}
+
+func BenchmarkCount(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{name: "go"},
+		{name: "heap", opts: []Option{WithHeap(100000)}},
+		{name: "bytecode", opts: []Option{WithBytecode()}},
+		{name: "bytecode-heap", opts: []Option{WithBytecode(), WithHeap(100000)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			l := NewInterpreter(bc.opts...)
+			if _, err := l.EvalString("(define (count n) (if (= n 0) t (count (- n 1))))"); err != nil {
+				b.Fatalf("define: error: want nil: got %v\n", err)
+			}
+			expr, _, err := l.Read([]byte("(count 10000)"))
+			if err != nil {
+				b.Fatalf("read: error: want nil: got %v\n", err)
+			}
+			// keep the expression reachable across collections
+			l.Define("bench-expr", expr)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := l.Eval(expr); err != nil {
+					b.Fatalf("eval: error: want nil: got %v\n", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMap(b *testing.B) {
+	for _, mode := range modes {
+		b.Run(mode.name, func(b *testing.B) {
+			l := NewInterpreter(mode.opts...)
+			if err := l.LoadLibrary(); err != nil {
+				b.Fatalf("library: error: want nil: got %v\n", err)
+			}
+			if _, err := l.EvalString("(define (iota n) (if (= n 0) nil (cons n (iota (- n 1))))) (define big (iota 1000))"); err != nil {
+				b.Fatalf("define: error: want nil: got %v\n", err)
+			}
+			expr, _, err := l.Read([]byte("(foldr + 0 (map (lambda (x) (* x x)) big))"))
+			if err != nil {
+				b.Fatalf("read: error: want nil: got %v\n", err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := l.Eval(expr); err != nil {
+					b.Fatalf("eval: error: want nil: got %v\n", err)
+				}
+			}
+		})
+	}
+}
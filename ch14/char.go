@@ -0,0 +1,159 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lisp
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// characters are written #\ followed by the character, by its name,
+// or by x and its code in hexadecimal. for example, #\a, #\space
+// and #\x41.
+
+// char_names are the names of characters that can't be written
+// as themselves.
+var char_names = []struct {
+	name string
+	ch   rune
+}{
+	{"alarm", '\a'},
+	{"backspace", '\b'},
+	{"delete", 0x7f},
+	{"escape", 0x1b},
+	{"newline", '\n'},
+	{"null", 0},
+	{"return", '\r'},
+	{"space", ' '},
+	{"tab", '\t'},
+}
+
+// make_char returns an Atom on the stack.
+func make_char(ch rune) Atom {
+	return Atom{
+		_type: AtomType_Char,
+		value: AtomValue{
+			n: int64(ch),
+		},
+	}
+}
+
+// read_char reads a character literal from the input.
+// the input must include the leading #\.
+func read_char(input []byte, result *Atom) error {
+	text := input[2:]
+	if len(text) == 0 {
+		return Error_Syntax
+	} else if ch, size := utf8.DecodeRune(text); size == len(text) {
+		// a single character is always itself
+		if ch == utf8.RuneError && size == 1 {
+			return Error_Syntax
+		}
+		*result = make_char(ch)
+		return nil
+	}
+	for _, c := range char_names {
+		if bytes.EqualFold(text, []byte(c.name)) {
+			*result = make_char(c.ch)
+			return nil
+		}
+	}
+	if text[0] == 'x' || text[0] == 'X' {
+		if code, err := strconv.ParseUint(string(text[1:]), 16, 32); err == nil && utf8.ValidRune(rune(code)) {
+			*result = make_char(rune(code))
+			return nil
+		}
+	}
+	return Error_Syntax
+}
+
+// write_char returns a character literal that reads as the character.
+func write_char(ch rune) []byte {
+	for _, c := range char_names {
+		if c.ch == ch {
+			return []byte(`#\` + c.name)
+		}
+	}
+	if !unicode.IsPrint(ch) {
+		return []byte(fmt.Sprintf(`#\x%x`, ch))
+	}
+	return []byte(`#\` + string(ch))
+}
+
+// builtin_charp returns #t if the argument is a character.
+// note that the result may not be updated if we find errors.
+func builtin_charp(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	}
+
+	*result = make_boolean(car(args)._type == AtomType_Char)
+	return nil
+}
+
+// builtin_char_class returns a builtin that returns #t if the argument
+// is a character in a class, such as letters.
+func builtin_char_class(class func(rune) bool) Native {
+	return func(args Atom, result *Atom) error {
+		// verify number and type of arguments
+		if nilp(args) || !nilp(cdr(args)) {
+			return error_args("1", args)
+		} else if car(args)._type != AtomType_Char {
+			return error_type("char", car(args))
+		}
+
+		*result = make_boolean(class(car(args).value.char()))
+		return nil
+	}
+}
+
+// builtin_char_case returns a builtin that converts the case of a character.
+func builtin_char_case(convert func(rune) rune) Native {
+	return func(args Atom, result *Atom) error {
+		// verify number and type of arguments
+		if nilp(args) || !nilp(cdr(args)) {
+			return error_args("1", args)
+		} else if car(args)._type != AtomType_Char {
+			return error_type("char", car(args))
+		}
+
+		*result = make_char(convert(car(args).value.char()))
+		return nil
+	}
+}
+
+// builtin_char_to_integer returns the Unicode code point of a character.
+// note that the result may not be updated if we find errors.
+func builtin_char_to_integer(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	} else if car(args)._type != AtomType_Char {
+		return error_type("char", car(args))
+	}
+
+	*result = make_int(int(car(args).value.char()))
+	return nil
+}
+
+// builtin_integer_to_char returns the character for a Unicode code point.
+// note that the result may not be updated if we find errors.
+func builtin_integer_to_char(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	} else if car(args)._type != AtomType_Integer {
+		return error_type("integer", car(args))
+	}
+
+	code := car(args).value.integer()
+	if code < 0 || code > unicode.MaxRune || !utf8.ValidRune(rune(code)) {
+		return error_value(Error_Range, car(args))
+	}
+	*result = make_char(rune(code))
+	return nil
+}
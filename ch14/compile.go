@@ -0,0 +1,685 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lisp
+
+// this file implements a compiler from expressions to bytecode. it is
+// an alternative to the tree-walking evaluator in eval.go and is enabled
+// with the WithBytecode option. the virtual machine that runs the
+// bytecode is in vm.go.
+//
+// each special form is compiled to instructions that have the same
+// effect as the evaluator. a form that the evaluator would reject is
+// compiled to an instruction that raises the same error, so the error
+// is only raised if the form is reached.
+//
+// macros are expanded when the code that uses them is compiled. the
+// body of a LAMBDA isn't compiled until the first time that one of its
+// closures is called, so a procedure may use a macro that is defined
+// after the procedure, as long as it is defined before the call.
+//
+// the compiler keeps track of the names bound by each LAMBDA form and
+// GUARD clause. a reference to one of them is resolved to the depth of
+// the environment that binds it (counting outwards from the innermost)
+// and the index of its slot in that environment. the environments are
+// vectors of slots, so the name isn't searched for when the code runs.
+// other names are global and are looked up in the global environment.
+
+// opcode is the operation of a bytecode instruction.
+type opcode byte
+
+const (
+	op_const         opcode = iota // push constants[arg]
+	op_local                       // push the value of the local variable refs[arg]
+	op_global                      // push the value of the symbol constants[arg] in the global environment
+	op_define_local                // pop a value and store it in the local variable refs[arg], then push its name
+	op_define_global               // pop a value and bind the symbol constants[arg] to it, then push the symbol
+	op_set_local                   // pop a value and store it in the bound local variable refs[arg], then push its name
+	op_set_global                  // pop a value and update the binding of the symbol constants[arg], then push the symbol
+	op_pop                         // discard the value on the top of the stack
+	op_dup                         // push the value on the top of the stack again
+	op_swap                        // exchange the two values on the top of the stack
+	op_jump                        // continue at arg
+	op_jump_if_false               // pop a value and continue at arg if it is false
+	op_closure                     // push a closure for lambdas[arg]
+	op_macro                       // push a macro for lambdas[arg]
+	op_call                        // call the procedure under the top arg values with those values
+	op_tail_call                   // like op_call, but the procedure replaces the current frame
+	op_apply                       // pop a list and a procedure and call it. arg is 1 for a tail call
+	op_call_cc                     // pop a procedure and call it with the continuation. arg is 1 for a tail call
+	op_guard                       // install a handler for errors that continues at arg
+	op_unguard                     // remove the innermost handler
+	op_bind                        // pop a value and store it in the first slot of a new environment with arg slots
+	op_unbind                      // return to the parent of the environment
+	op_raise                       // pop a value and raise it
+	op_error                       // raise errors[arg]
+	op_return                      // pop a value and return it to the caller
+)
+
+// instr is a bytecode instruction.
+type instr struct {
+	op  opcode
+	arg int32
+}
+
+// code is the bytecode for an expression or for the body of a LAMBDA.
+type code struct {
+	instrs []instr
+	// pos is the position of the expression that each instruction
+	// was compiled from. errors are reported there.
+	pos []*Position
+	// constants are the quoted values and symbols used by the instructions.
+	constants []Atom
+	// refs are the local variables used by the instructions.
+	refs []ref
+	// lambdas are the LAMBDA forms used by the instructions.
+	lambdas []*Lambda
+	// errors are raised by op_error.
+	errors []error
+	// globals is the global environment for op_global.
+	globals Atom
+	// slots is the number of local variables in the environment
+	// for a call to a LAMBDA.
+	slots int
+}
+
+// ref is the address of a local variable.
+type ref struct {
+	// depth is the number of environments to go up from the current one.
+	depth int
+	// index is the slot in that environment.
+	index int
+	// name is used to look for a global if the variable hasn't been
+	// defined yet, and to report errors.
+	name Atom
+}
+
+// Lambda is a LAMBDA form seen by the compiler.
+// The closures created from the form share it and its bytecode.
+type Lambda struct {
+	// args and body are from the LAMBDA form.
+	args, body Atom
+	// scope is the lexical scope that the form appeared in.
+	scope *scope
+	// globals is the global environment for the body.
+	globals Atom
+	// code is the compiled body. it is nil until the first call.
+	code *code
+	// mark is used by the garbage collector.
+	mark uint32
+}
+
+// scope is the names bound by a LAMBDA form or GUARD clause, and the
+// scope that it is nested in. the outermost scope is nil, and names that
+// aren't bound in any scope are global.
+type scope struct {
+	parent *scope
+	names  []*Symbol
+	// macros are the SYNTAX-RULES macros defined in the scope. they
+	// are only used by the compiler, so they don't have slots.
+	macros map[*Symbol]Atom
+}
+
+// lookup returns the address of the variable for a symbol.
+// ok is false if the symbol isn't bound in the scope or its parents.
+// a symbol introduced by a macro that isn't bound by the expansion is
+// looked up in the scope where the macro was defined.
+func (s *scope) lookup(sym Atom) (r ref, ok bool) {
+	depth := 0
+	for t := s; t != nil; t, depth = t.parent, depth+1 {
+		if index := t.index(sym); index >= 0 {
+			return ref{depth: depth, index: index, name: sym}, true
+		}
+	}
+	if a := sym.value.symbol().alias; a != nil && a.syntax.scope != nil {
+		// count the scopes between here and the macro's
+		depth = 0
+		for t := s; t != a.syntax.scope; t, depth = t.parent, depth+1 {
+			if t == nil {
+				return ref{}, false
+			}
+		}
+		if r, ok = a.syntax.scope.lookup(a.symbol); ok {
+			r.depth += depth
+		}
+		return r, ok
+	}
+	return ref{}, false
+}
+
+// index returns the slot for a symbol in the scope, or -1 if the
+// scope doesn't bind it.
+func (s *scope) index(sym Atom) int {
+	// search from the end, so that the last of two
+	// arguments with the same name is found
+	for index := len(s.names) - 1; index >= 0; index-- {
+		if s.names[index] == sym.value.symbol() {
+			return index
+		}
+	}
+	return -1
+}
+
+// macro returns the SYNTAX-RULES macro that a symbol names in the scope
+// or its parents. ok is false if it doesn't name one, or if a variable
+// with the same name shadows it.
+func (s *scope) macro(sym Atom) (macro Atom, ok bool) {
+	for t := s; t != nil; t = t.parent {
+		if macro, ok = t.macros[sym.value.symbol()]; ok {
+			return macro, true
+		} else if t.index(sym) >= 0 {
+			return _nil, false
+		}
+	}
+	if a := sym.value.symbol().alias; a != nil && a.syntax.scope != nil {
+		return a.syntax.scope.macro(a.symbol)
+	}
+	return _nil, false
+}
+
+// bound returns true if the symbol is bound in the scope or its parents.
+func (s *scope) bound(sym Atom) bool {
+	_, ok := s.lookup(sym)
+	return ok
+}
+
+// declare adds a name to the scope if it isn't already there,
+// and returns the index of its slot.
+func (s *scope) declare(sym Atom) int {
+	if index := s.index(sym); index >= 0 {
+		return index
+	}
+	s.names = append(s.names, sym.value.symbol())
+	return len(s.names) - 1
+}
+
+// compiler holds the state for compiling an expression or the body of a LAMBDA.
+type compiler struct {
+	l     *Interpreter
+	code  *code
+	scope *scope
+	// pos is the position of the expression being compiled.
+	pos *Position
+}
+
+// compile returns the bytecode for an expression that will be run
+// at the top level. env is the global environment.
+func (l *Interpreter) compile(expr, env Atom) *code {
+	// the compiler holds atoms that are not roots,
+	// so collection waits until it is finished.
+	l.compiling++
+	defer func() {
+		l.compiling--
+	}()
+
+	c := &compiler{l: l, code: &code{globals: env}}
+	c.compile(expr, true)
+	c.emit(op_return, 0)
+	return c.code
+}
+
+// compile_lambda returns the bytecode for the body of a LAMBDA form.
+// the arguments are in the first slots of the environment, followed
+// by the names defined in the body.
+func (l *Interpreter) compile_lambda(lambda *Lambda) *code {
+	l.compiling++
+	defer func() {
+		l.compiling--
+	}()
+
+	c := &compiler{l: l, code: &code{globals: lambda.globals}, scope: &scope{parent: lambda.scope}, pos: pos_of(lambda.body)}
+	for p := lambda.args; !nilp(p); p = cdr(p) {
+		if p._type == AtomType_Symbol {
+			c.scope.names = append(c.scope.names, p.value.symbol())
+			break
+		}
+		c.scope.names = append(c.scope.names, car(p).value.symbol())
+	}
+	c.compile_body(lambda.body, true)
+	c.emit(op_return, 0)
+	c.code.slots = len(c.scope.names)
+	return c.code
+}
+
+// emit adds an instruction to the code and returns its address.
+func (c *compiler) emit(op opcode, arg int) int {
+	c.code.instrs = append(c.code.instrs, instr{op: op, arg: int32(arg)})
+	c.code.pos = append(c.code.pos, c.pos)
+	return len(c.code.instrs) - 1
+}
+
+// patch updates the jump at pc to continue at the next instruction.
+func (c *compiler) patch(pc int) {
+	c.code.instrs[pc].arg = int32(len(c.code.instrs))
+}
+
+// constant adds a value to the constants and returns its index.
+func (c *compiler) constant(value Atom) int {
+	c.code.constants = append(c.code.constants, value)
+	return len(c.code.constants) - 1
+}
+
+// ref adds a local variable to the refs and returns its index.
+func (c *compiler) ref(r ref) int {
+	c.code.refs = append(c.code.refs, r)
+	return len(c.code.refs) - 1
+}
+
+// define adds the instruction to bind a symbol to the value on the top of
+// the stack. the symbol is local unless this is the outermost scope.
+func (c *compiler) define(sym Atom) {
+	if c.scope == nil {
+		c.emit(op_define_global, c.constant(sym))
+		return
+	}
+	c.emit(op_define_local, c.ref(ref{index: c.scope.declare(sym), name: sym}))
+}
+
+// set adds the instruction to update the binding of a symbol with the
+// value on the top of the stack. the symbol is global unless one of the
+// enclosing scopes binds it.
+func (c *compiler) set(sym Atom) {
+	if r, ok := c.scope.lookup(sym); ok {
+		c.emit(op_set_local, c.ref(r))
+		return
+	}
+	c.emit(op_set_global, c.constant(sym))
+}
+
+// lambda adds a LAMBDA form to the lambdas and returns its index.
+func (c *compiler) lambda(args, body Atom) int {
+	c.code.lambdas = append(c.code.lambdas, &Lambda{args: args, body: body, scope: c.scope, globals: c.code.globals})
+	return len(c.code.lambdas) - 1
+}
+
+// compile adds the instructions to evaluate an expression and push the result.
+// tail is true if the result will be returned by the code.
+func (c *compiler) compile(expr Atom, tail bool) {
+	if pos := pos_of(expr); pos != nil {
+		saved := c.pos
+		c.pos = pos
+		defer func() {
+			c.pos = saved
+		}()
+	}
+
+	var err error
+	if expr._type == AtomType_Symbol {
+		if r, ok := c.scope.lookup(expr); ok {
+			c.emit(op_local, c.ref(r))
+		} else {
+			c.emit(op_global, c.constant(expr))
+		}
+	} else if expr._type != AtomType_Pair {
+		c.emit(op_const, c.constant(expr))
+	} else if !listp(expr) {
+		err = error_value(Error_Syntax, expr)
+	} else {
+		err = c.compile_form(car(expr), cdr(expr), tail)
+	}
+	if err != nil {
+		c.code.errors = append(c.code.errors, err)
+		c.emit(op_error, len(c.code.errors)-1)
+	}
+}
+
+// compile_body adds the instructions to evaluate each expression in
+// a body and push the result of the last one.
+// tail is true if the result will be returned by the code.
+func (c *compiler) compile_body(body Atom, tail bool) {
+	if nilp(body) {
+		c.emit(op_const, c.constant(_nil))
+		return
+	}
+	for ; !nilp(cdr(body)); body = cdr(body) {
+		c.compile(car(body), false)
+		c.emit(op_pop, 0)
+	}
+	c.compile(car(body), tail)
+}
+
+// compile_call adds the instructions to call a procedure with the
+// results of the expressions in args.
+func (c *compiler) compile_call(op, args Atom, tail bool) {
+	c.compile(op, false)
+	n := 0
+	for ; !nilp(args); args = cdr(args) {
+		c.compile(car(args), false)
+		n++
+	}
+	if tail {
+		c.emit(op_tail_call, n)
+	} else {
+		c.emit(op_call, n)
+	}
+}
+
+// compile_form adds the instructions for a list.
+// it returns an error if the form is a special form that isn't valid.
+func (c *compiler) compile_form(op, args Atom, tail bool) error {
+	if op._type == AtomType_Builtin {
+		// the builtin is called with the arguments as they are
+		c.emit(op_const, c.constant(op))
+		n := 0
+		for ; !nilp(args); args = cdr(args) {
+			c.emit(op_const, c.constant(car(args)))
+			n++
+		}
+		c.emit(op_call, n)
+		return nil
+	} else if op._type != AtomType_Symbol {
+		c.compile_call(op, args, tail)
+		return nil
+	}
+
+	// handle special forms
+	switch c.l.form(op) {
+	case form_quote:
+		// verify number and type of args
+		if nilp(args) || !nilp(cdr(args)) {
+			return error_args("1", args)
+		}
+		c.emit(op_const, c.constant(car(args)))
+		return nil
+	case form_define:
+		// verify number and type of args
+		if nilp(args) || nilp(cdr(args)) {
+			return error_args("at least 2", args)
+		}
+		if sym := car(args); sym._type == AtomType_Pair {
+			if err := check_lambda(cdr(sym), cdr(args)); err != nil {
+				return err
+			} else if sym = car(sym); sym._type != AtomType_Symbol {
+				return error_type("symbol", sym)
+			}
+			c.emit(op_closure, c.lambda(cdr(car(args)), cdr(args)))
+			c.define(sym)
+		} else if sym._type == AtomType_Symbol {
+			if !nilp(cdr(cdr(args))) {
+				return error_args("2", args)
+			}
+			c.compile(car(cdr(args)), false)
+			c.define(sym)
+		} else {
+			return error_type("symbol", sym)
+		}
+		return nil
+	case form_set:
+		// verify number and type of args
+		if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
+			return error_args("2", args)
+		} else if sym := car(args); sym._type != AtomType_Symbol {
+			return error_type("symbol", sym)
+		}
+		c.compile(car(cdr(args)), false)
+		c.set(car(args))
+		return nil
+	case form_define_syntax:
+		// verify number and type of args
+		if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
+			return error_args("2", args)
+		} else if name := car(args); name._type != AtomType_Symbol {
+			return error_type("symbol", name)
+		}
+		macro, err := c.l.make_syntax(car(cdr(args)), c.code.globals, c.scope)
+		if err != nil {
+			return err
+		}
+		if c.scope == nil {
+			// global macros are bound when the code runs, like DEFMACRO
+			c.emit(op_const, c.constant(macro))
+			c.define(car(args))
+			return nil
+		}
+		// local macros are only needed while the scope is compiled
+		if c.scope.macros == nil {
+			c.scope.macros = make(map[*Symbol]Atom)
+		}
+		c.scope.macros[car(args).value.symbol()] = macro
+		c.emit(op_const, c.constant(car(args)))
+		return nil
+	case form_lambda:
+		// verify number and type of args
+		if nilp(args) || nilp(cdr(args)) {
+			return error_args("at least 2", args)
+		} else if err := check_lambda(car(args), cdr(args)); err != nil {
+			return err
+		}
+		c.emit(op_closure, c.lambda(car(args), cdr(args)))
+		return nil
+	case form_if:
+		// verify number and type of args
+		if nilp(args) || nilp(cdr(args)) || nilp(cdr(cdr(args))) || !nilp(cdr(cdr(cdr(args)))) {
+			return error_args("3", args)
+		}
+		c.compile(car(args), false)
+		alternative := c.emit(op_jump_if_false, 0)
+		c.compile(car(cdr(args)), tail)
+		end := c.emit(op_jump, 0)
+		c.patch(alternative)
+		c.compile(car(cdr(cdr(args))), tail)
+		c.patch(end)
+		return nil
+	case form_begin:
+		c.compile_body(args, tail)
+		return nil
+	case form_and, form_or:
+		if nilp(args) {
+			c.emit(op_const, c.constant(make_boolean(c.l.form(op) == form_and)))
+			return nil
+		}
+		// each value but the last is kept as the result if it ends the form
+		var ends []int
+		for ; !nilp(cdr(args)); args = cdr(args) {
+			c.compile(car(args), false)
+			c.emit(op_dup, 0)
+			if c.l.form(op) == form_and {
+				ends = append(ends, c.emit(op_jump_if_false, 0))
+			} else {
+				next := c.emit(op_jump_if_false, 0)
+				ends = append(ends, c.emit(op_jump, 0))
+				c.patch(next)
+			}
+			c.emit(op_pop, 0)
+		}
+		c.compile(car(args), tail)
+		for _, pc := range ends {
+			c.patch(pc)
+		}
+		return nil
+	case form_cond:
+		if err := c.l.check_clauses(args); err != nil {
+			return err
+		}
+		ends := c.compile_clauses(args, tail)
+		c.emit(op_const, c.constant(_nil))
+		for _, pc := range ends {
+			c.patch(pc)
+		}
+		return nil
+	case form_case, form_do, form_let, form_let_star, form_let_syntax, form_letrec, form_unless, form_when:
+		// compile the expansion in place of the form
+		expansion, err := c.l.expand_derived(op, args)
+		if err != nil {
+			return err
+		}
+		c.compile(expansion, tail)
+		return nil
+	case form_defmacro:
+		// verify number and type of args
+		if nilp(args) || nilp(cdr(args)) {
+			return error_args("at least 2", args)
+		} else if car(args)._type != AtomType_Pair {
+			return error_value(Error_Syntax, car(args))
+		}
+		name := car(car(args))
+		if name._type != AtomType_Symbol {
+			return error_type("symbol", name)
+		} else if err := check_lambda(cdr(car(args)), cdr(args)); err != nil {
+			return err
+		}
+		c.emit(op_macro, c.lambda(cdr(car(args)), cdr(args)))
+		c.define(name)
+		return nil
+	case form_apply:
+		// verify number and type of args
+		if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
+			return error_args("2", args)
+		}
+		c.compile(car(args), false)
+		c.compile(car(cdr(args)), false)
+		c.emit(op_apply, tail_arg(tail))
+		return nil
+	case form_call_cc:
+		// verify number and type of args
+		if nilp(args) || !nilp(cdr(args)) {
+			return error_args("1", args)
+		}
+		c.compile(car(args), false)
+		c.emit(op_call_cc, tail_arg(tail))
+		return nil
+	case form_guard:
+		// verify number and type of args
+		if nilp(args) || nilp(cdr(args)) {
+			return error_args("at least 2", args)
+		} else if spec := car(args); spec._type != AtomType_Pair || car(spec)._type != AtomType_Symbol {
+			return error_value(Error_Syntax, spec)
+		} else if err := c.l.check_clauses(cdr(spec)); err != nil {
+			return err
+		}
+		c.compile_guard(car(args), cdr(args))
+		return nil
+	}
+
+	// expand macros and compile the expansion in their place
+	if macro, ok := c.macro(op); ok {
+		var expansion Atom
+		var err error
+		if macro._type == AtomType_Syntax {
+			expansion, err = c.l.expand_syntax(macro.value.syntax(), args, c.code.globals, c.scope)
+		} else {
+			expansion, err = c.expand(macro, args)
+		}
+		if err != nil {
+			return err
+		}
+		c.compile(expansion, tail)
+		return nil
+	}
+
+	c.compile_call(op, args, tail)
+	return nil
+}
+
+// compile_guard adds the instructions for a GUARD form.
+// spec is (var clause...).
+// the body is called as ((LAMBDA () body...)) with a handler installed.
+// if the body raises a value, the handler binds it to var and selects
+// a clause like COND does. if no clause is selected, the value is raised
+// again.
+func (c *compiler) compile_guard(spec, body Atom) {
+	handler := c.emit(op_guard, 0)
+	c.emit(op_closure, c.lambda(_nil, body))
+	c.emit(op_call, 0)
+	c.emit(op_unguard, 0)
+	done := c.emit(op_jump, 0)
+
+	c.patch(handler)
+	name := car(spec)
+	bind := c.emit(op_bind, 0)
+	saved := c.scope
+	c.scope = &scope{parent: saved, names: []*Symbol{name.value.symbol()}}
+	// the handler runs in the frame of the GUARD form, so the clauses
+	// are never in tail position.
+	ends := c.compile_clauses(cdr(spec), false)
+	c.emit(op_local, c.ref(ref{name: name}))
+	c.emit(op_raise, 0)
+	for _, pc := range ends {
+		c.patch(pc)
+	}
+	c.emit(op_unbind, 0)
+	// the clauses may have defined names in the scope too
+	c.code.instrs[bind].arg = int32(len(c.scope.names))
+	c.scope = saved
+
+	c.patch(done)
+}
+
+// compile_clauses adds the instructions to select one of the clauses of
+// a COND or GUARD form and evaluate it. the instructions for when no
+// clause is selected must follow them. it returns the jumps from the
+// end of each clause, which must be patched to continue after those.
+// tail is true if the result will be returned by the code.
+func (c *compiler) compile_clauses(clauses Atom, tail bool) (ends []int) {
+	for ; !nilp(clauses); clauses = cdr(clauses) {
+		test, body := car(car(clauses)), cdr(car(clauses))
+		if c.l.form(test) == form_else {
+			c.compile_body(body, tail)
+			ends = append(ends, c.emit(op_jump, 0))
+			break
+		}
+		c.compile(test, false)
+		if nilp(body) {
+			// the value of the test is the result
+			c.emit(op_dup, 0)
+			next := c.emit(op_jump_if_false, 0)
+			ends = append(ends, c.emit(op_jump, 0))
+			c.patch(next)
+			c.emit(op_pop, 0)
+		} else if c.l.form(car(body)) == form_arrow {
+			// the result is (proc test)
+			c.emit(op_dup, 0)
+			next := c.emit(op_jump_if_false, 0)
+			c.compile(car(cdr(body)), false)
+			c.emit(op_swap, 0)
+			if tail {
+				c.emit(op_tail_call, 1)
+			} else {
+				c.emit(op_call, 1)
+			}
+			ends = append(ends, c.emit(op_jump, 0))
+			c.patch(next)
+			c.emit(op_pop, 0)
+		} else {
+			next := c.emit(op_jump_if_false, 0)
+			c.compile_body(body, tail)
+			ends = append(ends, c.emit(op_jump, 0))
+			c.patch(next)
+		}
+	}
+	return ends
+}
+
+// macro returns the macro that a symbol names, if it names one.
+// local variables shadow global macros.
+func (c *compiler) macro(op Atom) (Atom, bool) {
+	if macro, ok := c.scope.macro(op); ok {
+		return macro, true
+	} else if c.scope.bound(op) {
+		return _nil, false
+	}
+	var macro Atom
+	if c.l.env_get(c.code.globals, op, &macro) == nil && (macro._type == AtomType_Macro || macro._type == AtomType_Syntax) {
+		return macro, true
+	}
+	return _nil, false
+}
+
+// expand returns the expansion of a call to a macro.
+// the macro is run on the virtual machine with the arguments unevaluated.
+func (c *compiler) expand(macro, args Atom) (Atom, error) {
+	macro._type = AtomType_Closure
+	x := &compiler{l: c.l, code: &code{globals: c.code.globals}, pos: c.pos}
+	x.emit(op_const, x.constant(macro))
+	x.emit(op_const, x.constant(args))
+	x.emit(op_apply, 0)
+	x.emit(op_return, 0)
+	return c.l.vm_run(&vm_frame{code: x.code})
+}
+
+// tail_arg returns the argument for an instruction that can make a tail call.
+func tail_arg(tail bool) int {
+	if tail {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lisp
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Condition is an error object.
+// It is created by ERROR, or from the error returned by a builtin
+// when that error is caught by GUARD.
+// We define a struct around it so that we can do
+// pointer comparisons for equality in other parts of this package.
+type Condition struct {
+	// message describes the error.
+	message string
+	// irritants is a list of the values that caused the error.
+	irritants Atom
+	// err is the error that the condition was created from.
+	// it is nil for conditions created by ERROR.
+	err error
+}
+
+// make_condition returns an Atom on the stack.
+func make_condition(message string, irritants Atom, err error) Atom {
+	return Atom{
+		_type: AtomType_Condition,
+		value: AtomValue{
+			ref: &Condition{
+				message:   message,
+				irritants: irritants,
+				err:       err,
+			},
+		},
+	}
+}
+
+// condition_of returns the value raised by an error.
+// if the error was raised by RAISE or ERROR, that is the raised value.
+// otherwise, it is a new condition wrapping the error.
+func condition_of(err error) Atom {
+	var e *LispError
+	if !errors.As(err, &e) {
+		return make_condition(err.Error(), _nil, err)
+	} else if e.Err == Error_Raise && e.Value != nil {
+		return *e.Value
+	}
+	irritants := _nil
+	if e.Value != nil {
+		irritants = cons(*e.Value, _nil)
+	}
+	return make_condition(err.Error(), irritants, err)
+}
+
+// write_condition returns the printed form of a condition.
+func write_condition(c *Condition) []byte {
+	bb := &bytes.Buffer{}
+	bb.WriteString("#<CONDITION ")
+	bb.Write(write_string(c.message))
+	for p := c.irritants; p._type == AtomType_Pair; p = cdr(p) {
+		bb.WriteByte(' ')
+		bb.Write(car(p).Bytes())
+	}
+	bb.WriteByte('>')
+	return bb.Bytes()
+}
+
+// builtin_error raises a new condition with a message and a list of irritants.
+// the message must be a string.
+func builtin_error(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) {
+		return error_args("at least 1", args)
+	} else if car(args)._type != AtomType_String {
+		return error_type("string", car(args))
+	}
+	return error_value(Error_Raise, make_condition(car(args).value.str().text, cdr(args), nil))
+}
+
+// builtin_error_objectp tests whether an atom is a condition.
+func builtin_error_objectp(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	}
+
+	if car(args)._type != AtomType_Condition {
+		*result = _false
+	} else {
+		*result = _true
+	}
+	return nil
+}
+
+// builtin_error_object_irritants returns the list of irritants of a condition.
+func builtin_error_object_irritants(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	} else if car(args)._type != AtomType_Condition {
+		return error_type("condition", car(args))
+	}
+
+	*result = car(args).value.condition().irritants
+	return nil
+}
+
+// builtin_error_object_message returns the message of a condition as a string.
+func builtin_error_object_message(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	} else if car(args)._type != AtomType_Condition {
+		return error_type("condition", car(args))
+	}
+
+	*result = make_string(car(args).value.condition().message)
+	return nil
+}
+
+// builtin_raise raises a value, which may be any object.
+// raising a condition that was created from an error raises that
+// error again, so it is reported just as if it had not been caught.
+func builtin_raise(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	}
+
+	if obj := car(args); obj._type == AtomType_Condition && obj.value.condition().err != nil {
+		return obj.value.condition().err
+	}
+	return error_value(Error_Raise, car(args))
+}
@@ -0,0 +1,16 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lisp
+
+// Continuation is the rest of a computation, captured by CALL/CC.
+// We define a struct around it so that we can do
+// pointer comparisons for equality in other parts of this package.
+type Continuation struct {
+	// stack is a private copy of the frames that were
+	// waiting for the result of the CALL/CC form.
+	stack Atom
+	// frames is a private copy of the bytecode frames that were
+	// waiting for the result, if the continuation was captured
+	// by the virtual machine.
+	frames *vm_frame
+}
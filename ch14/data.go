@@ -11,7 +11,7 @@ func cons(car, cdr Atom) Atom {
 	return Atom{
 		_type: AtomType_Pair,
 		value: AtomValue{
-			pair: &Pair{
+			ref: &Pair{
 				car: car,
 				cdr: cdr,
 			},
@@ -24,7 +24,7 @@ func make_builtin(fn Native) Atom {
 	return Atom{
 		_type: AtomType_Builtin,
 		value: AtomValue{
-			builtin: &Builtin{
+			ref: &Builtin{
 				fn: fn,
 			},
 		},
@@ -32,10 +32,31 @@ func make_builtin(fn Native) Atom {
 }
 
 // make_closure returns an Atom on the stack.
-// a closure is a list that binds the environment and arguments.
+// a closure binds the environment to the arguments and body, which
+// are resolved so that references to local variables are found
+// without searching for them. see resolve.go.
 // note that result may not be updated if there are errors.
-func make_closure(env, args, body Atom, result *Atom) error {
+func (l *Interpreter) make_closure(env, args, body Atom, result *Atom) error {
 	// verify number and type of arguments
+	if err := check_lambda(args, body); err != nil {
+		return err
+	}
+
+	// bind the environment to the resolved form
+	*result = Atom{
+		_type: AtomType_Closure,
+		value: AtomValue{
+			ref: &eval_closure{
+				lambda: l.resolve_lambda(env, args, body),
+				env:    env,
+			},
+		},
+	}
+	return nil
+}
+
+// check_lambda verifies the argument names and body of a LAMBDA form.
+func check_lambda(args, body Atom) error {
 	if !listp(body) {
 		return Error_Syntax
 	}
@@ -43,22 +64,27 @@ func make_closure(env, args, body Atom, result *Atom) error {
 	// if we find a symbol, stop checking.
 	// if we find something that is not a pair with a symbol in the cdr, return an error.
 	for p := args; !nilp(p) && p._type != AtomType_Symbol; p = cdr(p) {
-		if p._type != AtomType_Pair || car(p)._type != AtomType_Symbol {
-			return Error_Type
+		if p._type != AtomType_Pair {
+			return error_type("symbol", p)
+		} else if car(p)._type != AtomType_Symbol {
+			return error_type("symbol", car(p))
 		}
 	}
+	return nil
+}
 
-	// bind the environment and arguments to the closure
-	*result = Atom{
-		_type: AtomType_Closure,
+// make_continuation returns an Atom on the stack.
+// the continuation holds a copy of the stack, so later changes
+// to the frames on the stack do not change the continuation.
+func (l *Interpreter) make_continuation(stack Atom) Atom {
+	return Atom{
+		_type: AtomType_Continuation,
 		value: AtomValue{
-			pair: &Pair{
-				car: env,
-				cdr: cons(args, body),
+			ref: &Continuation{
+				stack: l.stack_copy(stack),
 			},
 		},
 	}
-	return nil
 }
 
 // make_int returns an Atom on the stack.
@@ -66,7 +92,7 @@ func make_int(x int) Atom {
 	return Atom{
 		_type: AtomType_Integer,
 		value: AtomValue{
-			integer: x,
+			n: int64(x),
 		},
 	}
 }
@@ -82,8 +108,10 @@ func make_macro(env, args, body Atom, result *Atom) error {
 	// if we find a symbol, stop checking.
 	// if we find something that is not a pair with a symbol in the cdr, return an error.
 	for p := args; !nilp(p) && p._type != AtomType_Symbol; p = cdr(p) {
-		if p._type != AtomType_Pair || car(p)._type != AtomType_Symbol {
-			return Error_Type
+		if p._type != AtomType_Pair {
+			return error_type("symbol", p)
+		} else if car(p)._type != AtomType_Symbol {
+			return error_type("symbol", car(p))
 		}
 	}
 
@@ -91,7 +119,7 @@ func make_macro(env, args, body Atom, result *Atom) error {
 	*result = Atom{
 		_type: AtomType_Macro,
 		value: AtomValue{
-			pair: &Pair{
+			ref: &Pair{
 				car: env,
 				cdr: cons(args, body),
 			},
@@ -101,31 +129,48 @@ func make_macro(env, args, body Atom, result *Atom) error {
 }
 
 // make_sym returns an Atom on the stack.
-// The name of the symbol is always converted to uppercase.
-// If the symbol already exists in the global symbol table, that symbol is
+// The name of the symbol is converted to uppercase unless the
+// interpreter preserves case.
+// If the symbol already exists in the interpreter's symbol table, that symbol is
 // returned. Otherwise, a new symbol is created on the stack, added to the
 // symbol table, and returned. The new symbol allocates space for the name.
-func make_sym(name []byte) Atom {
-	// make an upper-case copy of the name
-	name = bytes.ToUpper(name)
+// Symbols with the same name are always the same pointer, so they can be
+// compared by identity.
+func (l *Interpreter) make_sym(name []byte) Atom {
+	// make a copy of the name, in upper case unless case is preserved
+	if l.case_sensitive {
+		name = append([]byte(nil), name...)
+	} else {
+		name = bytes.ToUpper(name)
+	}
 	// search for any existing symbol with the same name
-	for p := sym_table; !nilp(p); p = cdr(p) {
-		if atom := car(p); bytes.Equal(name, atom.value.symbol.label) {
-			// found match, so return the existing symbol
-			return atom
+	sym, ok := l.symbols[string(name)]
+	if !ok {
+		// did not find a matching symbol, so create a new one
+		// and add it to the symbol table
+		sym = &Symbol{label: name}
+		if l.symbols == nil {
+			l.symbols = make(map[string]*Symbol)
 		}
+		l.symbols[string(name)] = sym
+	}
+	return Atom{
+		_type: AtomType_Symbol,
+		value: AtomValue{
+			ref: sym,
+		},
 	}
-	// did not find a matching symbol, so create a new one
-	atom := Atom{
+}
+
+// make_uninterned_sym returns a new symbol that is not added to the
+// symbol table, so it is never the same as a symbol read from the input.
+func make_uninterned_sym(name []byte) Atom {
+	return Atom{
 		_type: AtomType_Symbol,
 		value: AtomValue{
-			symbol: &Symbol{
-				label: name,
+			ref: &Symbol{
+				label: bytes.ToUpper(name),
 			},
 		},
 	}
-	// add it to the symbol_table
-	sym_table = cons(atom, sym_table)
-	// and return it
-	return atom
 }
@@ -0,0 +1,301 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lisp
+
+import "fmt"
+
+// the derived forms are rewritten in terms of the core special forms
+// when they are compiled, or when the body of a closure they are in is
+// resolved. a form outside of a closure, or in a GUARD clause, is
+// rewritten each time it is evaluated. the rewriting is done here,
+// rather than by macros in the library, so that it costs little more
+// than building the expansion. the last expression of each body stays
+// in tail position, so loops written with DO or a named LET run in
+// constant space.
+//
+// BEGIN, AND and OR are core forms that the evaluators handle themselves.
+
+// expand_derived returns the expansion of a derived form.
+// it returns an error if the form isn't valid.
+func (l *Interpreter) expand_derived(op, args Atom) (Atom, error) {
+	switch l.form(op) {
+	case form_case:
+		return l.expand_case(args)
+	case form_cond:
+		if err := l.check_clauses(args); err != nil {
+			return _nil, err
+		}
+		return l.cond_clauses(args, _nil), nil
+	case form_do:
+		return l.expand_do(args)
+	case form_let:
+		return l.expand_let(args)
+	case form_let_star:
+		return l.expand_let_star(args)
+	case form_let_syntax:
+		return l.expand_let_syntax(args)
+	case form_letrec:
+		return l.expand_letrec(args)
+	case form_unless, form_when:
+		// verify number and type of args
+		if nilp(args) || nilp(cdr(args)) {
+			return _nil, error_args("at least 2", args)
+		}
+		// (IF test (BEGIN body...) NIL), with the branches swapped for UNLESS
+		body := l.cons(l.intern("BEGIN"), cdr(args))
+		if l.form(op) == form_unless {
+			return l.list_of(l.intern("IF"), car(args), _nil, body), nil
+		}
+		return l.list_of(l.intern("IF"), car(args), body, _nil), nil
+	}
+	panic(fmt.Sprintf("assert(form != %d)", l.form(op)))
+}
+
+// check_clauses returns an error if a clause of a CASE, COND or GUARD
+// form isn't a list, or if a clause with => doesn't have exactly one
+// procedure after it.
+func (l *Interpreter) check_clauses(clauses Atom) error {
+	for ; !nilp(clauses); clauses = cdr(clauses) {
+		clause := car(clauses)
+		if clause._type != AtomType_Pair || !listp(clause) {
+			return error_value(Error_Syntax, clause)
+		} else if body := cdr(clause); !nilp(body) && l.form(car(body)) == form_arrow && (nilp(cdr(body)) || !nilp(cdr(cdr(body)))) {
+			return error_value(Error_Syntax, clause)
+		}
+	}
+	return nil
+}
+
+// cond_clauses returns an expression that evaluates the clauses of a
+// COND or GUARD form. if no clause is selected, the expression
+// evaluates otherwise.
+// the clauses must have been checked with check_clauses.
+func (l *Interpreter) cond_clauses(clauses, otherwise Atom) Atom {
+	if nilp(clauses) {
+		return otherwise
+	}
+	clause, rest := car(clauses), l.cond_clauses(cdr(clauses), otherwise)
+	test, body := car(clause), cdr(clause)
+	if l.form(test) == form_else {
+		// (BEGIN body...)
+		return l.cons(l.intern("BEGIN"), body)
+	} else if nilp(body) {
+		// (OR test rest)
+		return l.list_of(l.intern("OR"), test, rest)
+	} else if l.form(car(body)) == form_arrow {
+		// ((LAMBDA (tmp) (IF tmp (proc tmp) rest)) test)
+		tmp := make_uninterned_sym([]byte("TEST"))
+		call := l.list_of(car(cdr(body)), tmp)
+		return l.list_of(l.list_of(l.intern("LAMBDA"), l.list_of(tmp), l.list_of(l.intern("IF"), tmp, call, rest)), test)
+	}
+	// (IF test (BEGIN body...) rest)
+	return l.list_of(l.intern("IF"), test, l.cons(l.intern("BEGIN"), body), rest)
+}
+
+// expand_case returns the expansion of (CASE key clause...), which is
+// ((LAMBDA (tmp) (COND clause...)) key). the test for each clause,
+// ((datum...) body...), compares the key to the data with EQV?.
+// a clause ((datum...) => proc) calls the procedure with the key.
+func (l *Interpreter) expand_case(args Atom) (Atom, error) {
+	// verify number and type of args
+	if nilp(args) {
+		return _nil, error_args("at least 1", args)
+	} else if err := l.check_clauses(cdr(args)); err != nil {
+		return _nil, err
+	}
+
+	tmp := make_uninterned_sym([]byte("KEY"))
+	// the builtin is quoted so that its arguments are evaluated
+	match := l.list_of(l.intern("QUOTE"), l.make_builtin(builtin_case_match))
+	var clauses []Atom
+	for p := cdr(args); !nilp(p); p = cdr(p) {
+		data, body := car(car(p)), cdr(car(p))
+		if !nilp(body) && l.form(car(body)) == form_arrow {
+			// (proc tmp)
+			body = l.list_of(l.list_of(car(cdr(body)), tmp))
+		}
+		if l.form(data) == form_else {
+			clauses = append(clauses, l.cons(data, body))
+			continue
+		} else if !listp(data) {
+			return _nil, error_value(Error_Syntax, car(p))
+		}
+		test := l.list_of(match, tmp, l.list_of(l.intern("QUOTE"), data))
+		clauses = append(clauses, l.cons(test, body))
+	}
+	cond := l.cond_clauses(l.list_of(clauses...), _nil)
+	return l.list_of(l.list_of(l.intern("LAMBDA"), l.list_of(tmp), cond), car(args)), nil
+}
+
+// builtin_case_match returns #t if the first argument is EQV? to an item
+// in the list that is the second argument. it isn't bound to a name;
+// CASE uses it to select a clause.
+func builtin_case_match(args Atom, result *Atom) error {
+	for data := car(cdr(args)); !nilp(data); data = cdr(data) {
+		if eqp(car(args), car(data)) {
+			*result = _true
+			return nil
+		}
+	}
+	*result = _false
+	return nil
+}
+
+// let_bindings returns the variables and initial values from the
+// bindings of a LET, LET*, LETREC or LET-SYNTAX form. each binding must
+// be a list of a symbol and an expression.
+func let_bindings(bindings Atom) (vars, inits []Atom, err error) {
+	if !listp(bindings) {
+		return nil, nil, error_value(Error_Syntax, bindings)
+	}
+	for ; !nilp(bindings); bindings = cdr(bindings) {
+		b := car(bindings)
+		if b._type != AtomType_Pair || car(b)._type != AtomType_Symbol || cdr(b)._type != AtomType_Pair || !nilp(cdr(cdr(b))) {
+			return nil, nil, error_value(Error_Syntax, b)
+		}
+		vars, inits = append(vars, car(b)), append(inits, car(cdr(b)))
+	}
+	return vars, inits, nil
+}
+
+// expand_let returns the expansion of (LET ((var init)...) body...),
+// which is ((LAMBDA (var...) body...) init...), or of a named LET,
+// (LET name ((var init)...) body...), which is
+// ((LETREC ((name (LAMBDA (var...) body...))) name) init...).
+func (l *Interpreter) expand_let(args Atom) (Atom, error) {
+	// verify number and type of args
+	if nilp(args) || nilp(cdr(args)) {
+		return _nil, error_args("at least 2", args)
+	}
+	name, bindings, body := _nil, car(args), cdr(args)
+	if bindings._type == AtomType_Symbol {
+		if nilp(cdr(body)) {
+			return _nil, error_args("at least 3", args)
+		}
+		name, bindings, body = bindings, car(body), cdr(body)
+	}
+	vars, inits, err := let_bindings(bindings)
+	if err != nil {
+		return _nil, err
+	}
+
+	proc := l.cons(l.intern("LAMBDA"), l.cons(l.list_of(vars...), body))
+	if !nilp(name) {
+		proc = l.list_of(l.intern("LETREC"), l.list_of(l.list_of(name, proc)), name)
+	}
+	return l.cons(proc, l.list_of(inits...)), nil
+}
+
+// expand_let_star returns the expansion of (LET* (binding...) body...),
+// which is nested LET forms with one binding each, so that each
+// initial value can refer to the variables bound before it.
+func (l *Interpreter) expand_let_star(args Atom) (Atom, error) {
+	// verify number and type of args
+	if nilp(args) || nilp(cdr(args)) {
+		return _nil, error_args("at least 2", args)
+	} else if !listp(car(args)) {
+		return _nil, error_value(Error_Syntax, car(args))
+	}
+
+	bindings, body := car(args), cdr(args)
+	if nilp(bindings) || nilp(cdr(bindings)) {
+		return l.cons(l.intern("LET"), args), nil
+	}
+	// (LET (first) (LET* (rest...) body...))
+	inner := l.cons(l.intern("LET*"), l.cons(cdr(bindings), body))
+	return l.list_of(l.intern("LET"), l.list_of(car(bindings)), inner), nil
+}
+
+// expand_let_syntax returns the expansion of
+// (LET-SYNTAX ((keyword (SYNTAX-RULES ...))...) body...), which is
+// ((LAMBDA () (DEFINE-SYNTAX keyword (SYNTAX-RULES ...))... body...)).
+// the macros are defined in the new scope, so they can refer to each other.
+func (l *Interpreter) expand_let_syntax(args Atom) (Atom, error) {
+	// verify number and type of args
+	if nilp(args) || nilp(cdr(args)) {
+		return _nil, error_args("at least 2", args)
+	}
+	keywords, specs, err := let_bindings(car(args))
+	if err != nil {
+		return _nil, err
+	}
+
+	body, define := cdr(args), l.intern("DEFINE-SYNTAX")
+	for n := len(keywords) - 1; n >= 0; n-- {
+		body = l.cons(l.list_of(define, keywords[n], specs[n]), body)
+	}
+	return l.list_of(l.cons(l.intern("LAMBDA"), l.cons(_nil, body))), nil
+}
+
+// expand_letrec returns the expansion of (LETREC ((var init)...) body...),
+// which is ((LAMBDA () (DEFINE var init)... body...)). the variables are
+// defined in the new environment, so the initial values can refer to
+// each other.
+func (l *Interpreter) expand_letrec(args Atom) (Atom, error) {
+	// verify number and type of args
+	if nilp(args) || nilp(cdr(args)) {
+		return _nil, error_args("at least 2", args)
+	}
+	vars, inits, err := let_bindings(car(args))
+	if err != nil {
+		return _nil, err
+	}
+
+	body, define := cdr(args), l.intern("DEFINE")
+	for n := len(vars) - 1; n >= 0; n-- {
+		body = l.cons(l.list_of(define, vars[n], inits[n]), body)
+	}
+	return l.list_of(l.cons(l.intern("LAMBDA"), l.cons(_nil, body))), nil
+}
+
+// expand_do returns the expansion of
+// (DO ((var init step)...) (test expr...) body...), which is a loop:
+//
+//	((LETREC ((loop (LAMBDA (var...)
+//	                  (IF test
+//	                      (BEGIN expr...)
+//	                      (BEGIN body... (loop step...))))))
+//	   loop)
+//	 init...)
+//
+// a variable without a step keeps its value. if there are no
+// expressions after the test, the result is NIL.
+func (l *Interpreter) expand_do(args Atom) (Atom, error) {
+	// verify number and type of args
+	if nilp(args) || nilp(cdr(args)) {
+		return _nil, error_args("at least 2", args)
+	} else if !listp(car(args)) {
+		return _nil, error_value(Error_Syntax, car(args))
+	} else if exit := car(cdr(args)); exit._type != AtomType_Pair || !listp(exit) {
+		return _nil, error_value(Error_Syntax, exit)
+	}
+
+	var vars, inits, steps []Atom
+	for bindings := car(args); !nilp(bindings); bindings = cdr(bindings) {
+		// (var init) or (var init step)
+		b := car(bindings)
+		if b._type != AtomType_Pair || !listp(b) || car(b)._type != AtomType_Symbol || nilp(cdr(b)) || !(nilp(cdr(cdr(b))) || nilp(cdr(cdr(cdr(b))))) {
+			return _nil, error_value(Error_Syntax, b)
+		}
+		step := car(b)
+		if !nilp(cdr(cdr(b))) {
+			step = car(cdr(cdr(b)))
+		}
+		vars, inits, steps = append(vars, car(b)), append(inits, car(cdr(b))), append(steps, step)
+	}
+
+	loop, begin := make_uninterned_sym([]byte("LOOP")), l.intern("BEGIN")
+	exit, done := car(cdr(args)), _nil
+	if !nilp(cdr(exit)) {
+		done = l.cons(begin, cdr(exit))
+	}
+	again := []Atom{begin}
+	for body := cdr(cdr(args)); !nilp(body); body = cdr(body) {
+		again = append(again, car(body))
+	}
+	again = append(again, l.cons(loop, l.list_of(steps...)))
+
+	proc := l.list_of(l.intern("LAMBDA"), l.list_of(vars...), l.list_of(l.intern("IF"), car(exit), done, l.list_of(again...)))
+	letrec := l.list_of(l.intern("LETREC"), l.list_of(l.list_of(loop, proc)), loop)
+	return l.cons(letrec, l.list_of(inits...)), nil
+}
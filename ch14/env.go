@@ -2,30 +2,112 @@
 
 package lisp
 
+import (
+	"math"
+	"unicode"
+)
+
 // env_create creates a new environment.
 // if parent is not NIL, then parent is added to the environment.
-func env_create(parent Atom) Atom {
-	return cons(parent, _nil)
+func (l *Interpreter) env_create(parent Atom) Atom {
+	return l.cons(parent, _nil)
 }
 
 // env_create_default creates a new environment with some native
 // functions added to the symbol table.
-func env_create_default() Atom {
-	// create a new environment
-	env := env_create(_nil)
+func (l *Interpreter) env_create_default() Atom {
+	// create a new environment.
+	// it is the global environment, so it is indexed by a hash table too.
+	env := l.env_create(_nil)
+	l.env, l.globals = env, make(map[*Symbol]Atom)
 	// add the default list of native functions to the environment
-	_ = env_set(env, make_sym([]byte("CAR")), make_builtin(builtin_car))
-	_ = env_set(env, make_sym([]byte("CDR")), make_builtin(builtin_cdr))
-	_ = env_set(env, make_sym([]byte("CONS")), make_builtin(builtin_cons))
-	_ = env_set(env, make_sym([]byte{'+'}), make_builtin(builtin_add))
-	_ = env_set(env, make_sym([]byte{'-'}), make_builtin(builtin_subtract))
-	_ = env_set(env, make_sym([]byte{'*'}), make_builtin(builtin_multiply))
-	_ = env_set(env, make_sym([]byte{'/'}), make_builtin(builtin_divide))
-	_ = env_set(env, make_sym([]byte{'T'}), make_sym([]byte{'T'}))
-	_ = env_set(env, make_sym([]byte{'='}), make_builtin(builtin_numeq))
-	_ = env_set(env, make_sym([]byte{'<'}), make_builtin(builtin_less))
-	_ = env_set(env, make_sym([]byte("EQ?")), make_builtin(builtin_eq))
-	_ = env_set(env, make_sym([]byte("PAIR?")), make_builtin(builtin_pairp))
+	_ = l.env_set(env, l.intern("CAR"), l.make_builtin(builtin_car))
+	_ = l.env_set(env, l.intern("CDR"), l.make_builtin(builtin_cdr))
+	_ = l.env_set(env, l.intern("CONS"), l.make_builtin(l.builtin_cons))
+	_ = l.env_set(env, l.intern("+"), l.make_builtin(builtin_add))
+	_ = l.env_set(env, l.intern("-"), l.make_builtin(builtin_subtract))
+	_ = l.env_set(env, l.intern("*"), l.make_builtin(builtin_multiply))
+	_ = l.env_set(env, l.intern("/"), l.make_builtin(builtin_divide))
+	_ = l.env_set(env, l.intern("T"), _true)
+	_ = l.env_set(env, l.intern("="), l.make_builtin(builtin_numeq))
+	_ = l.env_set(env, l.intern("<"), l.make_builtin(builtin_less))
+	_ = l.env_set(env, l.intern("EQ?"), l.make_builtin(builtin_eq))
+	_ = l.env_set(env, l.intern("EQV?"), l.make_builtin(builtin_eq))
+	_ = l.env_set(env, l.intern("EQUAL?"), l.make_builtin(builtin_equal))
+	_ = l.env_set(env, l.intern("PAIR?"), l.make_builtin(builtin_pairp))
+	_ = l.env_set(env, l.intern("SET-CAR!"), l.make_builtin(builtin_set_car))
+	_ = l.env_set(env, l.intern("SET-CDR!"), l.make_builtin(builtin_set_cdr))
+	_ = l.env_set(env, l.intern("ACOS"), l.make_builtin(builtin_transcendental(math.Acos)))
+	_ = l.env_set(env, l.intern("ASIN"), l.make_builtin(builtin_transcendental(math.Asin)))
+	_ = l.env_set(env, l.intern("ATAN"), l.make_builtin(builtin_atan))
+	_ = l.env_set(env, l.intern("BOOLEAN?"), l.make_builtin(builtin_booleanp))
+	_ = l.env_set(env, l.intern("CEILING"), l.make_builtin(builtin_round(math.Ceil, rat_ceiling)))
+	_ = l.env_set(env, l.intern("COS"), l.make_builtin(builtin_transcendental(math.Cos)))
+	_ = l.env_set(env, l.intern("ERROR"), l.make_builtin(builtin_error))
+	_ = l.env_set(env, l.intern("ERROR-OBJECT?"), l.make_builtin(builtin_error_objectp))
+	_ = l.env_set(env, l.intern("ERROR-OBJECT-IRRITANTS"), l.make_builtin(builtin_error_object_irritants))
+	_ = l.env_set(env, l.intern("ERROR-OBJECT-MESSAGE"), l.make_builtin(builtin_error_object_message))
+	_ = l.env_set(env, l.intern("EXACT?"), l.make_builtin(builtin_exactp))
+	_ = l.env_set(env, l.intern("EXACT->INEXACT"), l.make_builtin(builtin_exact_to_inexact))
+	_ = l.env_set(env, l.intern("EXP"), l.make_builtin(builtin_transcendental(math.Exp)))
+	_ = l.env_set(env, l.intern("EXPT"), l.make_builtin(builtin_expt))
+	_ = l.env_set(env, l.intern("FLOOR"), l.make_builtin(builtin_round(math.Floor, rat_floor)))
+	_ = l.env_set(env, l.intern("GC"), l.make_builtin(l.builtin_gc))
+	_ = l.env_set(env, l.intern("HEAP-STATS"), l.make_builtin(l.builtin_heap_stats))
+	_ = l.env_set(env, l.intern("INEXACT->EXACT"), l.make_builtin(builtin_inexact_to_exact))
+	_ = l.env_set(env, l.intern("INTEGER?"), l.make_builtin(builtin_integerp))
+	_ = l.env_set(env, l.intern("DENOMINATOR"), l.make_builtin(builtin_denominator))
+	_ = l.env_set(env, l.intern("LOG"), l.make_builtin(builtin_log))
+	_ = l.env_set(env, l.intern("MODULO"), l.make_builtin(builtin_modulo))
+	_ = l.env_set(env, l.intern("NUMERATOR"), l.make_builtin(builtin_numerator))
+	_ = l.env_set(env, l.intern("NUMBER?"), l.make_builtin(builtin_numberp))
+	_ = l.env_set(env, l.intern("QUOTIENT"), l.make_builtin(builtin_quotient))
+	_ = l.env_set(env, l.intern("RAISE"), l.make_builtin(builtin_raise))
+	_ = l.env_set(env, l.intern("REMAINDER"), l.make_builtin(builtin_remainder))
+	_ = l.env_set(env, l.intern("ROUND"), l.make_builtin(builtin_round(math.RoundToEven, rat_round)))
+	_ = l.env_set(env, l.intern("SIN"), l.make_builtin(builtin_transcendental(math.Sin)))
+	_ = l.env_set(env, l.intern("SQRT"), l.make_builtin(builtin_sqrt))
+	_ = l.env_set(env, l.intern("TAN"), l.make_builtin(builtin_transcendental(math.Tan)))
+	_ = l.env_set(env, l.intern("TRUNCATE"), l.make_builtin(builtin_round(math.Trunc, rat_truncate)))
+	_ = l.env_set(env, l.intern("NUMBER->STRING"), l.make_builtin(builtin_number_to_string))
+	_ = l.env_set(env, l.intern("STRING-APPEND"), l.make_builtin(builtin_string_append))
+	_ = l.env_set(env, l.intern("STRING-LENGTH"), l.make_builtin(builtin_string_length))
+	_ = l.env_set(env, l.intern("STRING->NUMBER"), l.make_builtin(builtin_string_to_number))
+	_ = l.env_set(env, l.intern("STRING->SYMBOL"), l.make_builtin(l.builtin_string_to_symbol))
+	_ = l.env_set(env, l.intern("STRING<?"), l.make_builtin(builtin_string_less))
+	_ = l.env_set(env, l.intern("STRING=?"), l.make_builtin(builtin_string_eq))
+	_ = l.env_set(env, l.intern("STRING?"), l.make_builtin(builtin_stringp))
+	_ = l.env_set(env, l.intern("SUBSTRING"), l.make_builtin(builtin_substring))
+	_ = l.env_set(env, l.intern("SYMBOL->STRING"), l.make_builtin(builtin_symbol_to_string))
+	_ = l.env_set(env, l.intern("LIST->VECTOR"), l.make_builtin(builtin_list_to_vector))
+	_ = l.env_set(env, l.intern("MAKE-VECTOR"), l.make_builtin(builtin_make_vector))
+	_ = l.env_set(env, l.intern("VECTOR"), l.make_builtin(builtin_vector))
+	_ = l.env_set(env, l.intern("VECTOR?"), l.make_builtin(builtin_vectorp))
+	_ = l.env_set(env, l.intern("VECTOR-FILL!"), l.make_builtin(builtin_vector_fill))
+	_ = l.env_set(env, l.intern("VECTOR-LENGTH"), l.make_builtin(builtin_vector_length))
+	_ = l.env_set(env, l.intern("VECTOR-MAP"), l.make_builtin(l.builtin_vector_map))
+	_ = l.env_set(env, l.intern("VECTOR-REF"), l.make_builtin(builtin_vector_ref))
+	_ = l.env_set(env, l.intern("VECTOR-SET!"), l.make_builtin(builtin_vector_set))
+	_ = l.env_set(env, l.intern("VECTOR->LIST"), l.make_builtin(l.builtin_vector_to_list))
+	_ = l.env_set(env, l.intern("CHAR?"), l.make_builtin(builtin_charp))
+	_ = l.env_set(env, l.intern("CHAR-ALPHABETIC?"), l.make_builtin(builtin_char_class(unicode.IsLetter)))
+	_ = l.env_set(env, l.intern("CHAR-DOWNCASE"), l.make_builtin(builtin_char_case(unicode.ToLower)))
+	_ = l.env_set(env, l.intern("CHAR-NUMERIC?"), l.make_builtin(builtin_char_class(unicode.IsDigit)))
+	_ = l.env_set(env, l.intern("CHAR-UPCASE"), l.make_builtin(builtin_char_case(unicode.ToUpper)))
+	_ = l.env_set(env, l.intern("CHAR-WHITESPACE?"), l.make_builtin(builtin_char_class(unicode.IsSpace)))
+	_ = l.env_set(env, l.intern("CHAR->INTEGER"), l.make_builtin(builtin_char_to_integer))
+	_ = l.env_set(env, l.intern("INTEGER->CHAR"), l.make_builtin(builtin_integer_to_char))
+	_ = l.env_set(env, l.intern("HASH-TABLE?"), l.make_builtin(builtin_hash_tablep))
+	_ = l.env_set(env, l.intern("HASH-TABLE-COUNT"), l.make_builtin(builtin_hash_table_count))
+	_ = l.env_set(env, l.intern("HASH-TABLE-DELETE!"), l.make_builtin(builtin_hash_table_delete))
+	_ = l.env_set(env, l.intern("HASH-TABLE-KEYS"), l.make_builtin(l.builtin_hash_table_keys))
+	_ = l.env_set(env, l.intern("HASH-TABLE-REF"), l.make_builtin(builtin_hash_table_ref))
+	_ = l.env_set(env, l.intern("HASH-TABLE-SET!"), l.make_builtin(builtin_hash_table_set))
+	_ = l.env_set(env, l.intern("HASH-TABLE-WALK"), l.make_builtin(l.builtin_hash_table_walk))
+	_ = l.env_set(env, l.intern("MAKE-HASH-TABLE"), l.make_builtin(l.builtin_make_hash_table))
+	_ = l.env_set(env, l.intern("GENSYM"), l.make_builtin(l.builtin_gensym))
+	_ = l.env_set(env, l.intern("MACROEXPAND"), l.make_builtin(l.builtin_macroexpand))
+	_ = l.env_set(env, l.intern("MACROEXPAND-1"), l.make_builtin(l.builtin_macroexpand_1))
 
 	// return the new environment
 	return env
@@ -33,29 +115,169 @@ func env_create_default() Atom {
 
 // env_get retrieves the binding for a symbol from the environment.
 // does not update result unless it finds a symbol in the environment.
-func env_get(env, symbol Atom, result *Atom) error {
-	for bs := cdr(env); !nilp(bs); bs = cdr(bs) {
-		if b := car(bs); car(b).value.symbol == symbol.value.symbol {
-			*result = cdr(b)
-			return nil
+// the global environment is searched with its hash table.
+// the symbol may be a local, which is found without searching.
+func (l *Interpreter) env_get(env, symbol Atom, result *Atom) error {
+	if value := l.env_lookup(env, symbol); value != nil {
+		*result = *value
+		return nil
+	}
+	// not found, so return an unbound error
+	return error_value(Error_Unbound, symbol_of(symbol))
+}
+
+// env_lookup returns the value bound to a symbol in the environment or
+// its parents. it returns nil if the symbol is unbound. the pointer is
+// only valid until the next binding is created.
+func (l *Interpreter) env_lookup(env, symbol Atom) *Atom {
+	if symbol._type == AtomType_Local {
+		if value := env_slot(env, symbol); value != nil {
+			return value
 		}
+		// the name is used before it is defined, so search for it
+		symbol = symbol_of(symbol)
 	}
-	// search the parent environment (if we have one).
-	if parent := car(env); !nilp(parent) {
-		return env_get(parent, symbol, result)
+	for ; !nilp(env); env = car(env) {
+		if slots := frame_slots(env); slots != nil {
+			if value := frame_get(slots, symbol.value.symbol()); value != nil {
+				return value
+			}
+			continue
+		} else if l.env_global(env) {
+			if b, ok := l.globals[symbol.value.symbol()]; ok {
+				return &b.value.pair().cdr
+			}
+			break
+		}
+		for bs := cdr(env); !nilp(bs); bs = cdr(bs) {
+			if b := car(bs); car(b).value.symbol() == symbol.value.symbol() {
+				return &b.value.pair().cdr
+			}
+		}
 	}
-	// not found, so return an unbound error
-	return Error_Unbound
+	if a := symbol.value.symbol().alias; a != nil {
+		// a symbol introduced by a macro refers to the binding
+		// where the macro was defined
+		return l.env_lookup(a.syntax.env, a.symbol)
+	}
+	return nil
+}
+
+// env_slot returns the slot that a local refers to, or nil if the
+// slot is unbound.
+func env_slot(env, local Atom) *Atom {
+	depth, index := local.value.local()
+	for ; depth != 0; depth-- {
+		env = car(env)
+	}
+	if items := cdr(env).value.vector().items; index+1 < len(items) && items[index+1].value.symbol() != unbound {
+		return &items[index+1]
+	}
+	return nil
+}
+
+// frame_slots returns the slots of the environment for a call to a
+// closure, or nil if the environment is an association list.
+// the first slot holds the closure, which has the names of the others.
+func frame_slots(env Atom) *Vector {
+	if slots := cdr(env); slots._type == AtomType_Vector {
+		return slots.value.vector()
+	}
+	return nil
+}
+
+// frame_index returns the index of the slot for a name, or -1 if the
+// closure doesn't have a slot for it.
+func frame_index(slots *Vector, sym *Symbol) int {
+	return slots.items[0].value.closure().lambda.index(sym)
+}
+
+// frame_get returns the slot for a name, or nil if there isn't one
+// or it is unbound.
+func frame_get(slots *Vector, sym *Symbol) *Atom {
+	if index := frame_index(slots, sym); index >= 0 && index+1 < len(slots.items) && slots.items[index+1].value.symbol() != unbound {
+		return &slots.items[index+1]
+	}
+	return nil
+}
+
+// env_global returns true if the environment is the global environment
+// and it has a hash table.
+func (l *Interpreter) env_global(env Atom) bool {
+	return l.globals != nil && env.value.pair() == l.env.value.pair()
 }
 
 // env_set creates a binding for a symbol in the environment.
-func env_set(env, symbol, value Atom) error {
+// if the symbol is already bound, the binding is updated.
+// it never changes a binding in a parent, so DEFINE inside a
+// procedure shadows a global instead of replacing it.
+func (l *Interpreter) env_set(env, symbol, value Atom) error {
+	if slots := frame_slots(env); slots != nil {
+		// a name that wasn't found when the closure was created,
+		// such as one defined by a macro, gets a new slot
+		index := frame_index(slots, symbol.value.symbol())
+		if index < 0 {
+			lambda := slots.items[0].value.closure().lambda
+			lambda.names = append(lambda.names, symbol.value.symbol())
+			index = len(lambda.names) - 1
+		}
+		for len(slots.items) < index+2 {
+			slots.items = append(slots.items, _unbound)
+		}
+		slots.items[index+1] = value
+		return nil
+	} else if l.env_global(env) {
+		if b, ok := l.globals[symbol.value.symbol()]; ok {
+			b.value.pair().cdr = value
+			return nil
+		}
+		b := l.cons(symbol, value)
+		setcdr(env, l.cons(b, cdr(env)))
+		l.globals[symbol.value.symbol()] = b
+		return nil
+	}
 	for bs := cdr(env); !nilp(bs); bs = cdr(bs) {
-		if b := car(bs); car(b).value.symbol == symbol.value.symbol {
-			b.value.pair.cdr = value
+		if b := car(bs); car(b).value.symbol() == symbol.value.symbol() {
+			b.value.pair().cdr = value
 			return nil
 		}
 	}
-	setcdr(env, cons(cons(symbol, value), cdr(env)))
+	setcdr(env, l.cons(l.cons(symbol, value), cdr(env)))
 	return nil
 }
+
+// env_update changes the binding for a symbol in the environment or,
+// if it isn't bound there, the nearest parent that binds it.
+// unlike env_set, it never creates a binding, so SET! on an unbound
+// symbol returns an unbound error.
+func (l *Interpreter) env_update(env, symbol, value Atom) error {
+	if binding := l.env_lookup(env, symbol); binding != nil {
+		*binding = value
+		return nil
+	}
+	// not found, so return an unbound error
+	return error_value(Error_Unbound, symbol_of(symbol))
+}
+
+// env_name returns the name of a symbol bound to the value in the
+// environment or its parents. values are compared by identity, so this
+// is only useful for procedures. returns "" if the value isn't bound.
+func env_name(env, value Atom) string {
+	for ; !nilp(env); env = car(env) {
+		if slots := frame_slots(env); slots != nil {
+			names := slots.items[0].value.closure().lambda.names
+			for index, slot := range slots.items[1:] {
+				if index < len(names) && slot._type == value._type && slot.value == value.value {
+					return string(names[index].label)
+				}
+			}
+			continue
+		}
+		for bs := cdr(env); !nilp(bs); bs = cdr(bs) {
+			if b := car(bs); cdr(b)._type == value._type && cdr(b).value == value.value {
+				return string(car(b).value.symbol().label)
+			}
+		}
+	}
+	return ""
+}
@@ -2,13 +2,121 @@
 
 package lisp
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LispError is an error raised while reading or evaluating an expression.
+// It wraps one of the Error_ sentinels, so errors.Is can be used to check
+// the kind of error, and adds whatever context was known when the error
+// was raised.
+type LispError struct {
+	// Err is the underlying error.
+	Err error
+	// Pos is the position in the source code where the error was found.
+	// It is nil if the position is not known.
+	Pos *Position
+	// Proc is the name of the builtin or closure that raised the error.
+	// It is empty if the error was not raised by a procedure or if the
+	// procedure doesn't have a name.
+	Proc string
+	// Value is the offending symbol or value, or nil if there isn't one.
+	Value *Atom
+	// Expected and Actual describe the type or number of arguments that
+	// the procedure wanted and what it was given.
+	// They are empty if the error isn't a type or arity mismatch.
+	Expected, Actual string
+	// Stack is the Lisp call stack at the time of the error, innermost
+	// call first. Each entry is the name of the procedure or special
+	// form followed by its position, if known.
+	Stack []string
+}
+
+// Error implements the error interface.
+// The call stack is not included in the message.
+func (e *LispError) Error() string {
+	sb := &strings.Builder{}
+	if e.Pos != nil {
+		sb.WriteString(e.Pos.String())
+		sb.WriteString(": ")
+	}
+	sb.WriteString(e.Err.Error())
+	if e.Proc != "" {
+		sb.WriteString(" in ")
+		sb.WriteString(e.Proc)
+	}
+	if e.Expected != "" {
+		fmt.Fprintf(sb, ": expected %s, got %s", e.Expected, e.Actual)
+	}
+	if e.Value != nil {
+		sb.WriteString(": ")
+		sb.WriteString(e.Value.String())
+	}
+	return sb.String()
+}
+
+// Unwrap returns the underlying error.
+func (e *LispError) Unwrap() error {
+	return e.Err
+}
+
+// lisp_error returns err as a LispError, wrapping it if needed.
+func lisp_error(err error) *LispError {
+	if e, ok := err.(*LispError); ok {
+		return e
+	}
+	return &LispError{Err: err}
+}
+
+// error_at returns err with the position added.
+// if err already has a position, or if pos is nil, err is returned as is.
+func error_at(err error, pos *Position) error {
+	if pos == nil || err == nil || err == Error_EndOfInput {
+		return err
+	}
+	e := lisp_error(err)
+	if e.Pos == nil {
+		e.Pos = pos
+	}
+	return e
+}
+
+// error_value returns err with the offending symbol or value added.
+func error_value(err error, value Atom) error {
+	return &LispError{Err: err, Value: &value}
+}
+
+// error_type returns a type error for a value that isn't the expected type.
+// expected describes the type, for example "pair" or "number".
+func error_type(expected string, value Atom) error {
+	return &LispError{Err: Error_Type, Value: &value, Expected: expected, Actual: value._type.String()}
+}
+
+// error_args returns an arity error for a list of arguments that is
+// too short or too long. expected describes the number of arguments,
+// for example "2" or "at least 1".
+func error_args(expected string, args Atom) error {
+	n := 0
+	for ; args._type == AtomType_Pair; args = cdr(args) {
+		n++
+	}
+	return &LispError{Err: Error_Args, Expected: expected, Actual: strconv.Itoa(n)}
+}
 
 var (
 	// Error_Args is returned when a list expression was shorter or longer than anticipated.
 	Error_Args = fmt.Errorf("args")
+	// Error_DivideByZero is returned when an exact number is divided by zero.
+	Error_DivideByZero = fmt.Errorf("divide by zero")
 	// Error_EndOfInput is returned at end of input.
 	Error_EndOfInput = fmt.Errorf("eof")
+	// Error_Raise is returned when Lisp code raises a value with RAISE or ERROR
+	// and no GUARD catches it. The LispError holds the raised value.
+	Error_Raise = fmt.Errorf("raise")
+	// Error_Range is returned when an index or size is out of range.
+	Error_Range = fmt.Errorf("range")
 	// Error_Syntax is returned for almost every error parsing.
 	Error_Syntax = fmt.Errorf("syntax")
 	// Error_Type is returned when an object in an expression isn't the expected type.
@@ -16,3 +124,19 @@ var (
 	// Error_Unbound is returned when we attempt to evaluate an unbound symbol.
 	Error_Unbound = fmt.Errorf("unbound")
 )
+
+// error_in returns err with the name of the procedure that raised it.
+// the name is found by looking for the procedure in the environment.
+// err is returned as is if op is not a procedure or doesn't have a name.
+func error_in(err error, op, env Atom) error {
+	switch op._type {
+	case AtomType_Builtin, AtomType_Closure, AtomType_Continuation, AtomType_Macro:
+	default:
+		return err
+	}
+	e := lisp_error(err)
+	if e.Proc == "" {
+		e.Proc = env_name(env, op)
+	}
+	return e
+}
@@ -2,6 +2,8 @@
 
 package lisp
 
+import "fmt"
+
 // eval_do_exec sets expr to the next part of the function
 // body, and pops the stack when we have reached end of the body.
 func eval_do_exec(stack, expr, env *Atom) error {
@@ -20,47 +22,69 @@ func eval_do_exec(stack, expr, env *Atom) error {
 // eval_do_bind binds the function arguments into a new environment
 // if they have not already been bound, then calls eval_do_exec to
 // get the next expression in the body.
-func eval_do_bind(stack, expr, env *Atom) error {
+// the environment has a vector of slots for the arguments and the
+// names defined in the body. the first slot holds the closure.
+func (l *Interpreter) eval_do_bind(stack, expr, env *Atom) error {
 	body := list_get(*stack, FRAME_BODY)
 	if !nilp(body) {
 		return eval_do_exec(stack, expr, env)
 	}
 	op := list_get(*stack, FRAME_OP)
 	args := list_get(*stack, FRAME_ARGS)
-
-	*env = env_create(car(op))
-	arg_names := car(cdr(op))
-	body = cdr(cdr(op))
-	list_set(*stack, FRAME_ENV, *env)
-	list_set(*stack, FRAME_BODY, body)
+	lambda := op.value.closure().lambda
 
 	// bind the arguments
-	for !nilp(arg_names) {
+	slots := make([]Atom, len(lambda.names)+1)
+	slots[0] = op
+	n := 1
+	for arg_names := lambda.args; !nilp(arg_names); n++ {
 		if arg_names._type == AtomType_Symbol {
-			_ = env_set(*env, arg_names, args)
+			slots[n] = args
 			args = _nil
+			n++
 			break
 		} else if nilp(args) {
 			// it is an error if we have too few arguments
-			return Error_Args
+			return error_args(arity(lambda.args), list_get(*stack, FRAME_ARGS))
 		}
-		_ = env_set(*env, car(arg_names), car(args))
+		slots[n] = car(args)
 		arg_names = cdr(arg_names)
 		args = cdr(args)
 	}
 	if !nilp(args) {
 		// it is an error if we have too many arguments
-		return Error_Args
+		return error_args(arity(lambda.args), list_get(*stack, FRAME_ARGS))
+	}
+	// the names defined in the body are unbound until they are defined
+	for ; n < len(slots); n++ {
+		slots[n] = _unbound
 	}
+
+	*env = l.cons(op.value.closure().env, Atom{_type: AtomType_Vector, value: AtomValue{ref: &Vector{items: slots}}})
+	list_set(*stack, FRAME_ENV, *env)
+	list_set(*stack, FRAME_BODY, lambda.body)
 	list_set(*stack, FRAME_ARGS, args)
 
 	return eval_do_exec(stack, expr, env)
 }
 
+// arity returns a description of the number of arguments
+// that a closure with the given argument names expects.
+func arity(arg_names Atom) string {
+	n := 0
+	for ; arg_names._type == AtomType_Pair; arg_names = cdr(arg_names) {
+		n++
+	}
+	if arg_names._type == AtomType_Symbol {
+		return fmt.Sprintf("at least %d", n)
+	}
+	return fmt.Sprintf("%d", n)
+}
+
 // eval_do_apply is called once all arguments have been evaluated.
 // it is responsible either generating an expression to call a builtin,
-// or delegating to eval_do_bind.
-func eval_do_apply(stack, expr, env, result *Atom) error {
+// reinstating a continuation, or delegating to eval_do_bind.
+func (l *Interpreter) eval_do_apply(stack, expr, env, result *Atom) error {
 	op := list_get(*stack, FRAME_OP)
 	args := list_get(*stack, FRAME_ARGS)
 
@@ -69,38 +93,52 @@ func eval_do_apply(stack, expr, env, result *Atom) error {
 		list_set(*stack, 4, args)
 	}
 
-	if op._type == AtomType_Symbol {
-		if op.value.symbol.EqualString("APPLY") {
-			// replace the current frame
-			*stack = car(*stack)
-			*stack = make_frame(*stack, *env, _nil)
-			// update the op and args in the new frame
-			op = car(args)
-			list_set(*stack, FRAME_OP, op)
-			if args = car(cdr(args)); !listp(args) {
-				return Error_Syntax
-			}
-			list_set(*stack, FRAME_ARGS, args)
+	if l.form(op) == form_apply {
+		// replace the current frame, keeping its position
+		pos := stack.value.pair().pos
+		*stack = car(*stack)
+		*stack = l.make_frame(*stack, *env, _nil)
+		stack.value.pair().pos = pos
+		// update the op and args in the new frame
+		op = car(args)
+		list_set(*stack, FRAME_OP, op)
+		if args = car(cdr(args)); !listp(args) {
+			return error_value(Error_Syntax, args)
 		}
+		list_set(*stack, FRAME_ARGS, args)
 	}
 
-	// we must have a builtin or closure to continue
+	// we must have a builtin, continuation, or closure to continue
 	if op._type == AtomType_Builtin {
+		// the call has the position of the frame so that errors
+		// raised by the builtin are reported at the call site.
+		pos := stack.value.pair().pos
 		*stack = car(*stack)
-		*expr = cons(op, args)
+		*expr = l.cons(op, args)
+		expr.value.pair().pos = pos
+		return nil
+	} else if op._type == AtomType_Continuation {
+		// verify number of arguments
+		if nilp(args) || !nilp(cdr(args)) {
+			return error_args("1", args)
+		}
+		// abandon the current stack and reinstate a copy of the captured one.
+		// the argument is quoted so that it is delivered as the result.
+		*stack = l.stack_copy(op.value.continuation().stack)
+		*expr = l.cons(l.intern("QUOTE"), l.cons(car(args), _nil))
 		return nil
 	} else if op._type != AtomType_Closure {
-		return Error_Type
+		return error_type("procedure", op)
 	}
 
-	return eval_do_bind(stack, expr, env)
+	return l.eval_do_bind(stack, expr, env)
 }
 
 // eval_do_return is called after an expression has been evaluated.
 // is responsible for storing the result, which is either an operator,
 // an argument, or an intermediate body expression, and fetching the
 // next expression to evaluate.
-func eval_do_return(stack, expr, env, result *Atom) error {
+func (l *Interpreter) eval_do_return(stack, expr, env, result *Atom) error {
 	var op, body, args, sym Atom
 
 	*env = list_get(*stack, FRAME_ENV)
@@ -108,8 +146,8 @@ func eval_do_return(stack, expr, env, result *Atom) error {
 	body = list_get(*stack, FRAME_BODY)
 
 	if !nilp(body) {
-		// still running a procedure; ignore the intermediate result
-		return eval_do_apply(stack, expr, env, result)
+		// still running a procedure or BEGIN; ignore the intermediate result
+		return eval_do_exec(stack, expr, env)
 	}
 
 	if nilp(op) {
@@ -119,34 +157,82 @@ func eval_do_return(stack, expr, env, result *Atom) error {
 
 		if op._type == AtomType_Macro {
 			// don't evaluate macro arguments
-			args = list_get(*stack, FRAME_TAIL)
-			*stack = make_frame(*stack, *env, _nil)
+			args = l.unresolve(*env, list_get(*stack, FRAME_TAIL))
+			*stack = l.make_frame(*stack, *env, _nil)
+			stack.value.pair().pos = stack.value.pair().car.value.pair().pos
 			op._type = AtomType_Closure
 			list_set(*stack, FRAME_OP, op)
 			list_set(*stack, FRAME_ARGS, args)
-			return eval_do_bind(stack, expr, env)
+			return l.eval_do_bind(stack, expr, env)
+		} else if op._type == AtomType_Syntax {
+			// evaluate the expansion in place of the form
+			expansion, err := l.expand_syntax(op.value.syntax(), l.unresolve(*env, list_get(*stack, FRAME_TAIL)), *env, nil)
+			if err != nil {
+				return err
+			}
+			if expansion._type == AtomType_Pair && expansion.value.pair().pos == nil {
+				expansion.value.pair().pos = stack.value.pair().pos
+			}
+			*stack = car(*stack)
+			*expr = expansion
+			return nil
 		}
 	} else if op._type == AtomType_Symbol {
 		// finished working on special form
-		if op.value.symbol.EqualString("DEFINE") {
+		switch l.form(op) {
+		case form_define:
+			sym = list_get(*stack, 4)
+			_ = l.env_set(*env, sym, *result)
+			*stack = car(*stack)
+			*expr = l.cons(l.intern("QUOTE"), l.cons(sym, _nil))
+			return nil
+		case form_set:
 			sym = list_get(*stack, 4)
-			_ = env_set(*env, sym, *result)
+			if err := l.env_update(*env, sym, *result); err != nil {
+				return err
+			}
 			*stack = car(*stack)
-			*expr = cons(make_sym([]byte("QUOTE")), cons(sym, _nil))
+			*expr = l.cons(l.intern("QUOTE"), l.cons(symbol_of(sym), _nil))
 			return nil
-		} else if op.value.symbol.EqualString("IF") {
+		case form_and, form_or:
+			// AND stops at the first false value and OR at the first true one
 			args = list_get(*stack, FRAME_TAIL)
-			if nilp(*result) {
+			if l.falsep(*result) == (l.form(op) == form_and) {
+				*stack = car(*stack)
+				*expr = l.cons(l.intern("QUOTE"), l.cons(*result, _nil))
+			} else if nilp(cdr(args)) {
+				// the last expression is in tail position
+				*stack = car(*stack)
+				*expr = car(args)
+			} else {
+				list_set(*stack, FRAME_TAIL, cdr(args))
+				*expr = car(args)
+			}
+			return nil
+		case form_if:
+			args = list_get(*stack, FRAME_TAIL)
+			if l.falsep(*result) {
 				*expr = car(cdr(args))
 			} else {
 				*expr = car(args)
 			}
 			*stack = car(*stack)
 			return nil
+		case form_call_cc:
+			// capture the frames waiting for the result of this form,
+			// then apply the procedure to the continuation.
+			list_set(*stack, FRAME_OP, *result)
+			list_set(*stack, FRAME_ARGS, l.cons(l.make_continuation(car(*stack)), _nil))
+			return l.eval_do_apply(stack, expr, env, result)
+		case form_guard:
+			// the body returned normally, so its value is the result
+			*stack = car(*stack)
+			*expr = l.cons(l.intern("QUOTE"), l.cons(*result, _nil))
+			return nil
 		}
 		// store evaluated argument
 		args = list_get(*stack, FRAME_ARGS)
-		list_set(*stack, FRAME_ARGS, cons(*result, args))
+		list_set(*stack, FRAME_ARGS, l.cons(*result, args))
 	} else if op._type == AtomType_Macro {
 		// finished evaluating macro
 		*expr = *result
@@ -155,13 +241,13 @@ func eval_do_return(stack, expr, env, result *Atom) error {
 	} else {
 		// store evaluated argument
 		args = list_get(*stack, FRAME_ARGS)
-		list_set(*stack, FRAME_ARGS, cons(*result, args))
+		list_set(*stack, FRAME_ARGS, l.cons(*result, args))
 	}
 
 	args = list_get(*stack, FRAME_TAIL)
 	if nilp(args) {
 		// no more arguments left to evaluate
-		return eval_do_apply(stack, expr, env, result)
+		return l.eval_do_apply(stack, expr, env, result)
 	}
 
 	// evaluate next argument
@@ -171,114 +257,293 @@ func eval_do_return(stack, expr, env, result *Atom) error {
 }
 
 // eval_expr evaluates an expression with a given environment and updates the result.
-// much of the work is for setting up special forms; the rest is a loop to process
-// then entire stack frame.
+// if the interpreter was created with WithBytecode, the expression is
+// compiled and run on the virtual machine instead.
+// errors are passed to the innermost GUARD form on the stack. if there isn't
+// one, the error is returned.
 // note that the result may not be updated if we find errors.
-func eval_expr(expr, env Atom, result *Atom) error {
+func (l *Interpreter) eval_expr(expr, env Atom, result *Atom) error {
+	// the caller may still need the expression after it is evaluated,
+	// so it must survive garbage collection.
+	top := expr
+	l.roots = append(l.roots, &top)
+	defer func() {
+		l.roots = l.roots[:len(l.roots)-1]
+	}()
+
+	if l.bytecode {
+		return l.vm_eval(expr, env, result)
+	}
+
 	var stack Atom
+	for {
+		err := l.eval_stack(&stack, expr, env, result)
+		if err == nil {
+			return nil
+		} else if expr, env, err = l.eval_do_raise(&stack, err); err != nil {
+			return err
+		}
+	}
+}
+
+// eval_do_raise unwinds the stack to the innermost GUARD frame and returns
+// the expression and environment for the guard's clauses, with the guard's
+// variable bound to the raised value. if there is no GUARD frame on the
+// stack, it returns the error.
+func (l *Interpreter) eval_do_raise(stack *Atom, err error) (expr, env Atom, _ error) {
+	for frame := *stack; !nilp(frame); frame = car(frame) {
+		if l.form(list_get(frame, FRAME_OP)) != form_guard {
+			continue
+		}
+		// spec is (var clause...)
+		spec, value := list_get(frame, FRAME_ARGS), condition_of(err)
+		env = l.env_create(list_get(frame, FRAME_ENV))
+		_ = l.env_set(env, car(spec), value)
+		*stack = car(frame)
+		// the builtin is called directly, so the value isn't evaluated
+		raise := l.list_of(l.make_builtin(builtin_raise), value)
+		return l.cond_clauses(cdr(spec), raise), env, nil
+	}
+	return _nil, _nil, err
+}
+
+// eval_stack evaluates an expression and then the rest of the stack.
+// much of the work is for setting up special forms; the rest is a loop to process
+// then entire stack frame.
+// on error, the stack is left as it was when the error was found.
+// errors are reported at the position of the expression being
+// evaluated or, if that isn't known, of the innermost call.
+func (l *Interpreter) eval_stack(sp *Atom, expr, env Atom, result *Atom) (err error) {
+	stack := *sp
+	l.roots = append(l.roots, &stack, &expr, &env, result)
+	defer func() {
+		l.roots = l.roots[:len(l.roots)-4]
+		*sp = stack
+		if err != nil {
+			if pos := pos_of(expr); pos != nil {
+				err = error_at(err, pos)
+			} else {
+				err = error_at(err, frame_pos(stack))
+			}
+			if e := lisp_error(err); e.Stack == nil {
+				e.Stack = stack_trace(stack)
+				err = e
+			}
+		}
+	}()
 
 	// do {...} while (!err);
 	for {
-		if expr._type == AtomType_Symbol {
-			if err := env_get(env, expr, result); err != nil {
+		if l.gc_needed() {
+			l.gc()
+		}
+
+		if expr._type == AtomType_Local || expr._type == AtomType_Symbol {
+			if err := l.env_get(env, expr, result); err != nil {
 				return err
 			}
 		} else if expr._type != AtomType_Pair {
 			*result = expr
 		} else if !listp(expr) {
-			return Error_Syntax
+			return error_value(Error_Syntax, expr)
 		} else {
 			op, args := car(expr), cdr(expr)
 			if op._type == AtomType_Symbol {
 				// handle special forms
-				if op.value.symbol.EqualString("QUOTE") {
+				switch l.form(op) {
+				case form_quote:
 					// verify number and type of args
 					if nilp(args) || !nilp(cdr(args)) {
-						return Error_Args
+						return error_args("1", args)
 					}
 					*result = car(args)
-				} else if op.value.symbol.EqualString("DEFINE") {
+				case form_define:
 					// verify number and type of args
 					if nilp(args) || nilp(cdr(args)) {
-						return Error_Args
+						return error_args("at least 2", args)
 					}
 					if sym := car(args); sym._type == AtomType_Pair {
-						if err := make_closure(env, cdr(sym), cdr(args), result); err != nil {
+						if err := l.make_closure(env, cdr(sym), cdr(args), result); err != nil {
 							return err
 						} else if sym = car(sym); sym._type != AtomType_Symbol {
-							return Error_Type
+							return error_type("symbol", sym)
 						}
-						_ = env_set(env, sym, *result)
+						_ = l.env_set(env, sym, *result)
 						*result = sym
 					} else if sym._type == AtomType_Symbol {
 						if !nilp(cdr(cdr(args))) {
-							return Error_Args
+							return error_args("2", args)
 						}
-						stack = make_frame(stack, env, _nil)
+						stack = l.make_frame(stack, env, _nil)
+						stack.value.pair().pos = pos_of(expr)
 						list_set(stack, FRAME_OP, op)
 						list_set(stack, FRAME_ARGS, sym)
 						expr = car(cdr(args))
 						continue
 					} else {
-						return Error_Type
+						return error_type("symbol", sym)
 					}
-				} else if op.value.symbol.EqualString("LAMBDA") {
+				case form_set:
+					// verify number and type of args
+					if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
+						return error_args("2", args)
+					} else if sym := car(args); sym._type != AtomType_Symbol && sym._type != AtomType_Local {
+						return error_type("symbol", sym)
+					}
+					stack = l.make_frame(stack, env, _nil)
+					stack.value.pair().pos = pos_of(expr)
+					list_set(stack, FRAME_OP, op)
+					list_set(stack, FRAME_ARGS, car(args))
+					expr = car(cdr(args))
+					continue
+				case form_lambda:
 					// verify number and type of args
 					if nilp(args) || nilp(cdr(args)) {
-						return Error_Args
+						return error_args("at least 2", args)
 					}
-					if err := make_closure(env, car(args), cdr(args), result); err != nil {
+					if err := l.make_closure(env, car(args), cdr(args), result); err != nil {
 						return err
 					}
-				} else if op.value.symbol.EqualString("IF") {
+				case form_if:
 					// verify number and type of args
 					if nilp(args) || nilp(cdr(args)) || nilp(cdr(cdr(args))) || !nilp(cdr(cdr(cdr(args)))) {
-						return Error_Args
+						return error_args("3", args)
 					}
-					stack = make_frame(stack, env, cdr(args))
+					stack = l.make_frame(stack, env, cdr(args))
+					stack.value.pair().pos = pos_of(expr)
 					list_set(stack, 2, op)
 					expr = car(args)
 					continue
-				} else if op.value.symbol.EqualString("DEFMACRO") {
+				case form_begin:
+					if nilp(args) {
+						*result = _nil
+					} else if nilp(cdr(args)) {
+						// the last expression is in tail position
+						expr = car(args)
+						continue
+					} else {
+						// the frame runs the body like a procedure's
+						stack = l.make_frame(stack, env, _nil)
+						stack.value.pair().pos = pos_of(expr)
+						list_set(stack, FRAME_OP, op)
+						list_set(stack, FRAME_BODY, args)
+						_ = eval_do_exec(&stack, &expr, &env)
+						continue
+					}
+				case form_and, form_or:
+					if nilp(args) {
+						*result = make_boolean(l.form(op) == form_and)
+					} else if nilp(cdr(args)) {
+						// the last expression is in tail position
+						expr = car(args)
+						continue
+					} else {
+						stack = l.make_frame(stack, env, cdr(args))
+						stack.value.pair().pos = pos_of(expr)
+						list_set(stack, FRAME_OP, op)
+						expr = car(args)
+						continue
+					}
+				case form_case, form_cond, form_do, form_let, form_let_star, form_let_syntax, form_letrec, form_unless, form_when:
+					// evaluate the expansion in place of the form.
+					// in the body of a closure, the form has already
+					// been replaced by its expansion.
+					expansion, err := l.expand_derived(op, args)
+					if err != nil {
+						return err
+					}
+					if expansion._type == AtomType_Pair {
+						expansion.value.pair().pos = pos_of(expr)
+					}
+					expr = expansion
+					continue
+				case form_define_syntax:
+					// verify number and type of args
+					if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
+						return error_args("2", args)
+					} else if name := car(args); name._type != AtomType_Symbol {
+						return error_type("symbol", name)
+					}
+					macro, err := l.make_syntax(car(cdr(args)), env, nil)
+					if err != nil {
+						return err
+					}
+					_ = l.env_set(env, car(args), macro)
+					*result = car(args)
+				case form_defmacro:
 					// verify number and type of args
 					if nilp(args) || nilp(cdr(args)) {
-						return Error_Args
+						return error_args("at least 2", args)
 					} else if car(args)._type != AtomType_Pair {
-						return Error_Syntax
+						return error_value(Error_Syntax, car(args))
 					}
 					name := car(car(args))
 					if name._type != AtomType_Symbol {
-						return Error_Type
+						return error_type("symbol", name)
 					}
 					var macro Atom
-					if err := make_closure(env, cdr(car(args)), cdr(args), &macro); err != nil {
+					if err := l.make_closure(env, cdr(car(args)), cdr(args), &macro); err != nil {
 						return err
 					}
 					macro._type = AtomType_Macro
 					*result = name
-					_ = env_set(env, name, macro)
-				} else if op.value.symbol.EqualString("APPLY") {
+					_ = l.env_set(env, name, macro)
+				case form_apply:
 					// verify number and type of args
 					if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
-						return Error_Args
+						return error_args("2", args)
+					}
+					stack = l.make_frame(stack, env, cdr(args))
+					stack.value.pair().pos = pos_of(expr)
+					list_set(stack, FRAME_OP, op)
+					expr = car(args)
+					continue
+				case form_call_cc:
+					// verify number and type of args
+					if nilp(args) || !nilp(cdr(args)) {
+						return error_args("1", args)
 					}
-					stack = make_frame(stack, env, cdr(args))
+					// evaluate the procedure; eval_do_return will call it
+					stack = l.make_frame(stack, env, _nil)
+					stack.value.pair().pos = pos_of(expr)
 					list_set(stack, FRAME_OP, op)
 					expr = car(args)
 					continue
-				} else {
+				case form_guard:
+					// verify number and type of args
+					if nilp(args) || nilp(cdr(args)) {
+						return error_args("at least 2", args)
+					} else if spec := car(args); spec._type != AtomType_Pair || car(spec)._type != AtomType_Symbol {
+						return error_value(Error_Syntax, spec)
+					} else if err := l.check_clauses(cdr(spec)); err != nil {
+						return err
+					}
+					// the frame marks where eval_do_raise unwinds the stack to
+					// if the body raises an error.
+					stack = l.make_frame(stack, env, _nil)
+					stack.value.pair().pos = pos_of(expr)
+					list_set(stack, FRAME_OP, op)
+					list_set(stack, FRAME_ARGS, car(args))
+					// evaluate the body as ((LAMBDA () body...))
+					pos := pos_of(expr)
+					expr = l.cons(l.cons(l.intern("LAMBDA"), l.cons(_nil, cdr(args))), _nil)
+					expr.value.pair().pos = pos
+					continue
+				default:
 					// push a new stack frame to handle function application
-					stack = make_frame(stack, env, args)
+					stack = l.make_frame(stack, env, args)
+					stack.value.pair().pos = pos_of(expr)
 					expr = op
 					continue
 				}
 			} else if op._type == AtomType_Builtin {
-				if err := op.value.builtin.fn(args, result); err != nil {
-					return err
+				if err := op.value.builtin().fn(args, result); err != nil {
+					return error_in(err, op, env)
 				}
 			} else {
 				// push a new stack frame to handle function application
-				stack = make_frame(stack, env, args)
+				stack = l.make_frame(stack, env, args)
+				stack.value.pair().pos = pos_of(expr)
 				expr = op
 				continue
 			}
@@ -290,8 +555,13 @@ func eval_expr(expr, env Atom, result *Atom) error {
 		}
 
 		// try storing the result and fetching the next expression from the stack
-		if err := eval_do_return(&stack, &expr, &env, result); err != nil {
-			return nil
+		if err := l.eval_do_return(&stack, &expr, &env, result); err != nil {
+			// the frame knows where the failing call is better than
+			// the expression that was last evaluated.
+			if !nilp(stack) {
+				err = error_in(err, list_get(stack, FRAME_OP), env)
+			}
+			return error_at(err, frame_pos(stack))
 		}
 	}
 }
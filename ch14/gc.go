@@ -0,0 +1,406 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lisp
+
+// this file implements the mark-and-sweep garbage collector from
+// chapter 15. Go already collects garbage, so the managed heap is
+// optional. when it is enabled, the interpreter allocates pairs (from
+// slabs) and builtins from its own pools and reuses the cells that the
+// collector finds are no longer reachable from the global environment
+// or the eval stack.
+//
+// symbols aren't allocated from the managed heap, but the collector
+// marks them too. if weak interning is enabled, symbols that it doesn't
+// mark are removed from the symbol table, and Go reclaims them.
+
+// gc_free is the mark for cells on the free list.
+const gc_free = ^uint32(0)
+
+// HeapStats reports the use of the managed heap.
+type HeapStats struct {
+	// Collections is the number of times the collector has run.
+	Collections int
+	// Allocated is the total number of cells handed out by the heap.
+	Allocated int
+	// Freed is the total number of cells reclaimed by the collector.
+	Freed int
+	// Live is the number of cells in use.
+	Live int
+	// Free is the number of cells waiting to be reused.
+	Free int
+	// Slabs is the number of slabs allocated for pairs.
+	Slabs int
+}
+
+// heap is a pool of pairs and builtins owned by an interpreter.
+type heap struct {
+	// threshold is the number of allocations between collections.
+	// if it is zero, the collector only runs when GC is called.
+	threshold int
+	// count is the number of allocations since the last collection.
+	count int
+	// epoch is the mark for cells found during the current collection.
+	// cells with any other mark are garbage.
+	epoch uint32
+	// pairs is every pair allocated from the heap.
+	pairs pair_slabs
+	// builtins is every builtin allocated from the heap,
+	// and free_builtins are the ones that can be reused.
+	builtins      []*Builtin
+	free_builtins []*Builtin
+	// stats is updated as cells are allocated and collected.
+	stats HeapStats
+}
+
+// WithHeap returns an option that enables the managed heap.
+// The collector runs after every threshold allocations, or only
+// when GC is called if threshold is zero.
+//
+// Atoms held by Go code are not roots for the collector, so they may
+// be reclaimed by the next collection unless they are reachable from
+// the global environment.
+func WithHeap(threshold int) Option {
+	return func(l *Interpreter) {
+		l.heap = &heap{threshold: threshold}
+	}
+}
+
+// WithWeakSymbols returns an option that lets the garbage collector
+// remove symbols that are no longer used from the symbol table, such as
+// the symbols in data that was read and then thrown away. It only has an
+// effect if the managed heap is enabled.
+//
+// A symbol that is held only by Go code may be removed too, and reading
+// its name again creates a different symbol.
+func WithWeakSymbols() Option {
+	return func(l *Interpreter) {
+		l.weak_symbols = true
+	}
+}
+
+// GC runs the garbage collector and returns the number of cells that
+// it reclaimed. It does nothing if the managed heap is not enabled.
+func (l *Interpreter) GC() int {
+	return l.gc()
+}
+
+// HeapStats returns statistics for the managed heap.
+// All the counts are zero if the managed heap is not enabled.
+func (l *Interpreter) HeapStats() HeapStats {
+	if l.heap == nil {
+		return HeapStats{}
+	}
+	return l.heap.stats
+}
+
+// cons returns a new Pair.
+// it is allocated from the managed heap if it is enabled.
+func (l *Interpreter) cons(car, cdr Atom) Atom {
+	h := l.heap
+	if h == nil {
+		return cons(car, cdr)
+	}
+	p := h.pairs.alloc()
+	if p.mark == gc_free {
+		h.stats.Free--
+	}
+	*p = Pair{car: car, cdr: cdr}
+	h.stats.Slabs = len(h.pairs.slabs)
+	h.count, h.stats.Allocated, h.stats.Live = h.count+1, h.stats.Allocated+1, h.stats.Live+1
+	return Atom{_type: AtomType_Pair, value: AtomValue{ref: p}}
+}
+
+// make_builtin returns a new Builtin.
+// it is allocated from the managed heap if it is enabled.
+func (l *Interpreter) make_builtin(fn Native) Atom {
+	h := l.heap
+	if h == nil {
+		return make_builtin(fn)
+	}
+	var b *Builtin
+	if n := len(h.free_builtins); n != 0 {
+		b, h.free_builtins = h.free_builtins[n-1], h.free_builtins[:n-1]
+		h.stats.Free--
+	} else {
+		b = &Builtin{}
+		h.builtins = append(h.builtins, b)
+	}
+	b.fn, b.mark = fn, 0
+	h.count, h.stats.Allocated, h.stats.Live = h.count+1, h.stats.Allocated+1, h.stats.Live+1
+	return Atom{_type: AtomType_Builtin, value: AtomValue{ref: b}}
+}
+
+// gc_needed returns true if the heap has reached its threshold.
+func (l *Interpreter) gc_needed() bool {
+	return l.heap != nil && l.heap.threshold != 0 && l.heap.count >= l.heap.threshold && l.compiling == 0
+}
+
+// gc marks every cell that is reachable from the roots, then frees the
+// cells that were not marked. it returns the number of cells freed.
+// the roots are the global environment and the expressions, environments
+// and stacks of any evaluations in progress.
+func (l *Interpreter) gc() int {
+	h := l.heap
+	if h == nil || l.compiling != 0 {
+		return 0
+	}
+	// start a new epoch so that every existing mark is stale
+	if h.epoch++; h.epoch == gc_free {
+		// the epoch wrapped, so clear the marks
+		h.pairs.each(func(p *Pair) {
+			if p.mark != gc_free {
+				p.mark = 0
+			}
+		})
+		for _, b := range h.builtins {
+			if b.mark != gc_free {
+				b.mark = 0
+			}
+		}
+		h.epoch = 1
+	}
+
+	gc_mark(l.env, h.epoch)
+	for _, root := range l.roots {
+		gc_mark(*root, h.epoch)
+	}
+	for _, root := range l.frames {
+		gc_mark_frames(*root, h.epoch)
+	}
+
+	// free unmarked allocations.
+	// they are cleared so that they don't keep other cells alive.
+	freed := 0
+	h.pairs.each(func(p *Pair) {
+		if p.mark != h.epoch && p.mark != gc_free {
+			h.pairs.release(p)
+			freed++
+		}
+	})
+	for _, b := range h.builtins {
+		if b.mark != h.epoch && b.mark != gc_free {
+			*b = Builtin{mark: gc_free}
+			h.free_builtins = append(h.free_builtins, b)
+			freed++
+		}
+	}
+	if l.weak_symbols {
+		// the symbols for special forms are always used
+		for sym := range l.forms {
+			sym.mark = h.epoch
+		}
+		for name, sym := range l.symbols {
+			if sym.mark != h.epoch {
+				delete(l.symbols, name)
+			}
+		}
+	}
+
+	h.count = 0
+	h.stats.Collections++
+	h.stats.Freed += freed
+	h.stats.Live -= freed
+	h.stats.Free += freed
+	return freed
+}
+
+// gc_mark marks a whole tree of cells as "in use".
+// cells that are not from the managed heap are marked too,
+// so that cycles through them are only followed once.
+func gc_mark(root Atom, epoch uint32) {
+	for {
+		switch root._type {
+		case AtomType_Builtin:
+			if b := root.value.builtin(); b.mark != gc_free {
+				b.mark = epoch
+			}
+			return
+		case AtomType_Condition:
+			root = root.value.condition().irritants
+		case AtomType_Continuation:
+			gc_mark_frames(root.value.continuation().frames, epoch)
+			root = root.value.continuation().stack
+		case AtomType_HashTable:
+			t := root.value.table()
+			if t.mark == epoch {
+				return
+			}
+			t.mark = epoch
+			for _, e := range t.entries {
+				gc_mark(e.key, epoch)
+				gc_mark(e.value, epoch)
+			}
+			return
+		case AtomType_Local:
+			root = symbol_of(root)
+		case AtomType_Symbol:
+			root.value.symbol().mark = epoch
+			if a := root.value.symbol().alias; a != nil {
+				gc_mark_syntax(a.syntax, epoch)
+				root = a.symbol
+				continue
+			}
+			return
+		case AtomType_Syntax:
+			gc_mark_syntax(root.value.syntax(), epoch)
+			return
+		case AtomType_Vector:
+			v := root.value.vector()
+			if v.mark == epoch {
+				return
+			}
+			v.mark = epoch
+			for _, item := range v.items {
+				gc_mark(item, epoch)
+			}
+			return
+		case AtomType_Closure, AtomType_Macro, AtomType_Pair:
+			if lambda := root.value.lambda(); lambda != nil {
+				// a closure created by the virtual machine
+				gc_mark_lambda(lambda, epoch)
+				gc_mark_env(root.value.env(), epoch)
+				return
+			} else if closure := root.value.closure(); closure != nil {
+				// a closure created by the evaluator
+				gc_mark_eval_lambda(closure.lambda, epoch)
+				root = closure.env
+				continue
+			}
+			p := root.value.pair()
+			if p.mark == epoch || p.mark == gc_free {
+				return
+			}
+			p.mark = epoch
+			gc_mark(p.car, epoch)
+			// loop instead of recursing on the cdr
+			root = p.cdr
+		default:
+			return
+		}
+	}
+}
+
+// gc_mark_syntax marks the cells used by a SYNTAX-RULES macro.
+func gc_mark_syntax(x *Syntax, epoch uint32) {
+	if x.mark == epoch {
+		return
+	}
+	x.mark = epoch
+	gc_mark(x.ellipsis, epoch)
+	for _, literal := range x.literals {
+		gc_mark(literal, epoch)
+	}
+	for _, rule := range x.rules {
+		gc_mark(rule.pattern, epoch)
+		gc_mark(rule.template, epoch)
+	}
+	gc_mark(x.env, epoch)
+}
+
+// gc_mark_eval_lambda marks the cells used by a resolved LAMBDA form.
+func gc_mark_eval_lambda(x *eval_lambda, epoch uint32) {
+	if x.mark == epoch {
+		return
+	}
+	x.mark = epoch
+	gc_mark(x.args, epoch)
+	gc_mark(x.body, epoch)
+	for _, name := range x.names {
+		gc_mark(Atom{_type: AtomType_Symbol, value: AtomValue{ref: name}}, epoch)
+	}
+	for _, inner := range x.lambdas {
+		gc_mark_eval_lambda(inner, epoch)
+	}
+	for _, source := range x.sources {
+		gc_mark(source, epoch)
+	}
+}
+
+// gc_mark_lambda marks the cells used by a LAMBDA form and its bytecode.
+func gc_mark_lambda(lambda *Lambda, epoch uint32) {
+	if lambda.mark == epoch {
+		return
+	}
+	lambda.mark = epoch
+	gc_mark(lambda.args, epoch)
+	gc_mark(lambda.body, epoch)
+	gc_mark(lambda.globals, epoch)
+	if lambda.code != nil {
+		gc_mark_code(lambda.code, epoch)
+	}
+}
+
+// gc_mark_code marks the cells used by bytecode.
+func gc_mark_code(c *code, epoch uint32) {
+	for _, value := range c.constants {
+		gc_mark(value, epoch)
+	}
+	for _, r := range c.refs {
+		gc_mark(r.name, epoch)
+	}
+	for _, lambda := range c.lambdas {
+		gc_mark_lambda(lambda, epoch)
+	}
+	gc_mark(c.globals, epoch)
+}
+
+// gc_mark_env marks the values in virtual machine environments.
+func gc_mark_env(env *vm_env, epoch uint32) {
+	for ; env != nil && env.mark != epoch; env = env.parent {
+		env.mark = epoch
+		for _, value := range env.slots {
+			gc_mark(value, epoch)
+		}
+	}
+}
+
+// gc_mark_frames marks the cells used by virtual machine frames.
+func gc_mark_frames(f *vm_frame, epoch uint32) {
+	for ; f != nil; f = f.parent {
+		gc_mark_code(f.code, epoch)
+		gc_mark_env(f.env, epoch)
+		gc_mark(f.op, epoch)
+		for _, value := range f.stack {
+			gc_mark(value, epoch)
+		}
+		for _, h := range f.handlers {
+			gc_mark_env(h.env, epoch)
+		}
+	}
+}
+
+// builtin_gc runs the garbage collector and returns the number of cells freed.
+func (l *Interpreter) builtin_gc(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if !nilp(args) {
+		return error_args("0", args)
+	}
+
+	*result = make_int(l.gc())
+	return nil
+}
+
+// builtin_heap_stats returns the statistics for the managed heap as a
+// list of (name . count) pairs.
+func (l *Interpreter) builtin_heap_stats(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if !nilp(args) {
+		return error_args("0", args)
+	}
+
+	stats := l.HeapStats()
+	*result = _nil
+	for _, stat := range []struct {
+		name  string
+		count int
+	}{
+		{"FREE", stats.Free},
+		{"LIVE", stats.Live},
+		{"FREED", stats.Freed},
+		{"ALLOCATED", stats.Allocated},
+		{"COLLECTIONS", stats.Collections},
+	} {
+		*result = l.cons(l.cons(l.intern(stat.name), make_int(stat.count)), *result)
+	}
+	return nil
+}
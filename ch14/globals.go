@@ -2,14 +2,20 @@
 
 package lisp
 
-// this file defines all the global variables used by the implementation.
-// because the implementation uses globals rather than passing state,
-// we can only have one instance running per program.
+// this file defines the global variables used by the implementation.
+// all mutable state (the symbol table and the global environment) is
+// owned by an Interpreter, so only immutable values belong here.
 
 // _nil is the NIL symbol.
 // This should be immutable, so don't change it!
 var _nil = Atom{_type: AtomType_Nil}
 
-// sym_table is a global symbol table.
-// it is a list of all existing symbols.
-var sym_table = Atom{_type: AtomType_Nil}
+// _false and _true are the booleans #f and #t.
+var _false = Atom{_type: AtomType_Boolean}
+var _true = Atom{_type: AtomType_Boolean, value: AtomValue{n: 1}}
+
+// unbound is the symbol in the slot for a local variable that is
+// defined in the body of a procedure but hasn't been defined yet.
+// it isn't interned, so programs can't refer to it.
+var unbound = &Symbol{label: []byte("#<UNBOUND>")}
+var _unbound = Atom{_type: AtomType_Symbol, value: AtomValue{ref: unbound}}
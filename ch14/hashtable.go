@@ -0,0 +1,403 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lisp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// HashTable implements data for a hash table.
+// Keys are compared with EQ?, EQV? or EQUAL?, depending on the kind of table.
+type HashTable struct {
+	kind hash_kind
+	// index maps the hash key for each key to its entry.
+	index map[any]int
+	// entries are kept in the order they were added, except that
+	// deleting an entry moves the last entry into its place.
+	entries []hash_entry
+	// mark is used by the garbage collector.
+	mark uint32
+}
+
+// hash_entry is a key and its value.
+type hash_entry struct {
+	key, value Atom
+	// hash is the key in the table's index. it is saved when the entry
+	// is added because the contents of the key may be changed later.
+	hash any
+}
+
+// hash_kind is the procedure used to compare the keys in a table.
+type hash_kind int
+
+const (
+	hash_equal hash_kind = iota
+	hash_eq
+	hash_eqv
+)
+
+// String implements the Stringer interface.
+// It returns the name of the procedure.
+func (k hash_kind) String() string {
+	switch k {
+	case hash_eq:
+		return "EQ?"
+	case hash_eqv:
+		return "EQV?"
+	}
+	return "EQUAL?"
+}
+
+// nil_key and number_key are the hash keys for NIL and for numbers
+// that don't fit in a Go type that can be used as a map key.
+type nil_key struct{}
+type number_key string
+
+// float_key is the hash key for a float. it is the bits of the float,
+// so 0.0 and -0.0 are different keys and NaN is the same key as itself.
+type float_key uint64
+
+// make_float_key returns the key for a float.
+// every NaN has the same key.
+func make_float_key(f float64) float_key {
+	if math.IsNaN(f) {
+		f = math.NaN()
+	}
+	return float_key(math.Float64bits(f))
+}
+
+// equal_key is the hash key for a pair, string or vector in an
+// EQUAL? table. it is an encoding of the structure of the key.
+type equal_key string
+
+// make_hash_table returns an Atom on the stack.
+func make_hash_table(kind hash_kind) Atom {
+	return Atom{
+		_type: AtomType_HashTable,
+		value: AtomValue{
+			ref: &HashTable{
+				kind:  kind,
+				index: make(map[any]int),
+			},
+		},
+	}
+}
+
+// hash_key returns the key for an atom in the table's index.
+// atoms have the same key if they are equal by the table's procedure.
+// a pair or vector that contains itself can't be written as an EQUAL?
+// key, so it is keyed as if by EQ?.
+func (t *HashTable) hash_key(atom Atom) any {
+	if t.kind == hash_equal {
+		switch atom._type {
+		case AtomType_Pair, AtomType_String, AtomType_Vector:
+			sb := &strings.Builder{}
+			if write_key(sb, atom, make(map[any]bool)) {
+				return equal_key(sb.String())
+			}
+		}
+	}
+	return eq_key(atom)
+}
+
+// eq_key returns a key for an atom that is the same for atoms that
+// are EQ?. numbers and characters are keyed by value and anything else
+// by its pointer.
+func eq_key(atom Atom) any {
+	switch atom._type {
+	case AtomType_Nil:
+		return nil_key{}
+	case AtomType_Bignum:
+		return number_key("b" + atom.value.bignum().String())
+	case AtomType_Boolean:
+		return atom.value.boolean()
+	case AtomType_Builtin:
+		return atom.value.builtin()
+	case AtomType_Char:
+		return atom.value.char()
+	case AtomType_Closure, AtomType_Macro, AtomType_Pair:
+		return atom.value.ref
+	case AtomType_Condition:
+		return atom.value.condition()
+	case AtomType_Continuation:
+		return atom.value.continuation()
+	case AtomType_Float:
+		return make_float_key(atom.value.float())
+	case AtomType_HashTable:
+		return atom.value.table()
+	case AtomType_Integer:
+		return atom.value.integer()
+	case AtomType_Rational:
+		return number_key("r" + atom.value.rational().String())
+	case AtomType_String:
+		return atom.value.str()
+	case AtomType_Symbol:
+		return atom.value.symbol()
+	case AtomType_Syntax:
+		return atom.value.syntax()
+	case AtomType_Vector:
+		return atom.value.vector()
+	}
+	panic(fmt.Sprintf("assert(_type != %d)", atom._type))
+}
+
+// write_key writes the encoding of an atom for an EQUAL? key.
+// pairs, strings and vectors are written by value and anything
+// else by its EQ? key.
+// path holds the pairs and vectors that contain the atom. if the atom
+// is one of them, the structure is cyclic and write_key returns false.
+func write_key(sb *strings.Builder, atom Atom, path map[any]bool) bool {
+	switch atom._type {
+	case AtomType_Pair:
+		sb.WriteByte('(')
+		var cells []any
+		for ; atom._type == AtomType_Pair; atom = cdr(atom) {
+			if path[atom.value.pair()] {
+				return false
+			}
+			path[atom.value.pair()] = true
+			cells = append(cells, atom.value.pair())
+			if !write_key(sb, car(atom), path) {
+				return false
+			}
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(". ")
+		if !write_key(sb, atom, path) {
+			return false
+		}
+		sb.WriteByte(')')
+		for _, cell := range cells {
+			delete(path, cell)
+		}
+	case AtomType_String:
+		sb.WriteString(strconv.Quote(atom.value.str().text))
+	case AtomType_Vector:
+		if path[atom.value.vector()] {
+			return false
+		}
+		path[atom.value.vector()] = true
+		sb.WriteString("#(")
+		for _, item := range atom.value.vector().items {
+			if !write_key(sb, item, path) {
+				return false
+			}
+			sb.WriteByte(' ')
+		}
+		sb.WriteByte(')')
+		delete(path, atom.value.vector())
+	default:
+		switch key := eq_key(atom).(type) {
+		case nil_key, bool, float_key, int, number_key, rune:
+			fmt.Fprintf(sb, "%T:%v", key, key)
+		default:
+			fmt.Fprintf(sb, "%T:%p", key, key)
+		}
+	}
+	return true
+}
+
+// get returns the value for a key and true if it is in the table.
+func (t *HashTable) get(key Atom) (Atom, bool) {
+	if n, ok := t.index[t.hash_key(key)]; ok {
+		return t.entries[n].value, true
+	}
+	return _nil, false
+}
+
+// set adds a key to the table or updates its value.
+func (t *HashTable) set(key, value Atom) {
+	k := t.hash_key(key)
+	if n, ok := t.index[k]; ok {
+		t.entries[n].value = value
+		return
+	}
+	t.index[k] = len(t.entries)
+	t.entries = append(t.entries, hash_entry{key: key, value: value, hash: k})
+}
+
+// delete removes a key from the table, if it is there.
+func (t *HashTable) delete(key Atom) {
+	k := t.hash_key(key)
+	n, ok := t.index[k]
+	if !ok {
+		return
+	}
+	delete(t.index, k)
+	// move the last entry into the hole. its saved hash key is used
+	// because the key may have been changed since it was added.
+	last := len(t.entries) - 1
+	if n != last {
+		t.entries[n] = t.entries[last]
+		t.index[t.entries[n].hash] = n
+	}
+	t.entries[last] = hash_entry{}
+	t.entries = t.entries[:last]
+}
+
+// write_hash_table writes a table as #<HASH-TABLE kind count>.
+func write_hash_table(t *HashTable) []byte {
+	return []byte(fmt.Sprintf("#<HASH-TABLE %s %d>", t.kind, len(t.entries)))
+}
+
+// builtin_make_hash_table returns an empty hash table.
+// the argument is the procedure used to compare keys, which must be
+// EQ?, EQV? or EQUAL?. if it isn't given, EQUAL? is used.
+// note that the result may not be updated if we find errors.
+func (l *Interpreter) builtin_make_hash_table(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if !nilp(args) && !nilp(cdr(args)) {
+		return error_args("0 or 1", args)
+	} else if nilp(args) {
+		*result = make_hash_table(hash_equal)
+		return nil
+	}
+
+	for _, kind := range []hash_kind{hash_eq, hash_eqv, hash_equal} {
+		var proc Atom
+		if err := l.env_get(l.env, l.intern(kind.String()), &proc); err == nil && eqp(proc, car(args)) {
+			*result = make_hash_table(kind)
+			return nil
+		}
+	}
+	return error_type("EQ?, EQV? or EQUAL?", car(args))
+}
+
+// builtin_hash_tablep returns #t if the argument is a hash table.
+// note that the result may not be updated if we find errors.
+func builtin_hash_tablep(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	}
+
+	*result = make_boolean(car(args)._type == AtomType_HashTable)
+	return nil
+}
+
+// builtin_hash_table_count returns the number of keys in a hash table.
+// note that the result may not be updated if we find errors.
+func builtin_hash_table_count(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	} else if car(args)._type != AtomType_HashTable {
+		return error_type("hash-table", car(args))
+	}
+
+	*result = make_int(len(car(args).value.table().entries))
+	return nil
+}
+
+// builtin_hash_table_delete removes a key from a hash table.
+// it returns NIL.
+// note that the result may not be updated if we find errors.
+func builtin_hash_table_delete(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
+		return error_args("2", args)
+	} else if car(args)._type != AtomType_HashTable {
+		return error_type("hash-table", car(args))
+	}
+
+	car(args).value.table().delete(car(cdr(args)))
+	*result = _nil
+	return nil
+}
+
+// builtin_hash_table_keys returns a list of the keys in a hash table.
+// its cells are allocated from the managed heap if it is enabled.
+// note that the result may not be updated if we find errors.
+func (l *Interpreter) builtin_hash_table_keys(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	} else if car(args)._type != AtomType_HashTable {
+		return error_type("hash-table", car(args))
+	}
+
+	list, entries := _nil, car(args).value.table().entries
+	for n := len(entries) - 1; n >= 0; n-- {
+		list = l.cons(entries[n].key, list)
+	}
+	*result = list
+	return nil
+}
+
+// builtin_hash_table_ref returns the value for a key in a hash table.
+// if the key isn't in the table, it returns the default value or,
+// if there isn't one, an error.
+// note that the result may not be updated if we find errors.
+func builtin_hash_table_ref(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || nilp(cdr(args)) || !(nilp(cdr(cdr(args))) || nilp(cdr(cdr(cdr(args))))) {
+		return error_args("2 or 3", args)
+	} else if car(args)._type != AtomType_HashTable {
+		return error_type("hash-table", car(args))
+	}
+
+	key := car(cdr(args))
+	if value, ok := car(args).value.table().get(key); ok {
+		*result = value
+	} else if rest := cdr(cdr(args)); !nilp(rest) {
+		*result = car(rest)
+	} else {
+		return error_value(Error_Unbound, key)
+	}
+	return nil
+}
+
+// builtin_hash_table_walk calls a procedure with each key in a hash table
+// and its value. it returns NIL.
+// the entries are copied before the first call, so the procedure may
+// change the table.
+// note that the result may not be updated if we find errors.
+func (l *Interpreter) builtin_hash_table_walk(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
+		return error_args("2", args)
+	} else if car(args)._type != AtomType_HashTable {
+		return error_type("hash-table", car(args))
+	}
+
+	// the copy must survive garbage collection while the procedure runs
+	list, entries := _nil, car(args).value.table().entries
+	l.roots = append(l.roots, &list)
+	defer func() {
+		l.roots = l.roots[:len(l.roots)-1]
+	}()
+	for n := len(entries) - 1; n >= 0; n-- {
+		list = l.cons(l.list_of(entries[n].key, entries[n].value), list)
+	}
+
+	// (APPLY 'proc '(key value))
+	proc, quote := car(cdr(args)), l.intern("QUOTE")
+	for ; !nilp(list); list = cdr(list) {
+		var ignored Atom
+		expr := l.list_of(l.intern("APPLY"), l.list_of(quote, proc), l.list_of(quote, car(list)))
+		if err := l.eval_expr(expr, l.env, &ignored); err != nil {
+			return err
+		}
+	}
+	*result = _nil
+	return nil
+}
+
+// builtin_hash_table_set adds a key to a hash table or updates its value.
+// it returns NIL.
+// note that the result may not be updated if we find errors.
+func builtin_hash_table_set(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || nilp(cdr(args)) || nilp(cdr(cdr(args))) || !nilp(cdr(cdr(cdr(args)))) {
+		return error_args("3", args)
+	} else if car(args)._type != AtomType_HashTable {
+		return error_type("hash-table", car(args))
+	}
+
+	car(args).value.table().set(car(cdr(args)), car(cdr(cdr(args))))
+	*result = _nil
+	return nil
+}
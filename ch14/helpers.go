@@ -5,25 +5,25 @@ package lisp
 // car returns the first item from a list.
 // It will panic if p is not a Pair
 func car(p Atom) Atom {
-	return p.value.pair.car
+	return p.value.pair().car
 }
 
 // cdr returns the remainder of a list.
 // It will panic if p is not a Pair
 func cdr(p Atom) Atom {
-	return p.value.pair.cdr
+	return p.value.pair().cdr
 }
 
 // list_copy returns a shallow copy of a list.
 // todo: define "shallow copy" and why we would create one.
-func list_copy(list Atom) Atom {
+func (l *Interpreter) list_copy(list Atom) Atom {
 	if nilp(list) {
 		return _nil
 	}
-	a := cons(car(list), _nil)
+	a := l.cons(car(list), _nil)
 	p := a
 	for list = cdr(list); !nilp(list); list = cdr(list) {
-		setcdr(p, cons(car(list), _nil))
+		setcdr(p, l.cons(car(list), _nil))
 		p = cdr(p)
 	}
 	return a
@@ -39,6 +39,16 @@ func list_get(list Atom, k int) Atom {
 	return car(list)
 }
 
+// list_of returns a list of the items.
+// its cells are allocated from the managed heap if it is enabled.
+func (l *Interpreter) list_of(items ...Atom) Atom {
+	list := _nil
+	for n := len(items) - 1; n >= 0; n-- {
+		list = l.cons(items[n], list)
+	}
+	return list
+}
+
 // list_reverse reverses a list in place.
 func list_reverse(list *Atom) {
 	tail := _nil
@@ -79,11 +89,11 @@ func nilp(atom Atom) bool {
 // setcar is a helper function to set the car of a pair.
 // panics if p is not a pair.
 func setcar(p, a Atom) {
-	p.value.pair.car = a
+	p.value.pair().car = a
 }
 
 // setcdr is a helper function to set the cdr of a pair.
 // panics if p is not a pair.
 func setcdr(p, a Atom) {
-	p.value.pair.cdr = a
+	p.value.pair().cdr = a
 }
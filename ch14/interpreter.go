@@ -0,0 +1,186 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lisp
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// library is the standard library, written in Lisp.
+//
+//go:embed library.lisp
+var library []byte
+
+// Interpreter holds the state for one instance of the interpreter.
+// Each Interpreter owns its own symbol table and global environment,
+// so several can run side by side in a single program without seeing
+// each other's symbols or definitions.
+//
+// The zero value has an empty symbol table and no global environment.
+// Use NewInterpreter to create one with the default builtins.
+type Interpreter struct {
+	// symbols is the symbol table. it maps the name of every symbol
+	// created by this interpreter to the symbol.
+	symbols map[string]*Symbol
+	// case_sensitive is true if the case of symbols is preserved.
+	case_sensitive bool
+	// forms maps the symbols that name special forms to the forms.
+	forms map[*Symbol]form
+	// nil_false is true if NIL is false as well as #f.
+	nil_false bool
+	// weak_symbols is true if the garbage collector removes symbols
+	// that aren't used any more from the symbol table.
+	weak_symbols bool
+	// env is the global environment.
+	env Atom
+	// globals indexes the bindings in the global environment by symbol.
+	globals map[*Symbol]Atom
+	// source is the text being read, if known.
+	// the reader uses it to record the position of each expression.
+	source *source
+	// keep_comments is true if the reader records the comments
+	// that it skips.
+	keep_comments bool
+	// comments are the comments recorded by the reader.
+	comments []Comment
+	// heap is the managed heap, or nil if cells are allocated by Go.
+	heap *heap
+	// roots points to the expressions, environments and stacks of the
+	// evaluations in progress. the garbage collector marks from them.
+	roots []*Atom
+	// bytecode is true if expressions are compiled and run on the
+	// virtual machine instead of being evaluated by walking them.
+	bytecode bool
+	// frames points to the frames of the virtual machine runs in
+	// progress. the garbage collector marks from them too.
+	frames []**vm_frame
+	// compiling is the number of compilations in progress.
+	// the garbage collector doesn't run while it is not zero.
+	compiling int
+	// gensyms is the number of symbols created by GENSYM.
+	gensyms int
+}
+
+// Option configures an Interpreter.
+type Option func(*Interpreter)
+
+// NewInterpreter returns a new interpreter with a global environment
+// that contains the default native functions.
+func NewInterpreter(opts ...Option) *Interpreter {
+	l := &Interpreter{symbols: make(map[string]*Symbol)}
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.env = l.env_create_default()
+	return l
+}
+
+// Define binds a value to a name in the global environment.
+// The name is interned in this interpreter's symbol table.
+func (l *Interpreter) Define(name string, value Atom) {
+	_ = l.env_set(l.env, l.make_sym([]byte(name)), value)
+}
+
+// DefineBuiltin binds a native Go function to a name in the global environment.
+func (l *Interpreter) DefineBuiltin(name string, fn Native) {
+	l.Define(name, l.make_builtin(fn))
+}
+
+// Eval evaluates an expression in the global environment and returns the result.
+func (l *Interpreter) Eval(expr Atom) (Atom, error) {
+	var result Atom
+	if err := l.eval_expr(expr, l.env, &result); err != nil {
+		return _nil, err
+	}
+	return result, nil
+}
+
+// EvalString reads and evaluates every expression in the input.
+// It returns the result of the last expression, or NIL if the input
+// does not contain any expressions.
+// Evaluation stops at the first error.
+func (l *Interpreter) EvalString(input string) (Atom, error) {
+	result, rest := _nil, []byte(input)
+	for {
+		expr, remainder, err := l.Read(rest)
+		if errors.Is(err, Error_EndOfInput) {
+			return result, nil
+		} else if err != nil {
+			return _nil, err
+		}
+		if result, err = l.Eval(expr); err != nil {
+			return _nil, err
+		}
+		rest = remainder
+	}
+}
+
+// LoadFile reads and evaluates every expression in a file.
+// Loading stops at the first error, which includes the expression
+// that failed.
+func (l *Interpreter) LoadFile(path string) error {
+	input, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return l.load(path, input)
+}
+
+// LoadLibrary evaluates the standard library (library.lisp), which is
+// compiled into the package.
+func (l *Interpreter) LoadLibrary() error {
+	return l.load("library.lisp", library)
+}
+
+// load reads and evaluates every expression in the input.
+// name is used to identify the input in error messages.
+func (l *Interpreter) load(name string, input []byte) error {
+	saved := l.source
+	l.source = new_source(name, input)
+	defer func() {
+		l.source = saved
+	}()
+
+	for rest := input; ; {
+		expr, remainder, err := l.Read(rest)
+		if errors.Is(err, Error_EndOfInput) {
+			return nil
+		} else if err != nil {
+			return located(name, err)
+		}
+		if _, err = l.Eval(expr); err != nil {
+			return fmt.Errorf("%w in expression: %s", located(name, err), expr.String())
+		}
+		rest = remainder
+	}
+}
+
+// located returns err prefixed with name if it doesn't have a position.
+func located(name string, err error) error {
+	var e *LispError
+	if errors.As(err, &e) && e.Pos != nil {
+		return err
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+// Read reads the next expression from the input.
+// It returns the expression and the remainder of the input.
+// At end of input, it returns NIL and Error_EndOfInput.
+func (l *Interpreter) Read(input []byte) (expr Atom, remainder []byte, err error) {
+	if l.source == nil {
+		l.source = new_source("", input)
+	} else if _, ok := l.source.offset(input); !ok {
+		// input is not part of the text we were reading,
+		// so start tracking positions from the beginning of it.
+		l.source = new_source("", input)
+	}
+	expr = _nil
+	if remainder, err = l.read_expr(input, &expr); err != nil {
+		return _nil, nil, err
+	}
+	return expr, remainder, nil
+}
@@ -4,6 +4,7 @@ package lisp
 
 import (
 	"bytes"
+	"unicode/utf8"
 )
 
 var (
@@ -15,9 +16,18 @@ var (
 	// delimiters are characters that are not allowed in a symbol.
 	// at the minimum, this must include all whitespace and
 	// reserved characters.
-	delimiters = []byte{'(', ')', ' ', '\t', '\r', '\n'}
+	delimiters = []byte{'(', ')', '"', ';', ' ', '\t', '\r', '\n'}
 )
 
+// Comment is a comment that the reader skipped.
+type Comment struct {
+	// Pos is the position of the start of the comment, if known.
+	Pos *Position
+	// Text is the comment, including the characters that start and
+	// end it. the text of a datum comment includes the datum.
+	Text string
+}
+
 // lex extracts the next token from the input after skipping
 // comments and leading whitespace. on end of input, it
 // returns nil for both the token and the remainder.
@@ -30,6 +40,27 @@ func lex(input []byte) (token []byte, remainder []byte) {
 		return nil, nil
 	}
 
+	// an unterminated block comment isn't skipped, so return the rest
+	// of the input and let the reader report the error.
+	if bytes.HasPrefix(input, []byte("#|")) {
+		return input, nil
+	}
+
+	// a character is #\ followed by any character, which may be a
+	// delimiter, and then by the rest of its name, if it has one.
+	if bytes.HasPrefix(input, []byte{'#', '\\'}) && len(input) > 2 {
+		_, size := utf8.DecodeRune(input[2:])
+		_, remainder = runto(input[2+size:], delimiters)
+		token = input[:len(input)-len(remainder)]
+		return token, remainder
+	}
+
+	// a vector starts with "#("
+	if bytes.HasPrefix(input, []byte("#(")) {
+		token, remainder = input[:2], input[2:]
+		return token, remainder
+	}
+
 	// check for prefix characters
 	if bytes.IndexByte(prefix, input[0]) >= 0 {
 		token, remainder = input[:1], input[1:]
@@ -41,6 +72,20 @@ func lex(input []byte) (token []byte, remainder []byte) {
 			token, remainder = input[:1], input[1:]
 		}
 		return token, remainder
+	} else if input[0] == '"' {
+		// a string runs to the closing quote.
+		// skip escaped characters so that \" doesn't end the string.
+		for n := 1; n < len(input); n++ {
+			if input[n] == '\\' {
+				n++
+			} else if input[n] == '"' {
+				token, remainder = input[:n+1], input[n+1:]
+				return token, remainder
+			}
+		}
+		// unterminated string, so return the rest of the input and
+		// let the reader report the error.
+		return input, nil
 	}
 
 	// if we get here, the token is a symbol.
@@ -49,6 +94,46 @@ func lex(input []byte) (token []byte, remainder []byte) {
 	return token, remainder
 }
 
+// WithComments returns an option that keeps the comments that the
+// reader skips. Use Comments to get them.
+func WithComments() Option {
+	return func(l *Interpreter) {
+		l.keep_comments = true
+	}
+}
+
+// Comments returns the comments that the reader has skipped, in the
+// order that they were read. It returns nil unless the interpreter was
+// created with WithComments.
+func (l *Interpreter) Comments() []Comment {
+	return l.comments
+}
+
+// lex extracts the next token from the input, like the lex function.
+// it records the comments that it skips if the interpreter keeps them.
+func (l *Interpreter) lex(input []byte) (token []byte, remainder []byte) {
+	if l.keep_comments {
+		input = skip_trivia(input, l.keep_comment)
+	}
+	return lex(input)
+}
+
+// keep_comment records a comment that the reader skipped.
+// the reader may look at the same input more than once, so comments
+// are only recorded the first time they are skipped. comments that
+// aren't part of the source text are not recorded.
+func (l *Interpreter) keep_comment(comment []byte) {
+	if l.source == nil {
+		return
+	}
+	offset, ok := l.source.offset(comment)
+	if !ok || offset < l.source.trivia {
+		return
+	}
+	l.source.trivia = offset + len(comment)
+	l.comments = append(l.comments, Comment{Pos: l.position(comment), Text: string(comment)})
+}
+
 // runof splits the input in two. the first part is the prefix from input that
 // includes delimiters. the second is the remainder of the input.
 func runof(input, delim []byte) ([]byte, []byte) {
@@ -75,8 +160,86 @@ func runto(input, delim []byte) ([]byte, []byte) {
 	return input, nil
 }
 
-// skipws skips whitespace characters.
+// skipws skips whitespace characters and comments.
 func skipws(input []byte) []byte {
-	_, input = runof(input, whitespace)
-	return input
+	return skip_trivia(input, nil)
+}
+
+// skip_trivia skips whitespace characters and comments. if keep is not
+// nil, it is called with the text of each comment that is skipped.
+//
+// there are three kinds of comments:
+//   - a line comment runs from a ';' to the end of the line.
+//   - a block comment runs from "#|" to the matching "|#". block
+//     comments may be nested.
+//   - a datum comment is "#;" followed by an expression.
+//
+// an unterminated block comment is not skipped.
+func skip_trivia(input []byte, keep func(comment []byte)) []byte {
+	for {
+		_, input = runof(input, whitespace)
+		var comment []byte
+		if len(input) == 0 {
+			return input
+		} else if input[0] == ';' {
+			comment, input = runto(input, []byte{'\n'})
+		} else if bytes.HasPrefix(input, []byte("#|")) {
+			n := block_comment(input)
+			if n == -1 {
+				return input
+			}
+			comment, input = input[:n], input[n:]
+		} else if bytes.HasPrefix(input, []byte("#;")) {
+			rest := skip_datum(input[2:])
+			comment, input = input[:len(input)-len(rest)], rest
+		} else {
+			return input
+		}
+		if keep != nil {
+			keep(comment)
+		}
+	}
+}
+
+// block_comment returns the length of the block comment at the start
+// of the input, or -1 if it is not terminated.
+func block_comment(input []byte) int {
+	depth := 0
+	for n := 0; n+1 < len(input); n++ {
+		if input[n] == '#' && input[n+1] == '|' {
+			depth, n = depth+1, n+1
+		} else if input[n] == '|' && input[n+1] == '#' {
+			if depth, n = depth-1, n+1; depth == 0 {
+				return n + 1
+			}
+		}
+	}
+	return -1
+}
+
+// skip_datum skips the next expression in the input and returns the
+// remainder. it doesn't check that the expression is valid, but it does
+// stop at a close paren that doesn't belong to the expression.
+func skip_datum(input []byte) []byte {
+	token, rest := lex(input)
+	if token == nil || token[0] == ')' {
+		// there is no expression to skip
+		return input
+	} else if token[0] == '\'' || token[0] == '`' || token[0] == ',' {
+		// skip the quoted expression too
+		return skip_datum(rest)
+	} else if token[0] != '(' {
+		return rest
+	}
+	for depth := 1; depth != 0; {
+		if token, rest = lex(rest); token == nil {
+			// unterminated list
+			return rest
+		} else if token[0] == '(' {
+			depth++
+		} else if token[0] == ')' {
+			depth--
+		}
+	}
+	return rest
 }
@@ -1,8 +1,14 @@
+;;; library.lisp - the standard library, loaded by LoadLibrary.
+
 (define (abs x)
   (if (< x 0)
      (* -1 x)
      x))
 
+(define (not x) (eq? x #f))
+
+(define (null? x) (eq? x nil))
+
 (define (reverse list)
   (foldl (lambda (a x) (cons x a)) nil list))
 
@@ -14,14 +20,14 @@
 (define (cddr x) (cdr (cdr x)))
 
 (define (foldl proc init list)
-  (if list
+  (if (pair? list)
       (foldl proc
              (proc init (car list))
              (cdr list))
       init))
 
 (define (foldr proc init list)
-  (if list
+  (if (pair? list)
       (proc (car list)
             (foldr proc init (cdr list)))
       init))
@@ -35,28 +41,21 @@
          list))
 
 (define (map proc . arg-lists)
-  (if (car arg-lists)
+  (if (pair? (car arg-lists))
       (cons (apply proc (unary-map car arg-lists))
             (apply map (cons proc
                              (unary-map cdr arg-lists))))
       nil))
 
-(defmacro (quasiquote x)
-  (if (pair? x)
-      (if (eq? (car x) 'unquote)
-          (cadr x)
-          (if (eq? (if (pair? (car x)) (caar x) nil) 'unquote-splicing)
-              (list 'append
-                    (cadr (car x))
-                    (list 'quasiquote (cdr x)))
-              (list 'cons
-                    (list 'quasiquote (car x))
-                    (list 'quasiquote (cdr x)))))
-      (list 'quote x)))
-
-(defmacro (let defs . body)
-  `((lambda ,(map car defs) ,@body)
-    ,@(map cadr defs)))
+;; the names in the templates refer to the library's definitions,
+;; even where the caller has rebound them.
+(define-syntax quasiquote
+  (syntax-rules (unquote unquote-splicing)
+    ((_ (unquote x)) x)
+    ((_ ((unquote-splicing x) . rest)) (append x (quasiquote rest)))
+    ((_ (a . rest)) (cons (quasiquote a) (quasiquote rest)))
+    ((_ #(x ...)) (list->vector (quasiquote (x ...))))
+    ((_ x) (quote x))))
 
 (define +
   (let ((old+ +))
@@ -0,0 +1,124 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lisp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// these builtins help with writing macros. GENSYM returns symbols that
+// a DEFMACRO can bind without capturing the caller's variables, and
+// MACROEXPAND-1 and MACROEXPAND return the expansion of a form without
+// evaluating it.
+//
+// the builtins aren't passed the caller's environment, so only macros
+// that are bound in the global environment are expanded.
+
+// builtin_gensym returns a new uninterned symbol. its name is the prefix,
+// which defaults to G, followed by a number that is different for each
+// symbol, so that the symbols print distinctly. the prefix may be a
+// string or a symbol.
+// note that the result may not be updated if we find errors.
+func (l *Interpreter) builtin_gensym(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if !nilp(args) && !nilp(cdr(args)) {
+		return error_args("0 or 1", args)
+	}
+
+	prefix := string(l.intern("G").value.symbol().label)
+	if !nilp(args) {
+		switch arg := car(args); arg._type {
+		case AtomType_String:
+			// a string is converted the same way as a symbol's name
+			if prefix = arg.value.str().text; !l.case_sensitive {
+				prefix = strings.ToUpper(prefix)
+			}
+		case AtomType_Symbol:
+			prefix = string(arg.value.symbol().label)
+		default:
+			return error_type("string or symbol", arg)
+		}
+	}
+
+	l.gensyms++
+	*result = Atom{
+		_type: AtomType_Symbol,
+		value: AtomValue{
+			ref: &Symbol{
+				label: []byte(fmt.Sprintf("%s%d", prefix, l.gensyms)),
+			},
+		},
+	}
+	return nil
+}
+
+// macroexpand_1 returns the expansion of a form and true if the form uses
+// a macro. otherwise, it returns the form and false.
+// a macro defined with DEFMACRO is applied to the form's arguments, just
+// as it is when the form is evaluated.
+func (l *Interpreter) macroexpand_1(form Atom) (Atom, bool, error) {
+	if form._type != AtomType_Pair || car(form)._type != AtomType_Symbol {
+		return form, false, nil
+	}
+	var macro Atom
+	if err := l.env_get(l.env, car(form), &macro); err != nil {
+		return form, false, nil
+	}
+	switch macro._type {
+	case AtomType_Macro:
+		// (APPLY 'closure 'args)
+		var expansion Atom
+		macro._type = AtomType_Closure
+		quote := l.intern("QUOTE")
+		expr := l.list_of(l.intern("APPLY"), l.list_of(quote, macro), l.list_of(quote, cdr(form)))
+		if err := l.eval_expr(expr, l.env, &expansion); err != nil {
+			return _nil, false, err
+		}
+		return expansion, true, nil
+	case AtomType_Syntax:
+		expansion, err := l.expand_syntax(macro.value.syntax(), cdr(form), l.env, nil)
+		if err != nil {
+			return _nil, false, err
+		}
+		return expansion, true, nil
+	}
+	return form, false, nil
+}
+
+// builtin_macroexpand_1 returns the expansion of a form that uses a macro.
+// the expansion isn't expanded again. any other form is returned as it is.
+// note that the result may not be updated if we find errors.
+func (l *Interpreter) builtin_macroexpand_1(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	}
+
+	expansion, _, err := l.macroexpand_1(car(args))
+	if err != nil {
+		return err
+	}
+	*result = expansion
+	return nil
+}
+
+// builtin_macroexpand expands a form until it doesn't use a macro.
+// only the form itself is expanded, not the forms inside it.
+// note that the result may not be updated if we find errors.
+func (l *Interpreter) builtin_macroexpand(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	}
+
+	form := car(args)
+	for expanded := true; expanded; {
+		var err error
+		if form, expanded, err = l.macroexpand_1(form); err != nil {
+			return err
+		}
+	}
+	*result = form
+	return nil
+}
@@ -0,0 +1,748 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lisp
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// functions in this file implement the numeric tower.
+// integers, bignums, and rationals are exact and floats are inexact.
+// when an operation mixes the two, the exact number is converted to a
+// float and the result is inexact.
+//
+// integers are promoted to bignums when a result overflows an int, and
+// bignums are demoted back to integers when a result fits in one.
+// likewise, rationals with a denominator of 1 are demoted to integers.
+
+// make_bignum returns an Atom on the stack.
+// if the value fits in an int, the atom is an integer instead.
+func make_bignum(x *big.Int) Atom {
+	if x.IsInt64() {
+		if val := x.Int64(); int64(int(val)) == val {
+			return make_int(int(val))
+		}
+	}
+	return Atom{
+		_type: AtomType_Bignum,
+		value: AtomValue{
+			ref: x,
+		},
+	}
+}
+
+// make_rational returns an Atom on the stack.
+// if the value is a whole number, the atom is an integer instead.
+func make_rational(x *big.Rat) Atom {
+	if x.IsInt() {
+		return make_bignum(new(big.Int).Set(x.Num()))
+	}
+	return Atom{
+		_type: AtomType_Rational,
+		value: AtomValue{
+			ref: x,
+		},
+	}
+}
+
+// make_float returns an Atom on the stack.
+func make_float(x float64) Atom {
+	return Atom{
+		_type: AtomType_Float,
+		value: AtomValue{
+			n: int64(math.Float64bits(x)),
+		},
+	}
+}
+
+// numberp is a predicate function. It returns true if the atom is a number.
+func numberp(a Atom) bool {
+	return exactp(a) || a._type == AtomType_Float
+}
+
+// exactp is a predicate function. It returns true if the atom is an exact number.
+func exactp(a Atom) bool {
+	return exact_integerp(a) || a._type == AtomType_Rational
+}
+
+// exact_integerp is a predicate function. It returns true if the atom is
+// an integer or a bignum.
+func exact_integerp(a Atom) bool {
+	return a._type == AtomType_Integer || a._type == AtomType_Bignum
+}
+
+// to_bignum returns the value of an exact integer as a bignum.
+// the caller must not change the value returned.
+// panics if the atom is not an exact integer.
+func to_bignum(a Atom) *big.Int {
+	switch a._type {
+	case AtomType_Bignum:
+		return a.value.bignum()
+	case AtomType_Integer:
+		return big.NewInt(int64(a.value.integer()))
+	}
+	panic("assert(exact_integerp(a))")
+}
+
+// to_rational returns the value of an exact number as a rational.
+// the caller must not change the value returned.
+// panics if the atom is not an exact number.
+func to_rational(a Atom) *big.Rat {
+	switch a._type {
+	case AtomType_Bignum:
+		return new(big.Rat).SetInt(a.value.bignum())
+	case AtomType_Integer:
+		return big.NewRat(int64(a.value.integer()), 1)
+	case AtomType_Rational:
+		return a.value.rational()
+	}
+	panic("assert(exactp(a))")
+}
+
+// to_float returns the value of a number as a float.
+// panics if the atom is not a number.
+func to_float(a Atom) float64 {
+	switch a._type {
+	case AtomType_Bignum:
+		val, _ := new(big.Float).SetInt(a.value.bignum()).Float64()
+		return val
+	case AtomType_Float:
+		return a.value.float()
+	case AtomType_Integer:
+		return float64(a.value.integer())
+	case AtomType_Rational:
+		val, _ := a.value.rational().Float64()
+		return val
+	}
+	panic("assert(numberp(a))")
+}
+
+// write_float returns the printed representation of a float.
+// the output always includes a decimal point or an exponent so that
+// the reader will not confuse it with an integer.
+func write_float(x float64) []byte {
+	switch {
+	case math.IsInf(x, 1):
+		return []byte("+inf.0")
+	case math.IsInf(x, -1):
+		return []byte("-inf.0")
+	case math.IsNaN(x):
+		return []byte("+nan.0")
+	}
+	b := strconv.AppendFloat(nil, x, 'g', -1, 64)
+	for _, ch := range b {
+		if ch == '.' || ch == 'e' {
+			return b
+		}
+	}
+	return append(b, '.', '0')
+}
+
+// read_float reads a float from the input.
+// it returns false if the input is not a float.
+// we don't rely on strconv alone because it accepts words like "inf" and
+// "nan" that must be read as symbols.
+func read_float(input []byte, result *Atom) bool {
+	switch string(input) {
+	case "+inf.0":
+		*result = make_float(math.Inf(1))
+		return true
+	case "-inf.0":
+		*result = make_float(math.Inf(-1))
+		return true
+	case "+nan.0", "-nan.0":
+		*result = make_float(math.NaN())
+		return true
+	}
+
+	// verify that the input looks like [+-]digits[.digits][e[+-]digits]
+	// with at least one digit in the mantissa.
+	n, digits := 0, 0
+	if n < len(input) && (input[n] == '+' || input[n] == '-') {
+		n++
+	}
+	for ; n < len(input) && '0' <= input[n] && input[n] <= '9'; n++ {
+		digits++
+	}
+	if n < len(input) && input[n] == '.' {
+		for n++; n < len(input) && '0' <= input[n] && input[n] <= '9'; n++ {
+			digits++
+		}
+	}
+	if digits == 0 {
+		return false
+	}
+	if n < len(input) && (input[n] == 'e' || input[n] == 'E') {
+		n++
+		if n < len(input) && (input[n] == '+' || input[n] == '-') {
+			n++
+		}
+		start := n
+		for ; n < len(input) && '0' <= input[n] && input[n] <= '9'; n++ {
+		}
+		if n == start {
+			return false
+		}
+	}
+	if n != len(input) {
+		return false
+	}
+
+	val, err := strconv.ParseFloat(string(input), 64)
+	if err != nil {
+		return false
+	}
+	*result = make_float(val)
+	return true
+}
+
+// read_bignum reads an integer that is too large for strconv.Atoi.
+// it returns false if the input is not an integer.
+func read_bignum(input []byte, result *Atom) bool {
+	// verify that the input looks like [+-]digits
+	n := 0
+	if n < len(input) && (input[n] == '+' || input[n] == '-') {
+		n++
+	}
+	if n == len(input) {
+		return false
+	}
+	for ; n < len(input); n++ {
+		if !('0' <= input[n] && input[n] <= '9') {
+			return false
+		}
+	}
+
+	val, ok := new(big.Int).SetString(string(input), 10)
+	if !ok {
+		return false
+	}
+	*result = make_bignum(val)
+	return true
+}
+
+// read_rational reads a rational in the form n/d from the input.
+// it returns false if the input is not a rational or if the
+// denominator is zero.
+func read_rational(input []byte, result *Atom) bool {
+	num, den, ok := split_rational(input)
+	if !ok || den.Sign() == 0 {
+		return false
+	}
+	*result = make_rational(new(big.Rat).SetFrac(num, den))
+	return true
+}
+
+// zero_denominator returns true if the input looks like a rational
+// with a zero denominator. the readers report these as syntax errors
+// rather than reading them as symbols.
+func zero_denominator(input []byte) bool {
+	_, den, ok := split_rational(input)
+	return ok && den.Sign() == 0
+}
+
+// split_rational splits input in the form n/d into its numerator and
+// denominator. it returns false if the input is not in that form.
+func split_rational(input []byte) (num, den *big.Int, ok bool) {
+	slash := bytes.IndexByte(input, '/')
+	if slash == -1 {
+		return nil, nil, false
+	}
+	var n, d Atom
+	if !read_bignum(input[:slash], &n) {
+		return nil, nil, false
+	} else if len(input) == slash+1 || input[slash+1] == '+' || input[slash+1] == '-' {
+		// the sign must be on the numerator
+		return nil, nil, false
+	} else if !read_bignum(input[slash+1:], &d) {
+		return nil, nil, false
+	}
+	return to_bignum(n), to_bignum(d), true
+}
+
+// arith_op is an arithmetic operation on two numbers.
+// integer is called when both numbers are integers. it is responsible
+// for promoting the result to a bignum if it overflows.
+// bignum is called when both numbers are exact integers and either is
+// a bignum. rational is called when both numbers are exact and either
+// is a rational. if bignum or rational is nil, the numbers are converted
+// to floats instead.
+// float is called when either number is a float.
+type arith_op struct {
+	integer  func(a, b int) (Atom, error)
+	bignum   func(a, b *big.Int) (Atom, error)
+	rational func(a, b *big.Rat) (Atom, error)
+	float    func(a, b float64) (Atom, error)
+}
+
+// arith verifies that args holds exactly two numbers and applies the
+// operation to them, converting integers to floats when needed.
+// note that the result may not be updated if we find errors.
+func arith(args Atom, result *Atom, op arith_op) error {
+	// verify number and type of arguments
+	if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
+		return error_args("2", args)
+	}
+	a, b := car(args), car(cdr(args))
+	if !numberp(a) {
+		return error_type("number", a)
+	} else if !numberp(b) {
+		return error_type("number", b)
+	}
+
+	var val Atom
+	var err error
+	if a._type == AtomType_Integer && b._type == AtomType_Integer {
+		val, err = op.integer(a.value.integer(), b.value.integer())
+	} else if exact_integerp(a) && exact_integerp(b) && op.bignum != nil {
+		val, err = op.bignum(to_bignum(a), to_bignum(b))
+	} else if exactp(a) && exactp(b) && op.rational != nil {
+		val, err = op.rational(to_rational(a), to_rational(b))
+	} else {
+		val, err = op.float(to_float(a), to_float(b))
+	}
+	if err != nil {
+		return err
+	}
+	*result = val
+	return nil
+}
+
+// num_compare compares two numbers. it returns -1, 0, or +1 when a is
+// less than, equal to, or greater than b. ok is false if the numbers
+// can't be ordered (one of them is NaN).
+// panics if either atom is not a number.
+func num_compare(a, b Atom) (cmp int, ok bool) {
+	if a._type == AtomType_Integer && b._type == AtomType_Integer {
+		x, y := a.value.integer(), b.value.integer()
+		if x < y {
+			return -1, true
+		} else if x > y {
+			return 1, true
+		}
+		return 0, true
+	} else if exact_integerp(a) && exact_integerp(b) {
+		return to_bignum(a).Cmp(to_bignum(b)), true
+	} else if exactp(a) && exactp(b) {
+		return to_rational(a).Cmp(to_rational(b)), true
+	}
+	x, y := to_float(a), to_float(b)
+	if x < y {
+		return -1, true
+	} else if x > y {
+		return 1, true
+	} else if x == y {
+		return 0, true
+	}
+	return 0, false
+}
+
+// builtin_exactp returns #t if the argument is an exact number.
+// note that the result may not be updated if we find errors.
+func builtin_exactp(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	} else if !numberp(car(args)) {
+		return error_type("number", car(args))
+	}
+
+	if exactp(car(args)) {
+		*result = _true
+	} else {
+		*result = _false
+	}
+	return nil
+}
+
+// builtin_exact_to_inexact converts a number to a float.
+// note that the result may not be updated if we find errors.
+func builtin_exact_to_inexact(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	} else if !numberp(car(args)) {
+		return error_type("number", car(args))
+	}
+
+	*result = make_float(to_float(car(args)))
+	return nil
+}
+
+// builtin_expt raises the first argument to the power of the second.
+// the result is exact when the base is exact and the exponent is
+// an integer.
+// note that the result may not be updated if we find errors.
+func builtin_expt(args Atom, result *Atom) error {
+	return arith(args, result, arith_op{
+		integer: func(a, b int) (Atom, error) {
+			return rat_expt(big.NewRat(int64(a), 1), big.NewInt(int64(b)))
+		},
+		rational: func(a, b *big.Rat) (Atom, error) {
+			if !b.IsInt() || !b.Num().IsInt64() {
+				// the result is either irrational or too large to compute exactly
+				x, _ := a.Float64()
+				y, _ := b.Float64()
+				return make_float(math.Pow(x, y)), nil
+			}
+			return rat_expt(a, b.Num())
+		},
+		float: func(a, b float64) (Atom, error) {
+			return make_float(math.Pow(a, b)), nil
+		},
+	})
+}
+
+// rat_expt returns the exact value of a raised to the integer power of b.
+// it is an error to raise zero to a negative power.
+func rat_expt(a *big.Rat, b *big.Int) (Atom, error) {
+	num, den := new(big.Int).Set(a.Num()), new(big.Int).Set(a.Denom())
+	if b.Sign() < 0 {
+		if num.Sign() == 0 {
+			return _nil, Error_DivideByZero
+		}
+		num, den = den, num
+	}
+	e := new(big.Int).Abs(b)
+	num.Exp(num, e, nil)
+	den.Exp(den, e, nil)
+	return make_rational(new(big.Rat).SetFrac(num, den)), nil
+}
+
+// builtin_inexact_to_exact converts a number to the exact number with
+// the same value. it is an error if the number is infinite or NaN.
+// note that the result may not be updated if we find errors.
+func builtin_inexact_to_exact(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	}
+	a := car(args)
+	if exactp(a) {
+		*result = a
+		return nil
+	} else if a._type != AtomType_Float {
+		return error_type("number", a)
+	} else if math.IsInf(a.value.float(), 0) || math.IsNaN(a.value.float()) {
+		return error_type("finite number", a)
+	}
+
+	*result = make_rational(new(big.Rat).SetFloat64(a.value.float()))
+	return nil
+}
+
+// builtin_integerp returns #t if the argument is an integer.
+// floats with no fractional part are integers, too.
+// note that the result may not be updated if we find errors.
+func builtin_integerp(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	}
+
+	switch a := car(args); a._type {
+	case AtomType_Integer, AtomType_Bignum:
+		*result = _true
+	case AtomType_Float:
+		if math.Trunc(a.value.float()) == a.value.float() && !math.IsInf(a.value.float(), 0) {
+			*result = _true
+		} else {
+			*result = _false
+		}
+	default:
+		*result = _false
+	}
+	return nil
+}
+
+// builtin_numberp returns #t if the argument is a number.
+// note that the result may not be updated if we find errors.
+func builtin_numberp(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	}
+
+	if numberp(car(args)) {
+		*result = _true
+	} else {
+		*result = _false
+	}
+	return nil
+}
+
+// builtin_numerator returns the numerator of a number in lowest terms.
+// note that the result may not be updated if we find errors.
+func builtin_numerator(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	}
+	a := car(args)
+	if exact_integerp(a) {
+		*result = a
+	} else if a._type == AtomType_Rational {
+		*result = make_bignum(new(big.Int).Set(a.value.rational().Num()))
+	} else if a._type == AtomType_Float && !math.IsInf(a.value.float(), 0) && !math.IsNaN(a.value.float()) {
+		num, _ := new(big.Float).SetInt(new(big.Rat).SetFloat64(a.value.float()).Num()).Float64()
+		*result = make_float(num)
+	} else {
+		return error_type("rational number", a)
+	}
+	return nil
+}
+
+// builtin_denominator returns the denominator of a number in lowest terms.
+// note that the result may not be updated if we find errors.
+func builtin_denominator(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	}
+	a := car(args)
+	if exact_integerp(a) {
+		*result = make_int(1)
+	} else if a._type == AtomType_Rational {
+		*result = make_bignum(new(big.Int).Set(a.value.rational().Denom()))
+	} else if a._type == AtomType_Float && !math.IsInf(a.value.float(), 0) && !math.IsNaN(a.value.float()) {
+		den, _ := new(big.Float).SetInt(new(big.Rat).SetFloat64(a.value.float()).Denom()).Float64()
+		*result = make_float(den)
+	} else {
+		return error_type("rational number", a)
+	}
+	return nil
+}
+
+// builtin_modulo returns the remainder of dividing two integers.
+// the result has the same sign as the divisor.
+// note that the result may not be updated if we find errors.
+func builtin_modulo(args Atom, result *Atom) error {
+	return integer_division(args, result,
+		func(a, b, q, r int) int {
+			if r != 0 && (r < 0) != (b < 0) {
+				return r + b
+			}
+			return r
+		},
+		func(a, b, q, r *big.Int) *big.Int {
+			if r.Sign() != 0 && r.Sign() != b.Sign() {
+				return r.Add(r, b)
+			}
+			return r
+		})
+}
+
+// builtin_quotient returns the quotient of two integers, truncated towards zero.
+// note that the result may not be updated if we find errors.
+func builtin_quotient(args Atom, result *Atom) error {
+	return integer_division(args, result,
+		func(a, b, q, r int) int { return q },
+		func(a, b, q, r *big.Int) *big.Int { return q })
+}
+
+// builtin_remainder returns the remainder of dividing two integers.
+// the result has the same sign as the dividend.
+// note that the result may not be updated if we find errors.
+func builtin_remainder(args Atom, result *Atom) error {
+	return integer_division(args, result,
+		func(a, b, q, r int) int { return r },
+		func(a, b, q, r *big.Int) *big.Int { return r })
+}
+
+// integer_division divides two exact integers. int_fn (when both
+// arguments fit in an int) or fn (when they don't) is passed the
+// truncated quotient and the remainder and returns the result.
+// it is an error to divide by zero.
+// note that the result may not be updated if we find errors.
+func integer_division(args Atom, result *Atom, int_fn func(a, b, q, r int) int, fn func(a, b, q, r *big.Int) *big.Int) error {
+	return arith(args, result, arith_op{
+		integer: func(a, b int) (Atom, error) {
+			if b == 0 {
+				return _nil, Error_DivideByZero
+			} else if a == math.MinInt && b == -1 {
+				// the quotient overflows, so use bignums instead
+				x, y := big.NewInt(int64(a)), big.NewInt(int64(b))
+				q, r := new(big.Int).QuoRem(x, y, new(big.Int))
+				return make_bignum(fn(x, y, q, r)), nil
+			}
+			return make_int(int_fn(a, b, a/b, a%b)), nil
+		},
+		bignum: func(a, b *big.Int) (Atom, error) {
+			if b.Sign() == 0 {
+				return _nil, Error_DivideByZero
+			}
+			q, r := new(big.Int).QuoRem(a, b, new(big.Int))
+			return make_bignum(fn(a, b, q, r)), nil
+		},
+		float: func(a, b float64) (Atom, error) {
+			// integer division is only defined for exact integers
+			return _nil, &LispError{Err: Error_Type, Expected: "exact integer", Actual: AtomType_Float.String()}
+		},
+	})
+}
+
+// builtin_round returns a builtin that rounds a number to an integral
+// value. integers are returned unchanged; rationals are rounded using
+// exact_fn and become integers; floats are rounded using fn and stay
+// inexact.
+func builtin_round(fn func(float64) float64, exact_fn func(*big.Rat) *big.Int) Native {
+	return func(args Atom, result *Atom) error {
+		// verify number and type of arguments
+		if nilp(args) || !nilp(cdr(args)) {
+			return error_args("1", args)
+		}
+		a := car(args)
+		if exact_integerp(a) {
+			*result = a
+			return nil
+		} else if a._type == AtomType_Rational {
+			*result = make_bignum(exact_fn(a.value.rational()))
+			return nil
+		} else if a._type != AtomType_Float {
+			return error_type("number", a)
+		}
+
+		*result = make_float(fn(a.value.float()))
+		return nil
+	}
+}
+
+// rat_floor returns the largest integer not greater than x.
+func rat_floor(x *big.Rat) *big.Int {
+	// Div rounds towards negative infinity when the divisor is positive,
+	// and the denominator of a big.Rat is always positive.
+	return new(big.Int).Div(x.Num(), x.Denom())
+}
+
+// rat_ceiling returns the smallest integer not less than x.
+func rat_ceiling(x *big.Rat) *big.Int {
+	return new(big.Int).Neg(rat_floor(new(big.Rat).Neg(x)))
+}
+
+// rat_truncate returns the integer part of x.
+func rat_truncate(x *big.Rat) *big.Int {
+	return new(big.Int).Quo(x.Num(), x.Denom())
+}
+
+// rat_round returns the integer closest to x, rounding to even when x
+// is halfway between two integers.
+func rat_round(x *big.Rat) *big.Int {
+	floor := rat_floor(x)
+	diff := new(big.Rat).Sub(x, new(big.Rat).SetInt(floor))
+	switch diff.Cmp(big.NewRat(1, 2)) {
+	case -1:
+		return floor
+	case 0:
+		if floor.Bit(0) == 0 {
+			return floor
+		}
+	}
+	return floor.Add(floor, big.NewInt(1))
+}
+
+// builtin_sqrt returns the square root of a number.
+// the result is exact when the argument is an exact square.
+// note that the result may not be updated if we find errors.
+func builtin_sqrt(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	}
+	a := car(args)
+	if !numberp(a) {
+		return error_type("number", a)
+	}
+
+	if a._type == AtomType_Integer && a.value.integer() >= 0 {
+		root := int(math.Sqrt(float64(a.value.integer())))
+		// correct for rounding in the float conversion
+		for root*root > a.value.integer() {
+			root--
+		}
+		for (root+1)*(root+1) <= a.value.integer() {
+			root++
+		}
+		if root*root == a.value.integer() {
+			*result = make_int(root)
+			return nil
+		}
+	} else if a._type == AtomType_Bignum && a.value.bignum().Sign() > 0 {
+		root := new(big.Int).Sqrt(a.value.bignum())
+		if new(big.Int).Mul(root, root).Cmp(a.value.bignum()) == 0 {
+			*result = make_bignum(root)
+			return nil
+		}
+	} else if a._type == AtomType_Rational && a.value.rational().Sign() > 0 {
+		num, den := a.value.rational().Num(), a.value.rational().Denom()
+		num_root, den_root := new(big.Int).Sqrt(num), new(big.Int).Sqrt(den)
+		if new(big.Int).Mul(num_root, num_root).Cmp(num) == 0 && new(big.Int).Mul(den_root, den_root).Cmp(den) == 0 {
+			*result = make_rational(new(big.Rat).SetFrac(num_root, den_root))
+			return nil
+		}
+	}
+
+	*result = make_float(math.Sqrt(to_float(a)))
+	return nil
+}
+
+// builtin_transcendental returns a builtin that applies fn to a number.
+// the result is always inexact.
+func builtin_transcendental(fn func(float64) float64) Native {
+	return func(args Atom, result *Atom) error {
+		// verify number and type of arguments
+		if nilp(args) || !nilp(cdr(args)) {
+			return error_args("1", args)
+		} else if !numberp(car(args)) {
+			return error_type("number", car(args))
+		}
+
+		*result = make_float(fn(to_float(car(args))))
+		return nil
+	}
+}
+
+// builtin_atan returns the arc tangent of a number, or, with two
+// arguments, the arc tangent of y/x using the signs of both to
+// determine the quadrant.
+// note that the result may not be updated if we find errors.
+func builtin_atan(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !(nilp(cdr(args)) || nilp(cdr(cdr(args)))) {
+		return error_args("1 or 2", args)
+	}
+	if nilp(cdr(args)) {
+		return builtin_transcendental(math.Atan)(args, result)
+	}
+	return arith(args, result, arith_op{
+		integer: func(y, x int) (Atom, error) {
+			return make_float(math.Atan2(float64(y), float64(x))), nil
+		},
+		float: func(y, x float64) (Atom, error) {
+			return make_float(math.Atan2(y, x)), nil
+		},
+	})
+}
+
+// builtin_log returns the natural logarithm of a number, or, with two
+// arguments, the logarithm of the first in the base of the second.
+// note that the result may not be updated if we find errors.
+func builtin_log(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !(nilp(cdr(args)) || nilp(cdr(cdr(args)))) {
+		return error_args("1 or 2", args)
+	}
+	if nilp(cdr(args)) {
+		return builtin_transcendental(math.Log)(args, result)
+	}
+	return arith(args, result, arith_op{
+		integer: func(x, base int) (Atom, error) {
+			return make_float(math.Log(float64(x)) / math.Log(float64(base))), nil
+		},
+		float: func(x, base float64) (Atom, error) {
+			return make_float(math.Log(x) / math.Log(base)), nil
+		},
+	})
+}
@@ -4,6 +4,12 @@ package lisp
 
 // Pair is the two elements of a cell.
 // "car" is the left-hand value and "cdr" is the right-hand.
+// pos is the position in the source code of the expression in the
+// car, or of the opening paren for the first pair in a list. It is
+// nil for pairs that were not created by the reader.
+// mark is used by the garbage collector.
 type Pair struct {
 	car, cdr Atom
+	pos      *Position
+	mark     uint32
 }
@@ -0,0 +1,94 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lisp
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+// Position is a location in the source code.
+// Lines and columns start at 1; columns count characters, not bytes.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// String implements the Stringer interface.
+// It returns "file:line:column", or "line:column" if there is no file name.
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// source is the text being read.
+// the reader uses it to find the position of a token.
+type source struct {
+	name  string
+	text  []byte
+	lines []int // offset of the start of each line
+	// trivia is the offset of the end of the last comment recorded.
+	trivia int
+}
+
+// new_source returns a source for the text.
+func new_source(name string, text []byte) *source {
+	src := &source{name: name, text: text, lines: []int{0}}
+	for n, ch := range text {
+		if ch == '\n' {
+			src.lines = append(src.lines, n+1)
+		}
+	}
+	return src
+}
+
+// offset returns the offset of the input from the start of the source.
+// it returns false if the input is not a slice of the source text.
+// this relies on the lexer and reader always returning slices of
+// their input rather than copies.
+func (src *source) offset(input []byte) (int, bool) {
+	offset := cap(src.text) - cap(input)
+	if offset < 0 || offset > len(src.text) {
+		return 0, false
+	} else if offset < len(src.text) && len(input) != 0 && &src.text[offset] != &input[0] {
+		return 0, false
+	}
+	return offset, true
+}
+
+// position returns the position of the token in the source that
+// the interpreter is currently reading from.
+// it returns nil if the position is not known.
+func (l *Interpreter) position(token []byte) *Position {
+	if l.source == nil || len(token) == 0 {
+		return nil
+	}
+	offset, ok := l.source.offset(token)
+	if !ok {
+		return nil
+	}
+	// find the line that contains the offset
+	line := sort.Search(len(l.source.lines), func(i int) bool {
+		return l.source.lines[i] > offset
+	})
+	start := l.source.lines[line-1]
+	return &Position{
+		File:   l.source.name,
+		Line:   line,
+		Column: utf8.RuneCount(l.source.text[start:offset]) + 1,
+	}
+}
+
+// pos_of returns the position recorded for an expression.
+// it returns nil if the expression is not a pair or if its
+// position is not known.
+func pos_of(expr Atom) *Position {
+	if expr._type != AtomType_Pair {
+		return nil
+	}
+	return expr.value.pair().pos
+}
@@ -9,18 +9,19 @@ import (
 	"strconv"
 )
 
-func load_file(env Atom, path string) error {
+func (l *Interpreter) load_file(env Atom, path string) error {
 	fmt.Printf("Reading %s...\n", path)
 	input, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
+	l.source = new_source(path, input)
 
 	var expr Atom
-	rest, err := read_expr(input, &expr)
-	for ; err == nil; rest, err = read_expr(rest, &expr) {
+	rest, err := l.read_expr(input, &expr)
+	for ; err == nil; rest, err = l.read_expr(rest, &expr) {
 		var result Atom
-		if err := eval_expr(expr, env, &result); err != nil {
+		if err := l.eval_expr(expr, env, &result); err != nil {
 			fmt.Printf("error: %s in expression:\n\t%s\n", err, expr.String())
 		} else {
 			fmt.Printf("%s\n", result.String())
@@ -33,33 +34,50 @@ func load_file(env Atom, path string) error {
 	return nil
 }
 
-// read_atom reads an atom (a number or symbol) from the input.
+// read_atom reads an atom (a boolean, character, number, string, or symbol)
+// from the input.
 // if it's a symbol, we assume that the caller has parsed it already
 // and do no checking that it is a valid symbol.
-func read_atom(input []byte, result *Atom) error {
-	if val, err := strconv.Atoi(string(input)); err == nil { // it is an integer
-		*result = make_int(val)
+func (l *Interpreter) read_atom(input []byte, result *Atom) error {
+	if read_number(input, result) || read_boolean(input, result) {
 		return nil
+	} else if zero_denominator(input) {
+		return Error_Syntax
+	} else if input[0] == '"' {
+		return read_string(input, result)
+	} else if bytes.HasPrefix(input, []byte{'#', '\\'}) {
+		return read_char(input, result)
 	}
-	// it is a symbol, but we must treat NIL specially.
-	if label := bytes.ToUpper(input); bytes.Equal(label, []byte{'N', 'I', 'L'}) {
+	// it is a symbol, but we must treat NIL, in any case, specially.
+	if bytes.EqualFold(input, []byte{'N', 'I', 'L'}) {
 		// it is NIL and NIL must never be added to the symbol table.
 		*result = _nil
 	} else {
-		*result = make_sym(label)
+		*result = l.make_sym(input)
 	}
 	return nil
 }
 
+// read_number reads a number from the input.
+// it returns false if the input is not a number.
+// note that the result is not updated unless it is a number.
+func read_number(input []byte, result *Atom) bool {
+	if val, err := strconv.Atoi(string(input)); err == nil { // it is an integer
+		*result = make_int(val)
+		return true
+	}
+	return read_bignum(input, result) || read_rational(input, result) || read_float(input, result)
+}
+
 // read_list reads the next list from the input.
 // it returns the remainder of the input or an error.
-func read_list(input []byte, result *Atom) (remainder []byte, err error) {
+func (l *Interpreter) read_list(input []byte, result *Atom) (remainder []byte, err error) {
 	// set the result to NIL in case we read an empty list.
 	*result = _nil
 
 	var token []byte
 	var tail Atom
-	for token, remainder = lex(input); token != nil; token, remainder = lex(input) {
+	for token, remainder = l.lex(input); token != nil; token, remainder = l.lex(input) {
 		// check for ")"
 		if bytes.Equal(token, []byte{')'}) {
 			// result holds the list.
@@ -72,23 +90,26 @@ func read_list(input []byte, result *Atom) (remainder []byte, err error) {
 			// a dotted list must look like "(x . y)" or it is an improper list
 			if nilp(tail) {
 				// dot can't start a list, so this is an improper list
-				return nil, Error_Syntax
+				return nil, error_at(Error_Syntax, l.position(token))
 			}
 
 			// read the next expression and set the cdr of the current atom to it
 			var expr Atom
-			remainder, err = read_expr(remainder, &expr)
-			if err != nil {
+			remainder, err = l.read_expr(remainder, &expr)
+			if err == Error_EndOfInput {
+				// eof is an error since lists must end with a close paren.
+				return nil, Error_Syntax
+			} else if err != nil {
 				// return the error
 				return nil, err
 			}
 			setcdr(tail, expr)
 
 			// read the closing paren
-			token, remainder = lex(remainder)
+			token, remainder = l.lex(remainder)
 			if !bytes.Equal(token, []byte{')'}) {
 				// no closing paren, so this is an improper list
-				return nil, Error_Syntax
+				return nil, error_at(Error_Syntax, l.position(token))
 			}
 
 			// result holds the list.
@@ -98,8 +119,11 @@ func read_list(input []byte, result *Atom) (remainder []byte, err error) {
 
 		// read the next expression
 		var expr Atom
-		remainder, err = read_expr(input, &expr)
-		if err != nil {
+		remainder, err = l.read_expr(input, &expr)
+		if err == Error_EndOfInput {
+			// eof is an error since lists must end with a close paren.
+			return nil, Error_Syntax
+		} else if err != nil {
 			// return the error
 			return nil, err
 		}
@@ -107,13 +131,15 @@ func read_list(input []byte, result *Atom) (remainder []byte, err error) {
 		// and append it to the tail of the list
 		if nilp(tail) {
 			// first item in the list, so create a new list
-			*result = cons(expr, _nil)
+			*result = l.cons(expr, _nil)
 			tail = *result
 		} else {
 			// append to tail, then update tail
-			setcdr(tail, cons(expr, _nil))
+			setcdr(tail, l.cons(expr, _nil))
 			tail = cdr(tail)
 		}
+		// remember where the expression started
+		tail.value.pair().pos = l.position(token)
 
 		// at this point:
 		//    result is the head of the list
@@ -133,39 +159,64 @@ func read_list(input []byte, result *Atom) (remainder []byte, err error) {
 // returns NIL and Error_EndOfInput on end of input. the caller must
 // decide how to handle it.
 // todo: result is not always updated by read. does that lead to bugs later?
-func read_expr(input []byte, result *Atom) (remainder []byte, err error) {
-	token, rest := lex(input)
+func (l *Interpreter) read_expr(input []byte, result *Atom) (remainder []byte, err error) {
+	token, rest := l.lex(input)
 	if token == nil { // end of input
 		return nil, Error_EndOfInput
 	}
 
+	pos := l.position(token)
 	switch token[0] {
 	case '(':
-		return read_list(rest, result)
+		if remainder, err = l.read_list(rest, result); err != nil {
+			// errors without a position are reported at the open paren
+			return nil, error_at(err, pos)
+		} else if result._type == AtomType_Pair {
+			result.value.pair().pos = pos
+		}
+		return remainder, nil
 	case ')':
 		// unexpected close paren
-		return nil, Error_Syntax
+		return nil, error_at(Error_Syntax, pos)
+	case '#':
+		if bytes.Equal(token, []byte("#(")) {
+			// a vector is read as a list and then converted
+			var list Atom
+			if remainder, err = l.read_list(rest, &list); err != nil {
+				return nil, error_at(err, pos)
+			} else if *result, err = list_to_vector(list); err != nil {
+				// a vector can't be a dotted list
+				return nil, error_at(Error_Syntax, pos)
+			}
+			return remainder, nil
+		}
 	case '\'':
-		sym := []byte("QUOTE")
-		*result = cons(make_sym(sym), cons(_nil, _nil))
-		// set car(cdr(result))
-		return read_expr(rest, &result.value.pair.cdr.value.pair.car)
+		return l.read_quoted("QUOTE", pos, rest, result)
 	case '`':
-		sym := []byte("QUASIQUOTE")
-		*result = cons(make_sym(sym), cons(_nil, _nil))
-		// set car(cdr(result))
-		return read_expr(rest, &result.value.pair.cdr.value.pair.car)
+		return l.read_quoted("QUASIQUOTE", pos, rest, result)
 	case ',':
-		sym := []byte("UNQUOTE")
 		if len(token) > 1 && token[1] == '@' {
-			sym = []byte("UNQUOTE-SPLICING")
+			return l.read_quoted("UNQUOTE-SPLICING", pos, rest, result)
 		}
-		*result = cons(make_sym(sym), cons(_nil, _nil))
-		// set car(cdr(result))
-		return read_expr(rest, &result.value.pair.cdr.value.pair.car)
+		return l.read_quoted("UNQUOTE", pos, rest, result)
 	}
-	err = read_atom(token, result)
-	return rest, err
+	if bytes.HasPrefix(token, []byte("#|")) {
+		// unterminated block comment
+		return nil, error_at(Error_Syntax, pos)
+	} else if err = l.read_atom(token, result); err != nil {
+		return nil, error_at(err, pos)
+	}
+	return rest, nil
+}
+
+// read_quoted reads the expression following a quote character
+// and sets the result to (sym expr).
+// pos is the position of the quote character.
+func (l *Interpreter) read_quoted(sym string, pos *Position, input []byte, result *Atom) (remainder []byte, err error) {
+	*result = l.cons(l.intern(sym), l.cons(_nil, _nil))
+	result.value.pair().pos = pos
+	// set car(cdr(result))
+	return l.read_expr(input, &result.value.pair().cdr.value.pair().car)
 }
 
 // read reads the next expression from the input.
@@ -173,20 +224,27 @@ func read_expr(input []byte, result *Atom) (remainder []byte, err error) {
 // returns an error for any syntax error (such as unterminated list).
 // returns NIL, nil, and Error_EndOfInput on end of input.
 // otherwise, returns the expression and the remainder of the input.
-func read(input []byte) (expr Atom, remainder []byte, err error) {
+func (l *Interpreter) read(input []byte) (expr Atom, remainder []byte, err error) {
 	// stack and slice are used for building lists as we read them.
 	// slice tricks cheat sheet -> https://ueokande.github.io/go-slice-tricks/
-	var stack []Atom // stack of in-process lists
+	var stack []Atom         // stack of in-process lists
+	var starts []*Position   // position of the open paren for each list
+	var vectors []bool       // true if the list is a vector
+	var pos, start *Position // position of the current token and list
 
-	for token, rest := lex(input); token != nil; token, rest = lex(rest) {
+	for token, rest := l.lex(input); token != nil; token, rest = l.lex(rest) {
 		var atom Atom
+		pos = l.position(token)
 
 		// handle some syntax.
 		//   '(' starts a new list.
+		//   "#(" starts a new vector, which is read as a list.
 		//   '.' splices in a dotted pair.
-		if token[0] == '(' {
+		if token[0] == '(' || bytes.Equal(token, []byte("#(")) {
 			// push a new list onto the stack
 			stack = append(stack, _nil)
+			starts = append(starts, pos)
+			vectors = append(vectors, token[0] == '#')
 			continue // process the next token
 		} else if bytes.Equal(token, []byte{'.'}) {
 			// a dotted pair must look like "(x . y)" or it is an error.
@@ -196,19 +254,19 @@ func read(input []byte) (expr Atom, remainder []byte, err error) {
 			// is no list or if the next token in the input is not a close paren.
 			if len(stack) == 0 || nilp(stack[len(stack)-1]) {
 				// dot can't start a list, so this is an improper list
-				return _nil, nil, Error_Syntax
+				return _nil, nil, error_at(Error_Syntax, pos)
 			}
 
 			// the cdr of the dotted pair is the next expression
-			if atom, rest, err = read(rest); err != nil {
+			if atom, rest, err = l.read(rest); err != nil {
 				return _nil, nil, err
 			}
 
 			// the dotted pair must be followed by a close paren.
 			// verify by looking ahead at the next token.
-			if lookAhead, _ := lex(rest); !bytes.Equal(lookAhead, []byte{')'}) {
+			if lookAhead, _ := l.lex(rest); !bytes.Equal(lookAhead, []byte{')'}) {
 				// no closing paren, so this is an improper list
-				return _nil, nil, Error_Syntax
+				return _nil, nil, error_at(Error_Syntax, l.position(lookAhead))
 			}
 
 			// get the list from the stack so that we can hack it.
@@ -229,23 +287,52 @@ func read(input []byte) (expr Atom, remainder []byte, err error) {
 			// found end of a list
 			if len(stack) == 0 {
 				// empty stack means unexpected close paren
-				return _nil, nil, Error_Syntax
+				return _nil, nil, error_at(Error_Syntax, pos)
 			}
 			// pop the list from the stack
 			atom, stack = stack[len(stack)-1], stack[:len(stack)-1]
+			start, starts = starts[len(starts)-1], starts[:len(starts)-1]
+			vector := vectors[len(vectors)-1]
+			vectors = vectors[:len(vectors)-1]
+			if vector {
+				if atom, err = list_to_vector(atom); err != nil {
+					// a vector can't be a dotted list
+					return _nil, nil, error_at(Error_Syntax, start)
+				}
+			} else if atom._type == AtomType_Pair {
+				atom.value.pair().pos = start
+			}
+			// the list starts at the open paren
+			pos = start
 
 		default:
-			if val, err := strconv.Atoi(string(token)); err == nil {
-				// it is an integer
-				atom = make_int(val)
+			if bytes.HasPrefix(token, []byte("#|")) {
+				// unterminated block comment
+				return _nil, nil, error_at(Error_Syntax, pos)
+			} else if read_number(token, &atom) {
+				// it is a number
+			} else if zero_denominator(token) {
+				// a rational like 1/0 is not a symbol
+				return _nil, nil, error_at(Error_Syntax, pos)
+			} else if read_boolean(token, &atom) {
+				// it is a boolean
+			} else if bytes.HasPrefix(token, []byte{'#', '\\'}) {
+				// it is a character
+				if err = read_char(token, &atom); err != nil {
+					return _nil, nil, error_at(err, pos)
+				}
+			} else if token[0] == '"' {
+				// it is a string
+				if err = read_string(token, &atom); err != nil {
+					return _nil, nil, error_at(err, pos)
+				}
 			} else {
 				// it is a symbol
-				sym := bytes.ToUpper(token)
-				if bytes.Equal(sym, []byte{'N', 'I', 'L'}) {
+				if bytes.EqualFold(token, []byte{'N', 'I', 'L'}) {
 					// treat NIL specially.
 					atom = _nil
 				} else {
-					atom = make_sym(sym)
+					atom = l.make_sym(token)
 				}
 			}
 		}
@@ -258,12 +345,14 @@ func read(input []byte) (expr Atom, remainder []byte, err error) {
 		// append the atom to the list at the top of the stack
 		var list Atom
 		list, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		item := l.cons(atom, _nil)
+		item.value.pair().pos = pos
 		if nilp(list) {
-			list = cons(atom, _nil)
+			list = item
 		} else {
 			for tail := list; !nilp(tail); tail = cdr(tail) {
 				if nilp(cdr(tail)) {
-					setcdr(tail, cons(atom, _nil))
+					setcdr(tail, item)
 					break
 				}
 			}
@@ -273,7 +362,7 @@ func read(input []byte) (expr Atom, remainder []byte, err error) {
 
 	if len(stack) != 0 {
 		// unexpected end of input
-		return _nil, nil, Error_Syntax
+		return _nil, nil, error_at(Error_Syntax, starts[len(starts)-1])
 	}
 
 	// input contained no expressions at all
@@ -0,0 +1,346 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lisp
+
+// when the evaluator creates a closure, it resolves the references in
+// the body to the local variables of the closure and of the closures
+// that it is nested in. each reference is replaced by a local, which
+// holds the depth of the frame and the index of the slot that the
+// variable is kept in. a call to the closure binds the arguments in a
+// vector of slots instead of an association list, so a local is found
+// without searching.
+//
+// the slots are for the arguments and for the names that the body
+// defines. a name that isn't found is left as a symbol, and is looked
+// up by name when it is evaluated. so are names in a GUARD clause and
+// the arguments of a macro, since they aren't evaluated as they are
+// written.
+//
+// the derived forms in the body, like LET and COND, are replaced by
+// their expansions, which are resolved with the rest of the body. the
+// LAMBDA forms in the body are resolved along with it. so both are
+// only done once however many closures are created from the body or
+// however many times it is evaluated.
+
+// eval_lambda is a LAMBDA form whose body has been resolved.
+type eval_lambda struct {
+	// args are the names of the arguments, as written.
+	args Atom
+	// body is the resolved body.
+	body Atom
+	// names are the names of the slots. a name defined by a macro
+	// expansion is added when it is defined.
+	names []*Symbol
+	// macros are the names that the body defines as macros.
+	macros map[*Symbol]bool
+	// lambdas are the resolved LAMBDA forms in the body,
+	// indexed by their bodies.
+	lambdas map[*Pair]*eval_lambda
+	// sources are the derived forms in the body,
+	// indexed by their resolved expansions.
+	sources map[*Pair]Atom
+	// mark is used by the garbage collector.
+	mark uint32
+}
+
+// index returns the slot for a name, or -1 if there isn't one.
+func (x *eval_lambda) index(sym *Symbol) int {
+	// search from the end, so that the last of two
+	// arguments with the same name is found
+	for index := len(x.names) - 1; index >= 0; index-- {
+		if x.names[index] == sym {
+			return index
+		}
+	}
+	return -1
+}
+
+// declare adds a slot for a name that the body defines.
+func (x *eval_lambda) declare(sym *Symbol, macro bool) {
+	if x.index(sym) < 0 {
+		x.names = append(x.names, sym)
+	}
+	if macro {
+		if x.macros == nil {
+			x.macros = make(map[*Symbol]bool)
+		}
+		x.macros[sym] = true
+	}
+}
+
+// resolve_scope is the closure whose slots a reference may be in, and
+// the closure that it is nested in.
+type resolve_scope struct {
+	lambda *eval_lambda
+	parent *resolve_scope
+}
+
+// lookup returns the depth and index of the slot for a name.
+// ok is false if the name isn't bound in the scope or its parents.
+func (s *resolve_scope) lookup(sym *Symbol) (depth, index int, ok bool) {
+	for ; s != nil; s, depth = s.parent, depth+1 {
+		if index = s.lambda.index(sym); index >= 0 {
+			return depth, index, true
+		}
+	}
+	return 0, 0, false
+}
+
+// resolver holds the state for resolving the body of a closure.
+type resolver struct {
+	l *Interpreter
+	// env is the environment outside the scopes, which is the
+	// global environment or one created for a GUARD clause.
+	env Atom
+}
+
+// resolve_lambda returns the resolved form of a LAMBDA with the given
+// arguments and body, for a closure created in env.
+func (l *Interpreter) resolve_lambda(env, args, body Atom) *eval_lambda {
+	if !nilp(env) {
+		if slots := frame_slots(env); slots != nil {
+			// the form was resolved with the body it is in
+			if x, ok := slots.items[0].value.closure().lambda.lambdas[body.value.pair()]; ok && x.args == args {
+				return x
+			}
+		}
+	}
+
+	// the scopes are the closures that env has slots for
+	r := &resolver{l: l}
+	var lambdas []*eval_lambda
+	for r.env = env; !nilp(r.env); r.env = car(r.env) {
+		slots := frame_slots(r.env)
+		if slots == nil {
+			break
+		}
+		lambdas = append(lambdas, slots.items[0].value.closure().lambda)
+	}
+	var s *resolve_scope
+	for n := len(lambdas) - 1; n >= 0; n-- {
+		s = &resolve_scope{lambda: lambdas[n], parent: s}
+	}
+	return r.lambda(s, args, body)
+}
+
+// lambda returns a LAMBDA form resolved in the scope s.
+// the arguments must have been checked.
+func (r *resolver) lambda(s *resolve_scope, args, body Atom) *eval_lambda {
+	x := &eval_lambda{args: args}
+	for ; args._type == AtomType_Pair; args = cdr(args) {
+		x.names = append(x.names, car(args).value.symbol())
+	}
+	if args._type == AtomType_Symbol {
+		x.names = append(x.names, args.value.symbol())
+	}
+	r.declare(x, body)
+	x.body = r.list(&resolve_scope{lambda: x, parent: s}, body)
+	return x
+}
+
+// declare adds slots for the names defined in a body.
+func (r *resolver) declare(x *eval_lambda, body Atom) {
+	for ; body._type == AtomType_Pair; body = cdr(body) {
+		form := car(body)
+		if form._type != AtomType_Pair || !listp(form) || nilp(cdr(form)) {
+			continue
+		}
+		name := car(cdr(form))
+		switch r.l.form(car(form)) {
+		case form_begin:
+			// a BEGIN in the body defines names in the body
+			r.declare(x, cdr(form))
+		case form_define:
+			if name._type == AtomType_Pair {
+				name = car(name)
+			}
+			if name._type == AtomType_Symbol {
+				x.declare(name.value.symbol(), false)
+			}
+		case form_define_syntax:
+			if name._type == AtomType_Symbol {
+				x.declare(name.value.symbol(), true)
+			}
+		case form_defmacro:
+			if name._type == AtomType_Pair && car(name)._type == AtomType_Symbol {
+				x.declare(car(name).value.symbol(), true)
+			}
+		}
+	}
+}
+
+// expr returns an expression resolved in the scope s.
+func (r *resolver) expr(s *resolve_scope, expr Atom) Atom {
+	switch expr._type {
+	case AtomType_Symbol:
+		if depth, index, ok := s.lookup(expr.value.symbol()); ok {
+			return make_local(expr.value.symbol(), depth, index)
+		}
+	case AtomType_Pair:
+		return r.form(s, expr)
+	}
+	return expr
+}
+
+// form returns a list resolved in the scope s. special forms that
+// aren't evaluated as they are written are returned as they are.
+func (r *resolver) form(s *resolve_scope, expr Atom) Atom {
+	if !listp(expr) {
+		return expr
+	}
+	op, args := car(expr), cdr(expr)
+	switch r.l.form(op) {
+	case form_none, form_arrow, form_else, form_syntax_rules:
+		if op._type == AtomType_Symbol && r.macrop(s, op) {
+			return expr
+		}
+		return r.list(s, expr)
+	case form_and, form_apply, form_begin, form_call_cc, form_if, form_or, form_set:
+		return r.copy(expr, op, r.list(s, args))
+	case form_define:
+		if nilp(args) || nilp(cdr(args)) {
+			return expr
+		} else if name := car(args); name._type == AtomType_Symbol {
+			return r.copy(expr, op, r.copy(args, name, r.list(s, cdr(args))))
+		} else if name._type == AtomType_Pair && check_lambda(cdr(name), cdr(args)) == nil {
+			x := r.inner(s, cdr(name), cdr(args))
+			return r.copy(expr, op, r.copy(args, name, x.body))
+		}
+	case form_lambda:
+		if !nilp(args) && !nilp(cdr(args)) && check_lambda(car(args), cdr(args)) == nil {
+			x := r.inner(s, car(args), cdr(args))
+			return r.copy(expr, op, r.copy(args, car(args), x.body))
+		}
+	case form_case, form_cond, form_do, form_let, form_let_star, form_let_syntax, form_letrec, form_unless, form_when:
+		return r.derived(s, expr)
+	}
+	return expr
+}
+
+// derived returns the expansion of a derived form, resolved in the
+// scope s. the form is returned as it is if it isn't valid, so that
+// the error is reported when it is evaluated.
+func (r *resolver) derived(s *resolve_scope, expr Atom) Atom {
+	expansion, err := r.l.expand_derived(car(expr), cdr(expr))
+	if err != nil || expansion._type != AtomType_Pair {
+		return expr
+	}
+	expansion.value.pair().pos = pos_of(expr)
+	expansion = r.expr(s, expansion)
+	if expansion._type != AtomType_Pair {
+		return expr
+	}
+	// the source is kept for unresolve
+	if s.lambda.sources == nil {
+		s.lambda.sources = make(map[*Pair]Atom)
+	}
+	s.lambda.sources[expansion.value.pair()] = expr
+	return expansion
+}
+
+// inner returns a LAMBDA form in the body of the closure for the scope
+// s, resolved in that scope. it is saved so that it is found when the
+// form is evaluated.
+func (r *resolver) inner(s *resolve_scope, args, body Atom) *eval_lambda {
+	x := r.lambda(s, args, body)
+	if s.lambda.lambdas == nil {
+		s.lambda.lambdas = make(map[*Pair]*eval_lambda)
+	}
+	s.lambda.lambdas[x.body.value.pair()] = x
+	return x
+}
+
+// list returns a list of expressions resolved in the scope s.
+// the list is only copied if something in it was resolved.
+func (r *resolver) list(s *resolve_scope, list Atom) Atom {
+	if list._type != AtomType_Pair {
+		return list
+	}
+	head, tail := r.expr(s, car(list)), r.list(s, cdr(list))
+	if head == car(list) && tail == cdr(list) {
+		return list
+	}
+	return r.copy(list, head, tail)
+}
+
+// copy returns a new pair with the position of an existing one.
+func (r *resolver) copy(p, car, cdr Atom) Atom {
+	if car == p.value.pair().car && cdr == p.value.pair().cdr {
+		return p
+	}
+	q := r.l.cons(car, cdr)
+	q.value.pair().pos = p.value.pair().pos
+	return q
+}
+
+// macrop returns true if a symbol names a macro, so that the arguments
+// in a call to it aren't evaluated. a macro that is defined after the
+// closure is created isn't found; unresolve undoes the resolution of
+// the arguments when the macro is called.
+func (r *resolver) macrop(s *resolve_scope, sym Atom) bool {
+	for ; s != nil; s = s.parent {
+		if s.lambda.index(sym.value.symbol()) >= 0 {
+			return s.lambda.macros[sym.value.symbol()]
+		}
+	}
+	var value Atom
+	if err := r.l.env_get(r.env, sym, &value); err != nil {
+		return false
+	}
+	return value._type == AtomType_Macro || value._type == AtomType_Syntax
+}
+
+// make_local returns a reference to the slot for a name.
+func make_local(sym *Symbol, depth, index int) Atom {
+	return Atom{
+		_type: AtomType_Local,
+		value: AtomValue{
+			ref: sym,
+			n:   int64(depth)<<32 | int64(index),
+		},
+	}
+}
+
+// symbol_of returns the symbol that a local refers to.
+// any other atom is returned as it is.
+func symbol_of(atom Atom) Atom {
+	if atom._type == AtomType_Local {
+		return Atom{_type: AtomType_Symbol, value: AtomValue{ref: atom.value.ref}}
+	}
+	return atom
+}
+
+// unresolve returns a list of expressions with the locals in them
+// replaced by their names, and the expansions of derived forms replaced
+// by the forms as they were written. it is used on the arguments to a
+// macro that is called in env.
+func (l *Interpreter) unresolve(env, list Atom) Atom {
+	var sources map[*Pair]Atom
+	if slots := frame_slots(env); slots != nil {
+		sources = slots.items[0].value.closure().lambda.sources
+	}
+	return l.unresolve_list(sources, list)
+}
+
+// unresolve_list returns a list with the resolution undone.
+func (l *Interpreter) unresolve_list(sources map[*Pair]Atom, list Atom) Atom {
+	if list._type != AtomType_Pair {
+		return symbol_of(list)
+	}
+	head, tail := car(list), l.unresolve_list(sources, cdr(list))
+	if head._type == AtomType_Local {
+		head = symbol_of(head)
+	} else if head._type == AtomType_Pair {
+		if source, ok := sources[head.value.pair()]; ok {
+			head = source
+		} else if l.form(car(head)) != form_quote {
+			head = l.unresolve_list(sources, head)
+		}
+	}
+	if head == car(list) && tail == cdr(list) {
+		return list
+	}
+	p := l.cons(head, tail)
+	p.value.pair().pos = list.value.pair().pos
+	return p
+}
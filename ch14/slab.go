@@ -0,0 +1,68 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lisp
+
+// slab_size is the number of pairs in each slab.
+const slab_size = 1024
+
+// pair_slabs hands out pairs from large slabs, so that allocating a
+// pair doesn't need its own Go allocation. the garbage collector
+// returns unreachable pairs to a free list, and they are handed out
+// again before any new pairs are taken from a slab.
+//
+// a slab is never returned to Go, so the heap is as large as it was
+// when the most pairs were in use.
+type pair_slabs struct {
+	// slabs is every slab allocated. only the last one has unused pairs.
+	slabs [][]Pair
+	// next is the index of the next unused pair in the last slab.
+	next int
+	// free is the list of free pairs, linked through their cdr.
+	free *Pair
+}
+
+// alloc returns a pair, which may have been used before.
+func (s *pair_slabs) alloc() *Pair {
+	if p := s.free; p != nil {
+		s.free = p.cdr.value.pair()
+		return p
+	}
+	if len(s.slabs) == 0 || s.next == slab_size {
+		s.slabs = append(s.slabs, make([]Pair, slab_size))
+		s.next = 0
+	}
+	p := &s.slabs[len(s.slabs)-1][s.next]
+	s.next++
+	return p
+}
+
+// release clears a pair, marks it as free, and adds it to the free list.
+func (s *pair_slabs) release(p *Pair) {
+	*p = Pair{mark: gc_free}
+	if s.free != nil {
+		p.cdr = Atom{_type: AtomType_Pair, value: AtomValue{ref: s.free}}
+	}
+	s.free = p
+}
+
+// each calls fn for every pair that has been handed out,
+// including the ones on the free list.
+func (s *pair_slabs) each(fn func(p *Pair)) {
+	for n, slab := range s.slabs {
+		if n == len(s.slabs)-1 {
+			slab = slab[:s.next]
+		}
+		for i := range slab {
+			fn(&slab[i])
+		}
+	}
+}
+
+// size returns the number of pairs that have been handed out,
+// including the ones on the free list.
+func (s *pair_slabs) size() int {
+	if len(s.slabs) == 0 {
+		return 0
+	}
+	return (len(s.slabs)-1)*slab_size + s.next
+}
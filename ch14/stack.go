@@ -15,13 +15,71 @@ const (
 // make_frame returns a frame.
 // the standard layout of a frame makes it easy to use
 // list_get to fetch values and list_set to update them.
-func make_frame(parent, env, tail Atom) Atom {
+func (l *Interpreter) make_frame(parent, env, tail Atom) Atom {
 	op, args, body := _nil, _nil, _nil
-	return cons(parent, // depth == 0
-		cons(env, // depth == 1
-			cons(op, // depth == 2
-				cons(tail, // depth == 3
-					cons(args, // depth == 4
-						cons(body, // depth == 5
+	return l.cons(parent, // depth == 0
+		l.cons(env, // depth == 1
+			l.cons(op, // depth == 2
+				l.cons(tail, // depth == 3
+					l.cons(args, // depth == 4
+						l.cons(body, // depth == 5
 							_nil))))))
 }
+
+// stack_copy returns a copy of the frames in a stack.
+// frames are updated in place as expressions are evaluated, so the
+// copy includes the frames and the list of evaluated arguments (which
+// is reversed in place when the function is applied).
+// environments are shared, not copied.
+func (l *Interpreter) stack_copy(stack Atom) Atom {
+	var head, tail Atom
+	for ; !nilp(stack); stack = car(stack) {
+		frame := l.list_copy(stack)
+		frame.value.pair().pos = stack.value.pair().pos
+		if args := list_get(frame, FRAME_ARGS); args._type == AtomType_Pair {
+			list_set(frame, FRAME_ARGS, l.list_copy(args))
+		}
+		if nilp(head) {
+			head = frame
+		} else {
+			setcar(tail, frame)
+		}
+		tail = frame
+	}
+	return head
+}
+
+// frame_pos returns the position of the innermost frame in the stack
+// that has one. it returns nil if none of the frames have a position.
+func frame_pos(stack Atom) *Position {
+	for ; !nilp(stack); stack = car(stack) {
+		if pos := stack.value.pair().pos; pos != nil {
+			return pos
+		}
+	}
+	return nil
+}
+
+// stack_trace returns a description of each frame in the stack,
+// innermost first. a frame is described by the name of its operator
+// and the position of the expression that created it.
+func stack_trace(stack Atom) []string {
+	var trace []string
+	for ; !nilp(stack); stack = car(stack) {
+		name, op := "?", list_get(stack, FRAME_OP)
+		switch op._type {
+		case AtomType_Symbol:
+			name = string(op.value.symbol().label)
+		case AtomType_Builtin, AtomType_Closure, AtomType_Continuation, AtomType_Macro:
+			if name = env_name(list_get(stack, FRAME_ENV), op); name == "" {
+				// anonymous procedure
+				name = "#<" + op._type.String() + ">"
+			}
+		}
+		if pos := stack.value.pair().pos; pos != nil {
+			name += " at " + pos.String()
+		}
+		trace = append(trace, name)
+	}
+	return trace
+}
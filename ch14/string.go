@@ -0,0 +1,267 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lisp
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// String implements data for a string.
+// We define a struct around it so that we can do
+// pointer comparisons for equality in other parts of this package.
+type String struct {
+	text string
+}
+
+// make_string returns an Atom on the stack.
+func make_string(s string) Atom {
+	return Atom{
+		_type: AtomType_String,
+		value: AtomValue{
+			ref: &String{
+				text: s,
+			},
+		},
+	}
+}
+
+// read_string reads a string literal from the input.
+// the input must include the opening and closing quotes.
+// the escapes \", \\, \n, and \t are recognized; anything else is an error.
+func read_string(input []byte, result *Atom) error {
+	if len(input) < 2 || input[0] != '"' || input[len(input)-1] != '"' {
+		// unterminated string
+		return Error_Syntax
+	}
+	sb := &strings.Builder{}
+	for n := 1; n < len(input)-1; n++ {
+		ch := input[n]
+		if ch != '\\' {
+			sb.WriteByte(ch)
+			continue
+		}
+		if n = n + 1; n == len(input)-1 {
+			// escape can't quote the closing quote
+			return Error_Syntax
+		}
+		switch input[n] {
+		case '"':
+			sb.WriteByte('"')
+		case '\\':
+			sb.WriteByte('\\')
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		default:
+			return Error_Syntax
+		}
+	}
+	*result = make_string(sb.String())
+	return nil
+}
+
+// write_string returns a string as a quoted literal that read_string accepts.
+func write_string(s string) []byte {
+	bb := &bytes.Buffer{}
+	bb.WriteByte('"')
+	for n := 0; n < len(s); n++ {
+		switch ch := s[n]; ch {
+		case '"':
+			bb.WriteString(`\"`)
+		case '\\':
+			bb.WriteString(`\\`)
+		case '\n':
+			bb.WriteString(`\n`)
+		case '\t':
+			bb.WriteString(`\t`)
+		default:
+			bb.WriteByte(ch)
+		}
+	}
+	bb.WriteByte('"')
+	return bb.Bytes()
+}
+
+// builtin_number_to_string returns the printed representation of a number.
+// note that the result may not be updated if we find errors.
+func builtin_number_to_string(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	} else if !numberp(car(args)) {
+		return error_type("number", car(args))
+	}
+
+	*result = make_string(car(args).String())
+	return nil
+}
+
+// builtin_string_append returns a new string that joins all the arguments.
+// note that the result may not be updated if we find errors.
+func builtin_string_append(args Atom, result *Atom) error {
+	sb := &strings.Builder{}
+	for ; !nilp(args); args = cdr(args) {
+		if car(args)._type != AtomType_String {
+			return error_type("string", car(args))
+		}
+		sb.WriteString(car(args).value.str().text)
+	}
+
+	*result = make_string(sb.String())
+	return nil
+}
+
+// builtin_string_eq returns #t if two strings contain the same characters.
+// note that the result may not be updated if we find errors.
+func builtin_string_eq(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
+		return error_args("2", args)
+	}
+	a, b := car(args), car(cdr(args))
+	if a._type != AtomType_String {
+		return error_type("string", a)
+	} else if b._type != AtomType_String {
+		return error_type("string", b)
+	}
+
+	if a.value.str().text == b.value.str().text {
+		*result = _true
+	} else {
+		*result = _false
+	}
+	return nil
+}
+
+// builtin_string_length returns the number of characters in a string.
+// note that the result may not be updated if we find errors.
+func builtin_string_length(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	} else if car(args)._type != AtomType_String {
+		return error_type("string", car(args))
+	}
+
+	*result = make_int(utf8.RuneCountInString(car(args).value.str().text))
+	return nil
+}
+
+// builtin_string_less returns #t if the first string sorts before the second.
+// note that the result may not be updated if we find errors.
+func builtin_string_less(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
+		return error_args("2", args)
+	}
+	a, b := car(args), car(cdr(args))
+	if a._type != AtomType_String {
+		return error_type("string", a)
+	} else if b._type != AtomType_String {
+		return error_type("string", b)
+	}
+
+	if a.value.str().text < b.value.str().text {
+		*result = _true
+	} else {
+		*result = _false
+	}
+	return nil
+}
+
+// builtin_string_to_number converts a string to a number.
+// it returns #f if the string is not a number.
+// note that the result may not be updated if we find errors.
+func builtin_string_to_number(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	} else if car(args)._type != AtomType_String {
+		return error_type("string", car(args))
+	}
+
+	if !read_number([]byte(car(args).value.str().text), result) {
+		*result = _false
+	}
+	return nil
+}
+
+// builtin_string_to_symbol returns the symbol with the name given by a string.
+// note that the result may not be updated if we find errors.
+func (l *Interpreter) builtin_string_to_symbol(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	} else if car(args)._type != AtomType_String {
+		return error_type("string", car(args))
+	}
+
+	*result = l.make_sym([]byte(car(args).value.str().text))
+	return nil
+}
+
+// builtin_stringp returns #t if the argument is a string.
+// note that the result may not be updated if we find errors.
+func builtin_stringp(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	}
+
+	*result = make_boolean(car(args)._type == AtomType_String)
+	return nil
+}
+
+// builtin_substring returns the characters of a string from start up to,
+// but not including, end. if end is not given, it defaults to the length
+// of the string. it is an error if start or end is outside the string
+// or if end is before start.
+// note that the result may not be updated if we find errors.
+func builtin_substring(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || nilp(cdr(args)) || !(nilp(cdr(cdr(args))) || nilp(cdr(cdr(cdr(args))))) {
+		return error_args("2 or 3", args)
+	}
+	s, start := car(args), car(cdr(args))
+	if s._type != AtomType_String {
+		return error_type("string", s)
+	} else if start._type == AtomType_Bignum {
+		return error_value(Error_Range, start)
+	} else if start._type != AtomType_Integer {
+		return error_type("integer", start)
+	}
+	runes := []rune(s.value.str().text)
+	if start.value.integer() < 0 || start.value.integer() > len(runes) {
+		return error_value(Error_Range, start)
+	}
+	end := len(runes)
+	if tail := cdr(cdr(args)); !nilp(tail) {
+		if car(tail)._type == AtomType_Bignum {
+			return error_value(Error_Range, car(tail))
+		} else if car(tail)._type != AtomType_Integer {
+			return error_type("integer", car(tail))
+		} else if end = car(tail).value.integer(); end < start.value.integer() || end > len(runes) {
+			return error_value(Error_Range, car(tail))
+		}
+	}
+
+	*result = make_string(string(runes[start.value.integer():end]))
+	return nil
+}
+
+// builtin_symbol_to_string returns the name of a symbol as a string.
+// note that the result may not be updated if we find errors.
+func builtin_symbol_to_string(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	} else if car(args)._type != AtomType_Symbol {
+		return error_type("symbol", car(args))
+	}
+
+	*result = make_string(string(car(args).value.symbol().label))
+	return nil
+}
@@ -2,15 +2,128 @@
 
 package lisp
 
-import "bytes"
+import (
+	"bytes"
+	"strings"
+)
 
 // Symbol implements data for a symbol.
 // We define a struct around it so that we can do
 // pointer comparisons for equality in other parts of this package.
 type Symbol struct {
 	label []byte
+	// mark is used by the garbage collector.
+	mark uint32
+	// alias is set if the symbol was introduced by the expansion of
+	// a SYNTAX-RULES macro.
+	alias *alias
 }
 
 func (s *Symbol) EqualString(str string) bool {
 	return bytes.Equal(s.label, []byte(str))
 }
+
+// form identifies a special form, or a keyword that is special
+// inside one of them.
+type form int
+
+const (
+	form_none form = iota
+	form_and
+	form_apply
+	form_begin
+	form_call_cc
+	form_case
+	form_cond
+	form_define
+	form_define_syntax
+	form_defmacro
+	form_do
+	form_guard
+	form_if
+	form_lambda
+	form_let
+	form_let_star
+	form_let_syntax
+	form_letrec
+	form_or
+	form_quote
+	form_set
+	form_unless
+	form_when
+	// keywords inside special forms
+	form_arrow
+	form_else
+	form_syntax_rules
+)
+
+// form_names are the names of the special forms and keywords.
+var form_names = []struct {
+	name string
+	form form
+}{
+	{"AND", form_and},
+	{"APPLY", form_apply},
+	{"BEGIN", form_begin},
+	{"CALL/CC", form_call_cc},
+	{"CALL-WITH-CURRENT-CONTINUATION", form_call_cc},
+	{"CASE", form_case},
+	{"COND", form_cond},
+	{"DEFINE", form_define},
+	{"DEFINE-SYNTAX", form_define_syntax},
+	{"DEFMACRO", form_defmacro},
+	{"DO", form_do},
+	{"GUARD", form_guard},
+	{"IF", form_if},
+	{"LAMBDA", form_lambda},
+	{"LET", form_let},
+	{"LET*", form_let_star},
+	{"LET-SYNTAX", form_let_syntax},
+	{"LETREC", form_letrec},
+	{"OR", form_or},
+	{"QUOTE", form_quote},
+	{"SET!", form_set},
+	{"UNLESS", form_unless},
+	{"WHEN", form_when},
+	{"=>", form_arrow},
+	{"ELSE", form_else},
+	{"SYNTAX-RULES", form_syntax_rules},
+}
+
+// WithCaseSensitive returns an option that preserves the case of symbols.
+// By default, symbols are converted to upper case, so foo and FOO are the
+// same symbol. When case is preserved, they are different symbols and the
+// names of the builtins, special forms and other symbols that the
+// interpreter uses, like T, are lower case. NIL may be written in any case.
+func WithCaseSensitive() Option {
+	return func(l *Interpreter) {
+		l.case_sensitive = true
+	}
+}
+
+// intern returns the symbol for a name used by the interpreter itself,
+// such as the name of a builtin or special form. the names are written
+// in upper case and are converted to lower case if case is preserved.
+func (l *Interpreter) intern(name string) Atom {
+	if l.case_sensitive {
+		name = strings.ToLower(name)
+	}
+	return l.make_sym([]byte(name))
+}
+
+// form returns the special form or keyword that an atom names.
+// it returns form_none if the atom isn't a symbol for one of them.
+// an alias names the same form as the symbol it was renamed from.
+func (l *Interpreter) form(atom Atom) form {
+	if atom._type != AtomType_Symbol {
+		return form_none
+	}
+	atom = unalias(atom)
+	if l.forms == nil {
+		l.forms = make(map[*Symbol]form)
+		for _, f := range form_names {
+			l.forms[l.intern(f.name).value.symbol()] = f.form
+		}
+	}
+	return l.forms[atom.value.symbol()]
+}
@@ -0,0 +1,447 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lisp
+
+// macros defined with SYNTAX-RULES are hygienic. the symbols that a
+// template introduces are renamed to aliases, which are uninterned
+// symbols that remember the symbol they were renamed from and the macro
+// that introduced them. a binding made by the expansion binds the alias,
+// so it can't capture the caller's variables. an alias that isn't bound
+// by the expansion refers to the binding of its symbol where the macro
+// was defined, so the caller's variables can't capture it either.
+//
+// an alias names the same special form as its symbol, and quoted data
+// in a template isn't renamed.
+//
+// a literal in a pattern matches a symbol with the same binding, so a
+// caller's local variable named ELSE doesn't match the literal ELSE.
+
+// Syntax implements data for a macro defined with SYNTAX-RULES.
+type Syntax struct {
+	// ellipsis is the symbol that follows a repeated pattern or template.
+	ellipsis Atom
+	// literals are the symbols that only match themselves in a pattern.
+	literals []Atom
+	// rules are tried in order until a pattern matches the form.
+	rules []syntax_rule
+	// env is the environment that the macro was defined in.
+	env Atom
+	// scope is the compiler's scope for env. it is nil for the global
+	// environment or if the macro wasn't compiled.
+	scope *scope
+	// mark is used by the garbage collector.
+	mark uint32
+}
+
+// syntax_rule is a pattern and the template for the forms that it matches.
+type syntax_rule struct {
+	pattern, template Atom
+}
+
+// alias is the symbol that an alias was renamed from and the macro
+// whose expansion introduced it.
+type alias struct {
+	symbol Atom
+	syntax *Syntax
+}
+
+// unalias returns the symbol that an alias was renamed from, following
+// aliases of aliases. any other atom is returned as it is.
+func unalias(atom Atom) Atom {
+	for atom._type == AtomType_Symbol && atom.value.symbol().alias != nil {
+		atom = atom.value.symbol().alias.symbol
+	}
+	return atom
+}
+
+// make_syntax returns a macro for a SYNTAX-RULES form that is defined in
+// the environment env and the compiler's scope s.
+// spec is (SYNTAX-RULES [ellipsis] (literal...) (pattern template)...).
+func (l *Interpreter) make_syntax(spec, env Atom, s *scope) (Atom, error) {
+	if spec._type != AtomType_Pair || !listp(spec) || l.form(car(spec)) != form_syntax_rules {
+		return _nil, error_value(Error_Syntax, spec)
+	}
+	x := &Syntax{ellipsis: l.intern("..."), env: env, scope: s}
+	args := cdr(spec)
+	if !nilp(args) && car(args)._type == AtomType_Symbol {
+		x.ellipsis, args = car(args), cdr(args)
+	}
+	if nilp(args) || !listp(car(args)) {
+		return _nil, error_value(Error_Syntax, spec)
+	}
+	for literals := car(args); !nilp(literals); literals = cdr(literals) {
+		if car(literals)._type != AtomType_Symbol {
+			return _nil, error_type("symbol", car(literals))
+		}
+		x.literals = append(x.literals, car(literals))
+	}
+	for rules := cdr(args); !nilp(rules); rules = cdr(rules) {
+		// the pattern starts with the keyword, which isn't matched
+		rule := car(rules)
+		if rule._type != AtomType_Pair || !listp(rule) || car(rule)._type != AtomType_Pair || nilp(cdr(rule)) || !nilp(cdr(cdr(rule))) {
+			return _nil, error_value(Error_Syntax, rule)
+		}
+		x.rules = append(x.rules, syntax_rule{pattern: car(rule), template: car(cdr(rule))})
+	}
+	return Atom{_type: AtomType_Syntax, value: AtomValue{ref: x}}, nil
+}
+
+// expand_syntax returns the expansion of a form that uses a macro.
+// args are the rest of the form after the macro's keyword.
+// env and s are the environment and compiler's scope where the form is.
+// it returns an error if no pattern matches the form.
+func (l *Interpreter) expand_syntax(x *Syntax, args, env Atom, s *scope) (Atom, error) {
+	m := &syntax_matcher{Syntax: x, l: l, env: env, scope: s}
+	for _, rule := range x.rules {
+		b := syntax_bindings{}
+		if m.match_list(cdr(rule.pattern), args, b) {
+			e := &syntax_expander{l: l, syntax: x, renamed: make(map[*Symbol]Atom)}
+			return e.expand(rule.template, b, false)
+		}
+	}
+	return _nil, error_value(Error_Syntax, args)
+}
+
+// same_symbol returns true if both atoms are symbols that are the same
+// once aliases are removed.
+func same_symbol(a, b Atom) bool {
+	return a._type == AtomType_Symbol && b._type == AtomType_Symbol && unalias(a).value.symbol() == unalias(b).value.symbol()
+}
+
+// ellipsisp returns true if the atom is the macro's ellipsis.
+func (x *Syntax) ellipsisp(atom Atom) bool {
+	return same_symbol(atom, x.ellipsis)
+}
+
+// literalp returns true if the atom is one of the macro's literals.
+func (x *Syntax) literalp(atom Atom) bool {
+	for _, literal := range x.literals {
+		if same_symbol(atom, literal) {
+			return true
+		}
+	}
+	return false
+}
+
+// underscorep returns true if the atom is _, which matches anything
+// without binding it.
+func underscorep(atom Atom) bool {
+	return atom._type == AtomType_Symbol && unalias(atom).value.symbol().EqualString("_")
+}
+
+// syntax_matcher holds the state for matching a form against patterns.
+type syntax_matcher struct {
+	*Syntax
+	l *Interpreter
+	// env and scope are where the form that uses the macro is.
+	env   Atom
+	scope *scope
+}
+
+// scope_binding is a variable or macro bound in a compiler's scope.
+type scope_binding struct {
+	scope *scope
+	name  *Symbol
+}
+
+// symbol_binding returns the binding for a symbol in the compiler's
+// scope s or, if the scope doesn't bind it, the environment env.
+// the result is only compared with other bindings. it is nil if the
+// symbol is unbound.
+func (l *Interpreter) symbol_binding(sym, env Atom, s *scope) any {
+	for t := s; t != nil; t = t.parent {
+		if _, ok := t.macros[sym.value.symbol()]; ok || t.index(sym) >= 0 {
+			return scope_binding{scope: t, name: sym.value.symbol()}
+		}
+	}
+	if a := sym.value.symbol().alias; a != nil && a.syntax.scope != nil {
+		return l.symbol_binding(a.symbol, a.syntax.env, a.syntax.scope)
+	} else if value := l.env_lookup(env, sym); value != nil {
+		return value
+	}
+	return nil
+}
+
+// match_literal returns true if the input is a symbol with the same
+// binding as a literal. the literal is looked up where the macro was
+// defined and the input where the form is. if both are unbound, they
+// must have the same name.
+func (m *syntax_matcher) match_literal(literal, input Atom) bool {
+	if input._type != AtomType_Symbol {
+		return false
+	}
+	b := m.l.symbol_binding(input, m.env, m.scope)
+	if b != m.l.symbol_binding(literal, m.Syntax.env, m.Syntax.scope) {
+		return false
+	}
+	return b != nil || same_symbol(literal, input)
+}
+
+// syntax_binding is the input matched by a pattern variable. a variable
+// in a pattern that is followed by an ellipsis matches a sequence, which
+// has a binding for each repetition.
+type syntax_binding struct {
+	atom     Atom
+	sequence bool
+	items    []*syntax_binding
+}
+
+// syntax_bindings maps the pattern variables to the input they matched.
+type syntax_bindings map[*Symbol]*syntax_binding
+
+// match returns true if the input matches the pattern.
+// it adds the bindings for the pattern variables.
+func (m *syntax_matcher) match(pattern, input Atom, b syntax_bindings) bool {
+	switch pattern._type {
+	case AtomType_Symbol:
+		if m.literalp(pattern) {
+			return m.match_literal(pattern, input)
+		} else if !underscorep(pattern) {
+			b[pattern.value.symbol()] = &syntax_binding{atom: input}
+		}
+		return true
+	case AtomType_Pair:
+		return m.match_list(pattern, input, b)
+	case AtomType_Vector:
+		return input._type == AtomType_Vector && m.match_list(vector_list(pattern), vector_list(input), b)
+	}
+	return equalp(pattern, input)
+}
+
+// match_list returns true if the input matches a list pattern.
+// a pattern followed by an ellipsis matches the items that aren't
+// needed for the patterns after the ellipsis.
+func (m *syntax_matcher) match_list(pattern, input Atom, b syntax_bindings) bool {
+	for pattern._type == AtomType_Pair {
+		if rest := cdr(pattern); rest._type == AtomType_Pair && m.ellipsisp(car(rest)) {
+			rest = cdr(rest)
+			n := pairs(input) - pairs(rest)
+			if n < 0 {
+				return false
+			}
+			vars := m.pattern_vars(car(pattern), nil)
+			for _, v := range vars {
+				b[v] = &syntax_binding{sequence: true}
+			}
+			for ; n != 0; n-- {
+				item := syntax_bindings{}
+				if !m.match(car(pattern), car(input), item) {
+					return false
+				}
+				for _, v := range vars {
+					b[v].items = append(b[v].items, item[v])
+				}
+				input = cdr(input)
+			}
+			pattern = rest
+			continue
+		} else if input._type != AtomType_Pair || !m.match(car(pattern), car(input), b) {
+			return false
+		}
+		pattern, input = cdr(pattern), cdr(input)
+	}
+	// the tail of the pattern matches the rest of the input
+	return m.match(pattern, input, b)
+}
+
+// pattern_vars appends the pattern variables in a pattern to vars.
+func (x *Syntax) pattern_vars(pattern Atom, vars []*Symbol) []*Symbol {
+	switch pattern._type {
+	case AtomType_Symbol:
+		if !x.literalp(pattern) && !x.ellipsisp(pattern) && !underscorep(pattern) {
+			vars = append(vars, pattern.value.symbol())
+		}
+	case AtomType_Pair:
+		for ; pattern._type == AtomType_Pair; pattern = cdr(pattern) {
+			vars = x.pattern_vars(car(pattern), vars)
+		}
+		vars = x.pattern_vars(pattern, vars)
+	case AtomType_Vector:
+		for _, item := range pattern.value.vector().items {
+			vars = x.pattern_vars(item, vars)
+		}
+	}
+	return vars
+}
+
+// pairs returns the number of pairs in a list, which may be improper.
+func pairs(list Atom) int {
+	n := 0
+	for ; list._type == AtomType_Pair; list = cdr(list) {
+		n++
+	}
+	return n
+}
+
+// vector_list returns a list of the items in a vector.
+// its cells aren't allocated from the managed heap, so it must only
+// be used while matching.
+func vector_list(vector Atom) Atom {
+	list, items := _nil, vector.value.vector().items
+	for n := len(items) - 1; n >= 0; n-- {
+		list = cons(items[n], list)
+	}
+	return list
+}
+
+// syntax_expander holds the state for instantiating a template.
+type syntax_expander struct {
+	l      *Interpreter
+	syntax *Syntax
+	// renamed maps the symbols introduced by the template to their aliases.
+	renamed map[*Symbol]Atom
+	// escaped is true inside (... template), where the ellipsis
+	// is an ordinary symbol.
+	escaped bool
+}
+
+// expand returns the instantiation of a template with the bindings.
+// symbols that aren't pattern variables are renamed unless they are quoted.
+func (e *syntax_expander) expand(t Atom, b syntax_bindings, quoted bool) (Atom, error) {
+	switch t._type {
+	case AtomType_Symbol:
+		if v, ok := b[t.value.symbol()]; ok {
+			if v.sequence {
+				// the variable must be followed by an ellipsis
+				return _nil, error_value(Error_Syntax, t)
+			}
+			return v.atom, nil
+		} else if quoted {
+			return t, nil
+		}
+		return e.rename(t), nil
+	case AtomType_Pair:
+		if !e.escaped && e.syntax.ellipsisp(car(t)) && cdr(t)._type == AtomType_Pair {
+			e.escaped = true
+			defer func() {
+				e.escaped = false
+			}()
+			return e.expand(car(cdr(t)), b, quoted)
+		} else if e.l.form(car(t)) == form_quote {
+			if _, ok := b[car(t).value.symbol()]; !ok {
+				quoted = true
+			}
+		}
+		var items []Atom
+		for ; t._type == AtomType_Pair; t = cdr(t) {
+			depth := 0
+			for next := cdr(t); !e.escaped && next._type == AtomType_Pair && e.syntax.ellipsisp(car(next)); next = cdr(next) {
+				depth++
+			}
+			if depth == 0 {
+				item, err := e.expand(car(t), b, quoted)
+				if err != nil {
+					return _nil, err
+				}
+				items = append(items, item)
+				continue
+			}
+			repeated, err := e.repeat(car(t), b, depth, quoted)
+			if err != nil {
+				return _nil, err
+			}
+			items = append(items, repeated...)
+			for ; depth != 0; depth-- {
+				t = cdr(t)
+			}
+		}
+		list, err := e.expand(t, b, quoted)
+		if err != nil {
+			return _nil, err
+		}
+		for n := len(items) - 1; n >= 0; n-- {
+			list = e.l.cons(items[n], list)
+		}
+		return list, nil
+	case AtomType_Vector:
+		list, err := e.expand(vector_list(t), b, quoted)
+		if err != nil {
+			return _nil, err
+		}
+		return list_to_vector(list)
+	}
+	return t, nil
+}
+
+// repeat returns the instantiations of a template that is followed by
+// depth ellipses, one for each item in the sequences that its pattern
+// variables are bound to. the sequences must be the same length.
+func (e *syntax_expander) repeat(t Atom, b syntax_bindings, depth int, quoted bool) ([]Atom, error) {
+	var vars []*Symbol
+	for _, v := range e.template_vars(t, b, nil) {
+		if b[v].sequence {
+			if len(vars) != 0 && len(b[v].items) != len(b[vars[0]].items) {
+				return nil, error_value(Error_Syntax, t)
+			}
+			vars = append(vars, v)
+		}
+	}
+	if len(vars) == 0 {
+		// there is nothing to repeat
+		return nil, error_value(Error_Syntax, t)
+	}
+
+	var items []Atom
+	for n := range b[vars[0]].items {
+		item := make(syntax_bindings, len(b))
+		for k, v := range b {
+			item[k] = v
+		}
+		for _, v := range vars {
+			item[v] = b[v].items[n]
+		}
+		if depth > 1 {
+			more, err := e.repeat(t, item, depth-1, quoted)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, more...)
+			continue
+		}
+		expansion, err := e.expand(t, item, quoted)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, expansion)
+	}
+	return items, nil
+}
+
+// template_vars appends the pattern variables used in a template to vars.
+func (e *syntax_expander) template_vars(t Atom, b syntax_bindings, vars []*Symbol) []*Symbol {
+	switch t._type {
+	case AtomType_Symbol:
+		if _, ok := b[t.value.symbol()]; ok {
+			vars = append(vars, t.value.symbol())
+		}
+	case AtomType_Pair:
+		for ; t._type == AtomType_Pair; t = cdr(t) {
+			vars = e.template_vars(car(t), b, vars)
+		}
+		vars = e.template_vars(t, b, vars)
+	case AtomType_Vector:
+		for _, item := range t.value.vector().items {
+			vars = e.template_vars(item, b, vars)
+		}
+	}
+	return vars
+}
+
+// rename returns the alias for a symbol introduced by the template.
+// the symbol has the same alias everywhere in an expansion.
+func (e *syntax_expander) rename(sym Atom) Atom {
+	if renamed, ok := e.renamed[sym.value.symbol()]; ok {
+		return renamed
+	}
+	renamed := Atom{
+		_type: AtomType_Symbol,
+		value: AtomValue{
+			ref: &Symbol{
+				label: sym.value.symbol().label,
+				alias: &alias{symbol: sym, syntax: e.syntax},
+			},
+		},
+	}
+	e.renamed[sym.value.symbol()] = renamed
+	return renamed
+}
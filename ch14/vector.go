@@ -0,0 +1,285 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lisp
+
+import "io"
+
+// Vector implements data for a vector.
+// The items are kept in a slice, so they can be indexed in constant time.
+type Vector struct {
+	items []Atom
+	// mark is used by the garbage collector.
+	mark uint32
+}
+
+// max_vector_length is the largest vector that MAKE-VECTOR will create.
+const max_vector_length = 1 << 24
+
+// make_vector returns an Atom on the stack.
+// the vector uses the slice of items; it doesn't copy it.
+func make_vector(items []Atom) Atom {
+	return Atom{
+		_type: AtomType_Vector,
+		value: AtomValue{
+			ref: &Vector{
+				items: items,
+			},
+		},
+	}
+}
+
+// list_to_vector returns a vector with the items in a list.
+// it returns an error if the list is not a proper list.
+func list_to_vector(list Atom) (Atom, error) {
+	if !listp(list) {
+		return _nil, error_type("list", list)
+	}
+	var items []Atom
+	for ; !nilp(list); list = cdr(list) {
+		items = append(items, car(list))
+	}
+	return make_vector(items), nil
+}
+
+// write_vector writes a vector as #(item ...).
+func write_vector(w io.Writer, v *Vector) (int, error) {
+	totalBytesWritten, err := w.Write([]byte{'#', '('})
+	if err != nil {
+		return totalBytesWritten, err
+	}
+	for n, item := range v.items {
+		if n != 0 {
+			bytesWritten, err := w.Write([]byte{' '})
+			totalBytesWritten += bytesWritten
+			if err != nil {
+				return totalBytesWritten, err
+			}
+		}
+		bytesWritten, err := item.Write(w)
+		totalBytesWritten += bytesWritten
+		if err != nil {
+			return totalBytesWritten, err
+		}
+	}
+	bytesWritten, err := w.Write([]byte{')'})
+	return totalBytesWritten + bytesWritten, err
+}
+
+// vector_index returns the index for a vector.
+// it returns an error if the index is not an integer or if it is
+// outside the vector.
+func vector_index(v *Vector, index Atom) (int, error) {
+	if index._type == AtomType_Bignum {
+		return 0, error_value(Error_Range, index)
+	} else if index._type != AtomType_Integer {
+		return 0, error_type("integer", index)
+	} else if index.value.integer() < 0 || index.value.integer() >= len(v.items) {
+		return 0, error_value(Error_Range, index)
+	}
+	return index.value.integer(), nil
+}
+
+// builtin_list_to_vector returns a vector with the items in a list.
+// note that the result may not be updated if we find errors.
+func builtin_list_to_vector(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	}
+
+	vector, err := list_to_vector(car(args))
+	if err != nil {
+		return err
+	}
+	*result = vector
+	return nil
+}
+
+// builtin_make_vector returns a vector with k items.
+// the items are set to the fill value, or to NIL if it isn't given.
+// it is an error if k is negative or larger than max_vector_length.
+// note that the result may not be updated if we find errors.
+func builtin_make_vector(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !(nilp(cdr(args)) || nilp(cdr(cdr(args)))) {
+		return error_args("1 or 2", args)
+	}
+	k, fill := car(args), _nil
+	if !nilp(cdr(args)) {
+		fill = car(cdr(args))
+	}
+	if k._type == AtomType_Bignum {
+		return error_value(Error_Range, k)
+	} else if k._type != AtomType_Integer {
+		return error_type("integer", k)
+	} else if k.value.integer() < 0 || k.value.integer() > max_vector_length {
+		return error_value(Error_Range, k)
+	}
+
+	items := make([]Atom, k.value.integer())
+	for n := range items {
+		items[n] = fill
+	}
+	*result = make_vector(items)
+	return nil
+}
+
+// builtin_vector returns a vector with the arguments as its items.
+func builtin_vector(args Atom, result *Atom) error {
+	// the arguments are always a proper list
+	vector, err := list_to_vector(args)
+	if err != nil {
+		return err
+	}
+	*result = vector
+	return nil
+}
+
+// builtin_vectorp returns #t if the argument is a vector.
+// note that the result may not be updated if we find errors.
+func builtin_vectorp(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	}
+
+	*result = make_boolean(car(args)._type == AtomType_Vector)
+	return nil
+}
+
+// builtin_vector_fill sets every item in a vector to a value.
+// it returns NIL.
+// note that the result may not be updated if we find errors.
+func builtin_vector_fill(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
+		return error_args("2", args)
+	} else if car(args)._type != AtomType_Vector {
+		return error_type("vector", car(args))
+	}
+
+	v, fill := car(args).value.vector(), car(cdr(args))
+	for n := range v.items {
+		v.items[n] = fill
+	}
+	*result = _nil
+	return nil
+}
+
+// builtin_vector_length returns the number of items in a vector.
+// note that the result may not be updated if we find errors.
+func builtin_vector_length(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	} else if car(args)._type != AtomType_Vector {
+		return error_type("vector", car(args))
+	}
+
+	*result = make_int(len(car(args).value.vector().items))
+	return nil
+}
+
+// builtin_vector_map returns a vector with the results of calling a
+// procedure with the items at each index of the vectors. the result is
+// as long as the shortest vector.
+// note that the result may not be updated if we find errors.
+func (l *Interpreter) builtin_vector_map(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || nilp(cdr(args)) {
+		return error_args("at least 2", args)
+	}
+	proc, n := car(args), -1
+	var vectors []*Vector
+	for p := cdr(args); !nilp(p); p = cdr(p) {
+		if car(p)._type != AtomType_Vector {
+			return error_type("vector", car(p))
+		}
+		v := car(p).value.vector()
+		if n == -1 || len(v.items) < n {
+			n = len(v.items)
+		}
+		vectors = append(vectors, v)
+	}
+
+	// the results must survive garbage collection while the procedure runs
+	items := make([]Atom, n)
+	mapped := make_vector(items)
+	l.roots = append(l.roots, &mapped)
+	defer func() {
+		l.roots = l.roots[:len(l.roots)-1]
+	}()
+
+	// (APPLY 'proc '(item...))
+	quote, arg_list := l.intern("QUOTE"), make([]Atom, len(vectors))
+	for i := range items {
+		for k, v := range vectors {
+			arg_list[k] = v.items[i]
+		}
+		expr := l.list_of(l.intern("APPLY"), l.list_of(quote, proc), l.list_of(quote, l.list_of(arg_list...)))
+		if err := l.eval_expr(expr, l.env, &items[i]); err != nil {
+			return err
+		}
+	}
+	*result = mapped
+	return nil
+}
+
+// builtin_vector_ref returns the k'th item in a vector.
+// note that the result may not be updated if we find errors.
+func builtin_vector_ref(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || nilp(cdr(args)) || !nilp(cdr(cdr(args))) {
+		return error_args("2", args)
+	} else if car(args)._type != AtomType_Vector {
+		return error_type("vector", car(args))
+	}
+
+	v := car(args).value.vector()
+	k, err := vector_index(v, car(cdr(args)))
+	if err != nil {
+		return err
+	}
+	*result = v.items[k]
+	return nil
+}
+
+// builtin_vector_set updates the k'th item in a vector.
+// it returns NIL.
+// note that the result may not be updated if we find errors.
+func builtin_vector_set(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || nilp(cdr(args)) || nilp(cdr(cdr(args))) || !nilp(cdr(cdr(cdr(args)))) {
+		return error_args("3", args)
+	} else if car(args)._type != AtomType_Vector {
+		return error_type("vector", car(args))
+	}
+
+	v := car(args).value.vector()
+	k, err := vector_index(v, car(cdr(args)))
+	if err != nil {
+		return err
+	}
+	v.items[k] = car(cdr(cdr(args)))
+	*result = _nil
+	return nil
+}
+
+// builtin_vector_to_list returns a list with the items in a vector.
+// its cells are allocated from the managed heap if it is enabled.
+// note that the result may not be updated if we find errors.
+func (l *Interpreter) builtin_vector_to_list(args Atom, result *Atom) error {
+	// verify number and type of arguments
+	if nilp(args) || !nilp(cdr(args)) {
+		return error_args("1", args)
+	} else if car(args)._type != AtomType_Vector {
+		return error_type("vector", car(args))
+	}
+
+	list, items := _nil, car(args).value.vector().items
+	for n := len(items) - 1; n >= 0; n-- {
+		list = l.cons(items[n], list)
+	}
+	*result = list
+	return nil
+}
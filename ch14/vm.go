@@ -0,0 +1,353 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package lisp
+
+// this file implements the virtual machine that runs the bytecode
+// from compile.go.
+//
+// each call to a compiled procedure gets a frame with its own stack
+// of values. frames are linked to the frame waiting for their result
+// instead of living on the Go stack, so deep recursion doesn't grow
+// the Go stack and a continuation can copy the frames that it needs.
+//
+// each call also gets an environment with a slot for every argument
+// and for every name defined in the body. closures keep the environment
+// they were created in, so it isn't copied by a continuation.
+
+// vm_frame is a call to a compiled procedure, or an expression
+// compiled at the top level.
+type vm_frame struct {
+	// parent is the frame waiting for the result.
+	// it is nil for the frame that started the run.
+	parent *vm_frame
+	// code is being run and pc is the next instruction.
+	code *code
+	pc   int
+	// env is the environment for the instructions.
+	// it is nil at the top level.
+	env *vm_env
+	// stack holds the values that the instructions are working on.
+	stack []Atom
+	// handlers are the GUARD forms that are waiting for errors.
+	handlers []vm_handler
+	// op is the procedure being called, or NIL at the top level.
+	op Atom
+}
+
+// vm_handler is where a frame continues if an error is raised.
+type vm_handler struct {
+	pc, sp int
+	env    *vm_env
+}
+
+// vm_env is the environment for a call to a compiled procedure,
+// or for the clauses of a GUARD form.
+type vm_env struct {
+	parent *vm_env
+	// slots hold the values of the local variables.
+	slots []Atom
+	// mark is used by the garbage collector.
+	mark uint32
+}
+
+// vm_env_create returns an environment with the given number of slots.
+// the slots are unbound.
+func vm_env_create(parent *vm_env, slots int) *vm_env {
+	env := &vm_env{parent: parent, slots: make([]Atom, slots)}
+	for i := range env.slots {
+		env.slots[i] = _unbound
+	}
+	return env
+}
+
+// WithBytecode returns an option that compiles expressions to bytecode
+// and runs them on a virtual machine instead of walking them.
+func WithBytecode() Option {
+	return func(l *Interpreter) {
+		l.bytecode = true
+	}
+}
+
+// push adds a value to the top of the frame's stack.
+func (f *vm_frame) push(value Atom) {
+	f.stack = append(f.stack, value)
+}
+
+// pop removes the value on the top of the frame's stack and returns it.
+func (f *vm_frame) pop() Atom {
+	n := len(f.stack) - 1
+	value := f.stack[n]
+	f.stack = f.stack[:n]
+	return value
+}
+
+// vm_copy returns a copy of the frames, so that running
+// the copy doesn't change the original.
+func vm_copy(f *vm_frame) *vm_frame {
+	var head, tail *vm_frame
+	for ; f != nil; f = f.parent {
+		g := &vm_frame{}
+		*g = *f
+		g.stack = append([]Atom(nil), f.stack...)
+		g.handlers = append([]vm_handler(nil), f.handlers...)
+		if head == nil {
+			head = g
+		} else {
+			tail.parent = g
+		}
+		tail = g
+	}
+	return head
+}
+
+// vm_eval compiles an expression and runs it in the environment.
+// note that the result is not updated if there are errors.
+func (l *Interpreter) vm_eval(expr, env Atom, result *Atom) error {
+	value, err := l.vm_run(&vm_frame{code: l.compile(expr, env)})
+	if err != nil {
+		return err
+	}
+	*result = value
+	return nil
+}
+
+// vm_run runs instructions until the first frame returns.
+// errors are passed to the innermost handler. if there isn't one,
+// the error is returned.
+func (l *Interpreter) vm_run(f *vm_frame) (Atom, error) {
+	l.frames = append(l.frames, &f)
+	defer func() {
+		l.frames = l.frames[:len(l.frames)-1]
+	}()
+
+	for {
+		if l.gc_needed() {
+			l.gc()
+		}
+
+		var err error
+		in := f.code.instrs[f.pc]
+		f.pc++
+		switch in.op {
+		case op_const:
+			f.push(f.code.constants[in.arg])
+		case op_local:
+			r, env := f.code.refs[in.arg], f.env
+			for depth := r.depth; depth != 0; depth-- {
+				env = env.parent
+			}
+			if value := env.slots[r.index]; value.value.symbol() != unbound {
+				f.push(value)
+			} else if err = l.env_get(f.code.globals, r.name, &value); err == nil {
+				// the name is used before it is defined, so use the global
+				f.push(value)
+			}
+		case op_global:
+			var value Atom
+			if err = l.env_get(f.code.globals, f.code.constants[in.arg], &value); err == nil {
+				f.push(value)
+			}
+		case op_define_local:
+			r := f.code.refs[in.arg]
+			f.env.slots[r.index] = f.pop()
+			f.push(r.name)
+		case op_define_global:
+			sym := f.code.constants[in.arg]
+			_ = l.env_set(f.code.globals, sym, f.pop())
+			f.push(sym)
+		case op_set_local:
+			r, env := f.code.refs[in.arg], f.env
+			for depth := r.depth; depth != 0; depth-- {
+				env = env.parent
+			}
+			if value := f.pop(); env.slots[r.index].value.symbol() != unbound {
+				env.slots[r.index] = value
+				f.push(r.name)
+			} else if err = l.env_update(f.code.globals, r.name, value); err == nil {
+				// the name is set before it is defined, so update the global
+				f.push(r.name)
+			}
+		case op_set_global:
+			sym := f.code.constants[in.arg]
+			if err = l.env_update(f.code.globals, sym, f.pop()); err == nil {
+				f.push(sym)
+			}
+		case op_pop:
+			f.pop()
+		case op_dup:
+			f.push(f.stack[len(f.stack)-1])
+		case op_swap:
+			n := len(f.stack)
+			f.stack[n-1], f.stack[n-2] = f.stack[n-2], f.stack[n-1]
+		case op_jump:
+			f.pc = int(in.arg)
+		case op_jump_if_false:
+			if l.falsep(f.pop()) {
+				f.pc = int(in.arg)
+			}
+		case op_closure, op_macro:
+			lambda := f.code.lambdas[in.arg]
+			closure := Atom{_type: AtomType_Closure, value: AtomValue{ref: &vm_closure{lambda: lambda, env: f.env}}}
+			if in.op == op_macro {
+				closure._type = AtomType_Macro
+			}
+			f.push(closure)
+		case op_call, op_tail_call:
+			// the arguments are on the top of the stack, with the procedure under them
+			n := len(f.stack) - int(in.arg)
+			args := _nil
+			for i := len(f.stack) - 1; i >= n; i-- {
+				args = l.cons(f.stack[i], args)
+			}
+			op := f.stack[n-1]
+			f.stack = f.stack[:n-1]
+			f, err = l.vm_call(f, op, args, in.op == op_tail_call)
+		case op_apply:
+			args := f.pop()
+			op := f.pop()
+			if !listp(args) {
+				err = error_value(Error_Syntax, args)
+			} else {
+				f, err = l.vm_call(f, op, args, in.arg == 1)
+			}
+		case op_call_cc:
+			// the continuation is a copy of this frame, which will
+			// push the value that it is called with.
+			op := f.pop()
+			k := Atom{_type: AtomType_Continuation, value: AtomValue{ref: &Continuation{frames: vm_copy(f)}}}
+			f, err = l.vm_call(f, op, l.cons(k, _nil), in.arg == 1)
+		case op_guard:
+			f.handlers = append(f.handlers, vm_handler{pc: int(in.arg), sp: len(f.stack), env: f.env})
+		case op_unguard:
+			f.handlers = f.handlers[:len(f.handlers)-1]
+		case op_bind:
+			env := vm_env_create(f.env, int(in.arg))
+			env.slots[0] = f.pop()
+			f.env = env
+		case op_unbind:
+			f.env = f.env.parent
+		case op_raise:
+			var result Atom
+			err = builtin_raise(l.cons(f.pop(), _nil), &result)
+		case op_error:
+			err = f.code.errors[in.arg]
+		case op_return:
+			value := f.pop()
+			if f = f.parent; f == nil {
+				return value, nil
+			}
+			f.push(value)
+		}
+
+		if err != nil {
+			if f, err = l.vm_raise(f, err); err != nil {
+				return _nil, err
+			}
+		}
+	}
+}
+
+// vm_call calls a procedure with a list of arguments and returns the
+// frame to continue with. if tail is true, a closure replaces the frame
+// instead of returning to it.
+// on error, it returns the frame it was given.
+func (l *Interpreter) vm_call(f *vm_frame, op, args Atom, tail bool) (*vm_frame, error) {
+	switch op._type {
+	case AtomType_Builtin:
+		var result Atom
+		if err := op.value.builtin().fn(args, &result); err != nil {
+			return f, error_in(err, op, f.code.globals)
+		}
+		f.push(result)
+		return f, nil
+	case AtomType_Continuation:
+		// verify number of arguments
+		if nilp(args) || !nilp(cdr(args)) {
+			return f, error_args("1", args)
+		}
+		// abandon the current frames and reinstate a copy of the captured ones
+		k := vm_copy(op.value.continuation().frames)
+		k.push(car(args))
+		return k, nil
+	case AtomType_Closure:
+		lambda := op.value.lambda()
+		if lambda == nil {
+			// the closure was created by the evaluator
+			return f, error_type("procedure", op)
+		}
+		if lambda.code == nil {
+			lambda.code = l.compile_lambda(lambda)
+		}
+		env := vm_env_create(op.value.env(), lambda.code.slots)
+		// bind the arguments to the first slots
+		arg_names, rest := lambda.args, args
+		for i := 0; !nilp(arg_names); i++ {
+			if arg_names._type == AtomType_Symbol {
+				env.slots[i] = rest
+				rest = _nil
+				break
+			} else if nilp(rest) {
+				// it is an error if we have too few arguments
+				return f, error_args(arity(lambda.args), args)
+			}
+			env.slots[i] = car(rest)
+			arg_names, rest = cdr(arg_names), cdr(rest)
+		}
+		if !nilp(rest) {
+			// it is an error if we have too many arguments
+			return f, error_args(arity(lambda.args), args)
+		}
+		parent := f
+		if tail {
+			parent = f.parent
+		}
+		return &vm_frame{parent: parent, code: lambda.code, env: env, op: op}, nil
+	}
+	return f, error_type("procedure", op)
+}
+
+// vm_raise passes an error to the innermost handler and returns the
+// frame that the handler is in. the raised value is pushed on that
+// frame's stack. if there isn't a handler, it returns the error.
+// errors are reported at the position of the current instruction.
+func (l *Interpreter) vm_raise(f *vm_frame, err error) (*vm_frame, error) {
+	err = error_at(err, f.code.pos[f.pc-1])
+	if e := lisp_error(err); e.Stack == nil {
+		e.Stack = vm_trace(f)
+		err = e
+	}
+	for ; f != nil; f = f.parent {
+		if n := len(f.handlers); n != 0 {
+			h := f.handlers[n-1]
+			f.handlers = f.handlers[:n-1]
+			f.stack, f.env, f.pc = f.stack[:h.sp], h.env, h.pc
+			f.push(condition_of(err))
+			return f, nil
+		}
+	}
+	return nil, err
+}
+
+// vm_trace returns a description of each procedure call in the frames,
+// innermost first. a call is described by the name of the procedure
+// and the position of the expression that called it.
+func vm_trace(f *vm_frame) []string {
+	var trace []string
+	for ; f != nil; f = f.parent {
+		if nilp(f.op) {
+			continue
+		}
+		name := env_name(f.op.value.lambda().globals, f.op)
+		if name == "" {
+			// anonymous procedure
+			name = "#<" + f.op._type.String() + ">"
+		}
+		if p := f.parent; p != nil {
+			if pos := p.code.pos[p.pc-1]; pos != nil {
+				name += " at " + pos.String()
+			}
+		}
+		trace = append(trace, name)
+	}
+	return trace
+}
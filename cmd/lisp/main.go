@@ -0,0 +1,313 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+// Package main implements a command that runs Lisp scripts or starts
+// an interactive REPL.
+//
+// Usage:
+//
+//	lisp [-bytecode] [-case-sensitive] [-history file] [-no-library] [file ...]
+//
+// The standard library is loaded first. Then each file named on the
+// command line is loaded in order. If no files are named, lisp reads
+// expressions from standard input, evaluates them, and prints the result.
+//
+// The REPL loads the history file when it starts and appends each input
+// it reads to the file as a single line. When standard input is a
+// terminal, lines can be edited and earlier inputs, including those from
+// previous sessions, can be recalled with the arrow keys or searched
+// with Ctrl-R.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	lisp "github.com/maloquacious/building_lisp/ch14"
+	"github.com/peterh/liner"
+)
+
+func main() {
+	bytecode := flag.Bool("bytecode", false, "compile expressions to bytecode instead of walking them")
+	caseSensitive := flag.Bool("case-sensitive", false, "preserve the case of symbols")
+	historyFile := flag.String("history", defaultHistoryFile(), "file to load and append REPL input to (empty to disable)")
+	noLibrary := flag.Bool("no-library", false, "do not load the standard library")
+	flag.Parse()
+
+	var opts []lisp.Option
+	if *bytecode {
+		opts = append(opts, lisp.WithBytecode())
+	}
+	if *caseSensitive {
+		opts = append(opts, lisp.WithCaseSensitive())
+	}
+	l := lisp.NewInterpreter(opts...)
+	if !*noLibrary {
+		if err := l.LoadLibrary(); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// run any scripts named on the command line
+	if flag.NArg() != 0 {
+		for _, path := range flag.Args() {
+			if err := l.LoadFile(path); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
+	// edit lines with liner when reading from a terminal
+	var in lineReader = newScanReader(os.Stdin, os.Stdout)
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		line := liner.NewLiner()
+		line.SetCtrlCAborts(true)
+		in = &linerReader{line: line}
+	}
+	err := repl(l, in, os.Stdout, *historyFile)
+	_ = in.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// lineReader reads the REPL's input a line at a time.
+type lineReader interface {
+	// ReadLine prints the prompt and returns the next line of input
+	// without its newline. it returns io.EOF at the end of the input
+	// and errAborted if the user abandons the current input.
+	ReadLine(prompt string) (string, error)
+	// AddHistory makes an input available for recall.
+	AddHistory(input string)
+	// Close restores the terminal.
+	Close() error
+}
+
+// errAborted is returned by ReadLine when the user abandons the input.
+var errAborted = errors.New("aborted")
+
+// scanReader reads lines from input that isn't a terminal.
+// it can't recall history, so AddHistory does nothing.
+type scanReader struct {
+	scanner *bufio.Scanner
+	w       io.Writer
+}
+
+// newScanReader returns a reader for the lines in r that prints its
+// prompts to w.
+func newScanReader(r io.Reader, w io.Writer) *scanReader {
+	return &scanReader{scanner: bufio.NewScanner(r), w: w}
+}
+
+func (s *scanReader) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(s.w, prompt)
+	if !s.scanner.Scan() {
+		if err := s.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return s.scanner.Text(), nil
+}
+
+func (s *scanReader) AddHistory(input string) {}
+
+func (s *scanReader) Close() error {
+	return nil
+}
+
+// linerReader reads lines from a terminal with line editing and recall.
+type linerReader struct {
+	line *liner.State
+}
+
+func (r *linerReader) ReadLine(prompt string) (string, error) {
+	input, err := r.line.Prompt(prompt)
+	if errors.Is(err, liner.ErrPromptAborted) {
+		return "", errAborted
+	}
+	return input, err
+}
+
+func (r *linerReader) AddHistory(input string) {
+	r.line.AppendHistory(input)
+}
+
+func (r *linerReader) Close() error {
+	return r.line.Close()
+}
+
+// defaultHistoryFile returns the path to the history file in the
+// user's home directory, or an empty string if there isn't one.
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".lisp_history")
+}
+
+// repl reads expressions from the input, evaluates them, and prints the
+// results to the output. input is read until the parentheses balance, so
+// an expression may span multiple lines. errors are reported and the loop
+// continues. it returns when the input is exhausted; input that is still
+// unbalanced at that point is reported as an error.
+// the entries in the history file, if there is one, are loaded for recall
+// before the first prompt, and each input is recalled and appended to it.
+func repl(l *lisp.Interpreter, in lineReader, w io.Writer, historyFile string) error {
+	var history io.Writer = io.Discard
+	if historyFile != "" {
+		if err := loadHistory(in, historyFile); err != nil {
+			return err
+		}
+		fp, err := os.OpenFile(historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		history = fp
+	}
+
+	var sb strings.Builder
+	for {
+		prompt := "> "
+		if sb.Len() != 0 {
+			prompt = "... "
+		}
+		line, err := in.ReadLine(prompt)
+		if errors.Is(err, errAborted) {
+			// discard the unfinished input
+			sb.Reset()
+			continue
+		} else if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+		if depth(sb.String()) > 0 {
+			// keep reading until the parentheses balance
+			continue
+		}
+
+		input := strings.TrimSpace(sb.String())
+		sb.Reset()
+		if input == "" {
+			continue
+		}
+		entry := strings.ReplaceAll(input, "\n", " ")
+		in.AddHistory(entry)
+		_, _ = fmt.Fprintln(history, entry)
+
+		for rest := []byte(input); ; {
+			expr, remainder, err := l.Read(rest)
+			if errors.Is(err, lisp.Error_EndOfInput) {
+				break
+			} else if err != nil {
+				fmt.Fprintf(w, "error: %v\n", err)
+				break
+			}
+			eval(l, w, expr)
+			rest = remainder
+		}
+	}
+	fmt.Fprintln(w)
+	if input := strings.TrimSpace(sb.String()); input != "" {
+		fmt.Fprintf(w, "error: %v: unterminated expression: %s\n", lisp.Error_EndOfInput, strings.ReplaceAll(input, "\n", " "))
+	}
+	return nil
+}
+
+// loadHistory makes the entries in the history file available for
+// recall. each line in the file is an entry. a missing file is not an
+// error, since it is created when the first input is appended.
+func loadHistory(in lineReader, historyFile string) error {
+	fp, err := os.Open(historyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		if entry := strings.TrimSpace(scanner.Text()); entry != "" {
+			in.AddHistory(entry)
+		}
+	}
+	return scanner.Err()
+}
+
+// eval evaluates an expression and prints the result to the output.
+// errors, including panics in the interpreter, are reported to the
+// output so that the REPL can continue with the next expression.
+func eval(l *lisp.Interpreter, w io.Writer, expr lisp.Atom) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(w, "error: panic: %v\n", r)
+		}
+	}()
+	if result, err := l.Eval(expr); err != nil {
+		fmt.Fprintf(w, "error: %v in expression: %s\n", err, expr.String())
+	} else {
+		fmt.Fprintln(w, result.String())
+	}
+}
+
+// depth returns the number of open parentheses in the input that have
+// not been closed. parentheses inside strings, comments and character
+// literals are ignored, and an unterminated string or block comment
+// counts as an open parenthesis.
+func depth(input string) int {
+	n, instring, comments := 0, false, 0
+	for i := 0; i < len(input); i++ {
+		switch ch := input[i]; {
+		case instring && ch == '\\':
+			i++ // skip the escaped character
+		case instring && ch == '"':
+			instring = false
+		case instring:
+			// ignore everything inside a string
+		case comments != 0 && strings.HasPrefix(input[i:], "|#"):
+			comments--
+			i++
+		case strings.HasPrefix(input[i:], "#|"):
+			// block comments nest
+			comments++
+			i++
+		case comments != 0:
+			// ignore everything inside a block comment
+		case strings.HasPrefix(input[i:], "#\\"):
+			// ignore the character after #\, which may be a paren
+			i += 2
+		case ch == '"':
+			instring = true
+		case ch == ';':
+			// ignore the rest of the line
+			if eol := strings.IndexByte(input[i:], '\n'); eol == -1 {
+				i = len(input)
+			} else {
+				i += eol
+			}
+		case ch == '(':
+			n++
+		case ch == ')':
+			n--
+		}
+	}
+	if instring || comments != 0 {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2023 Michael D Henderson. All rights reserved.
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	lisp "github.com/maloquacious/building_lisp/ch14"
+)
+
+func TestRepl(t *testing.T) {
+	l := lisp.NewInterpreter()
+	if err := l.LoadLibrary(); err != nil {
+		t.Fatalf("library: want nil: got %v\n", err)
+	}
+	historyFile := filepath.Join(t.TempDir(), "history")
+
+	input := "(define (square x)\n  (* x x))\n(square 3)\nfoo\n(list 1\n2) (+ 1 2)\n(list 3 #| ( |#\n4)\n"
+	w := &strings.Builder{}
+	if err := repl(l, newScanReader(strings.NewReader(input), w), w, historyFile); err != nil {
+		t.Fatalf("repl: want nil: got %v\n", err)
+	}
+	expect := "> ... SQUARE\n> 9\n> error: unbound: FOO in expression: FOO\n> ... (1 2)\n3\n> ... (3 4)\n> \n"
+	if got := w.String(); expect != got {
+		t.Errorf("repl: want %q: got %q\n", expect, got)
+	}
+
+	data, err := os.ReadFile(historyFile)
+	if err != nil {
+		t.Fatalf("history: want nil: got %v\n", err)
+	}
+	expect = "(define (square x)   (* x x))\n(square 3)\nfoo\n(list 1 2) (+ 1 2)\n(list 3 #| ( |# 4)\n"
+	if got := string(data); expect != got {
+		t.Errorf("history: want %q: got %q\n", expect, got)
+	}
+}
+
+// historyReader is a lineReader that records the history it is given.
+type historyReader struct {
+	lines   []string
+	history []string
+}
+
+func (h *historyReader) ReadLine(prompt string) (string, error) {
+	if len(h.lines) == 0 {
+		return "", io.EOF
+	}
+	line := h.lines[0]
+	h.lines = h.lines[1:]
+	return line, nil
+}
+
+func (h *historyReader) AddHistory(input string) {
+	h.history = append(h.history, input)
+}
+
+func (h *historyReader) Close() error {
+	return nil
+}
+
+func TestReplHistory(t *testing.T) {
+	l := lisp.NewInterpreter()
+	historyFile := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(historyFile, []byte("(define x 1)\n\n(+ x 1)\n"), 0600); err != nil {
+		t.Fatalf("history: want nil: got %v\n", err)
+	}
+
+	// the saved entries are recalled before the new input
+	in := &historyReader{lines: []string{"(list 1", "2)", "(car '(3))"}}
+	if err := repl(l, in, io.Discard, historyFile); err != nil {
+		t.Fatalf("repl: want nil: got %v\n", err)
+	}
+	expect := []string{"(define x 1)", "(+ x 1)", "(list 1 2)", "(car '(3))"}
+	if got := in.history; strings.Join(expect, "|") != strings.Join(got, "|") {
+		t.Errorf("recall: want %q: got %q\n", expect, got)
+	}
+
+	// the next session recalls the entries from both sessions
+	in = &historyReader{}
+	if err := repl(l, in, io.Discard, historyFile); err != nil {
+		t.Fatalf("repl: want nil: got %v\n", err)
+	}
+	if got := in.history; strings.Join(expect, "|") != strings.Join(got, "|") {
+		t.Errorf("reload: want %q: got %q\n", expect, got)
+	}
+
+	// a missing history file is created
+	historyFile = filepath.Join(t.TempDir(), "missing")
+	if err := repl(l, &historyReader{lines: []string{"1"}}, io.Discard, historyFile); err != nil {
+		t.Fatalf("missing: want nil: got %v\n", err)
+	} else if data, err := os.ReadFile(historyFile); err != nil || string(data) != "1\n" {
+		t.Errorf("missing: want %q: got %q %v\n", "1\n", string(data), err)
+	}
+}
+
+func TestReplClosures(t *testing.T) {
+	l := lisp.NewInterpreter()
+	if err := l.LoadLibrary(); err != nil {
+		t.Fatalf("library: want nil: got %v\n", err)
+	}
+
+	input := "(lambda (x) x)\n(define f (lambda (x) x))\nf\n(defmacro (m x) x)\nm\n(f 1)\n"
+	w := &strings.Builder{}
+	if err := repl(l, newScanReader(strings.NewReader(input), w), w, ""); err != nil {
+		t.Fatalf("repl: want nil: got %v\n", err)
+	}
+	expect := "> #<CLOSURE>\n> F\n> #<CLOSURE>\n> M\n> #<MACRO>\n> 1\n> \n"
+	if got := w.String(); expect != got {
+		t.Errorf("repl: want %q: got %q\n", expect, got)
+	}
+}
+
+func TestReplUnterminated(t *testing.T) {
+	l := lisp.NewInterpreter()
+	input := "(+ 1 2)\n(list 1\n2\n"
+	w := &strings.Builder{}
+	if err := repl(l, newScanReader(strings.NewReader(input), w), w, ""); err != nil {
+		t.Fatalf("repl: want nil: got %v\n", err)
+	}
+	expect := "> 3\n> ... ... \nerror: eof: unterminated expression: (list 1 2\n"
+	if got := w.String(); expect != got {
+		t.Errorf("repl: want %q: got %q\n", expect, got)
+	}
+}
+
+func TestDepth(t *testing.T) {
+	for _, tc := range []struct {
+		input  string
+		expect int
+	}{
+		{"", 0},
+		{"(foo", 1},
+		{"(foo (bar)", 1},
+		{"(foo (bar))", 0},
+		{")", -1},
+		{`(foo "(")`, 0},
+		{`(foo "\"(")`, 0},
+		{`"abc`, 1},
+		{`("abc`, 2},
+		{"(foo ; (bar\n", 1},
+		{"(foo ; )\n)", 0},
+		{"#| ( |# (foo", 1},
+		{"#| #| |# ) |# (", 1},
+		{"(foo #| bar", 2},
+		{`(foo ";" (`, 2},
+		{`(foo #\( #\" #\;)`, 0},
+	} {
+		if got := depth(tc.input); tc.expect != got {
+			t.Errorf("%q: want %d: got %d\n", tc.input, tc.expect, got)
+		}
+	}
+}